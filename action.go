@@ -16,6 +16,8 @@ const (
 	Delete Action = "delete"
 	// CRUD is for, create+read+update+delete permissions
 	CRUD Action = "crud"
+	// AnyAction is wildcard action, matches all actions of a permission
+	AnyAction Action = "*"
 	// Download is for downloading action
 	Download = "download"
 	// Upload is for uploading action
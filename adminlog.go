@@ -0,0 +1,419 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type actorKey struct{}
+
+type actor struct {
+	id     string
+	reason string
+}
+
+// WithActor returns a copy of ctx carrying actor and reason of administrative changes made with it, see
+// PermitContext
+func WithActor(ctx context.Context, actorID, reason string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor{actorID, reason})
+}
+
+// ActorFromContext returns actor and reason carried by ctx, they are empty if ctx does not carry them
+func ActorFromContext(ctx context.Context) (actorID, reason string) {
+	if a, ok := ctx.Value(actorKey{}).(actor); ok {
+		return a.id, a.reason
+	}
+	return "", ""
+}
+
+// Change is an administrative change recorded to admin log
+type Change struct {
+	Revision   uint64    `json:"revision"`
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor,omitempty"`
+	Reason     string    `json:"reason,omitempty"`
+	Type       EventType `json:"type"`
+	Domain     string    `json:"domain,omitempty"`
+	RoleID     string    `json:"role_id,omitempty"`
+	ParentID   string    `json:"parent_id,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	Permission string    `json:"permission,omitempty"`
+	Actions    []Action  `json:"actions,omitempty"`
+	// Condition, Instance and Schedule are set like in Event
+	Condition string    `json:"condition,omitempty"`
+	Instance  string    `json:"instance,omitempty"`
+	Schedule  *Schedule `json:"schedule,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+	// Before and After are state of the role before and after the change, nil if role does not exist or the change
+	// is not a change of a role
+	Before *RoleGrants `json:"before,omitempty"`
+	After  *RoleGrants `json:"after,omitempty"`
+	// PolicyBefore and PolicyAfter are state of the subject or the constraints before and after the change, nil if
+	// the change is a change of a role
+	PolicyBefore *PolicyState `json:"policy_before,omitempty"`
+	PolicyAfter  *PolicyState `json:"policy_after,omitempty"`
+}
+
+// PolicyState is state of a subject or instance wide constraints recorded to admin log, only the part touched by
+// the change is set
+type PolicyState struct {
+	SubjectRoles []string           `json:"subject_roles,omitempty"`
+	SoD          []*SoDConstraint   `json:"sod,omitempty"`
+	Implications []*Implication     `json:"implications,omitempty"`
+	Composites   []*CompositeAction `json:"composites,omitempty"`
+}
+
+// ChangeFilter filters changes of admin log, zero fields match all changes
+type ChangeFilter struct {
+	Actor      string
+	RoleID     string
+	Subject    string
+	Permission string
+	// Since is inclusive and Until is exclusive
+	Since time.Time
+	Until time.Time
+}
+
+// adminLog is an append-only log of administrative changes
+type adminLog struct {
+	// mu serializes recorded changes, so before and after states belong to the same change
+	mu      sync.Mutex
+	changes []*Change
+	enc     *json.Encoder
+}
+
+// EnableAdminLog enables recording of administrative changes with their actor, reason and before and after state
+// of the role. Changes are kept in memory and written to w as JSON lines if it is not nil. Changes made by
+// LoadJSON are recorded as a single bulk_load change.
+func (r *RBAC) EnableAdminLog(w io.Writer) {
+	l := &adminLog{}
+	if w != nil {
+		l.enc = json.NewEncoder(w)
+	}
+	r.adminLog.Store(l)
+}
+
+// AdminLog returns copies of changes matching filter in order of revisions
+func (r *RBAC) AdminLog(filter ChangeFilter) []*Change {
+	res := []*Change{}
+	l, _ := r.adminLog.Load().(*adminLog)
+	if l == nil {
+		return res
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, c := range l.changes {
+		if filter.match(c) {
+			cc := *c
+			res = append(res, &cc)
+		}
+	}
+	return res
+}
+
+func (f *ChangeFilter) match(c *Change) bool {
+	return (f.Actor == "" || c.Actor == f.Actor) &&
+		(f.RoleID == "" || c.RoleID == f.RoleID) &&
+		(f.Subject == "" || c.Subject == f.Subject) &&
+		(f.Permission == "" || c.Permission == f.Permission) &&
+		(f.Since.IsZero() || !c.Time.Before(f.Since)) &&
+		(f.Until.IsZero() || c.Time.Before(f.Until))
+}
+
+// record calls fn which makes change c, then appends c to admin log with actor and reason carried by ctx if admin
+// log is enabled. Nothing is recorded if fn fails or ctx is of a mutation made by LoadJSON.
+func (r *RBAC) record(ctx context.Context, c *Change, fn func() error) error {
+	return r.recordPolicy(ctx, c, nil, fn)
+}
+
+// recordPolicy is same as record, state returned by state is recorded as policy state instead of the role state if
+// state is not nil
+func (r *RBAC) recordPolicy(ctx context.Context, c *Change, state func() *PolicyState, fn func() error) error {
+	defer r.lockMutation(ctx)()
+	l, _ := r.adminLog.Load().(*adminLog)
+	if l == nil || isLoading(ctx) {
+		return fn()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if state != nil {
+		c.PolicyBefore = state()
+	} else {
+		c.Before = r.roleState(c.Domain, c.RoleID)
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	if state != nil {
+		c.PolicyAfter = state()
+	} else {
+		c.After = r.roleState(c.Domain, c.RoleID)
+	}
+	l.append(r, ctx, c)
+	return nil
+}
+
+// append appends c to admin log, l.mu should be held
+func (l *adminLog) append(r *RBAC, ctx context.Context, c *Change) {
+	c.Actor, c.Reason = ActorFromContext(ctx)
+	c.Time = r.now()
+	c.Revision = r.Revision()
+	l.changes = append(l.changes, c)
+	if l.enc != nil {
+		if err := l.enc.Encode(c); err != nil {
+			log.Errorf("can not write %s change of role %s to admin log, err: %v", c.Type, c.RoleID, err)
+		}
+	}
+}
+
+// recordBulkLoad appends a bulk_load change to admin log if it is enabled
+func (r *RBAC) recordBulkLoad() {
+	if l, _ := r.adminLog.Load().(*adminLog); l != nil {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.append(r, context.Background(), &Change{Type: EventBulkLoad})
+	}
+}
+
+// roleState returns grants of role registered in domain, nil if it is not registered
+func (r *RBAC) roleState(domain, roleID string) *RoleGrants {
+	if roleID == "" {
+		return nil
+	}
+	role := r.roleIn(domain, roleID)
+	if role == nil {
+		return nil
+	}
+	return r.roleGrants([]*Role{role})[0]
+}
+
+// subjectState returns a function returning roles of subject as policy state
+func (r *RBAC) subjectState(subjectID string) func() *PolicyState {
+	return func() *PolicyState {
+		return &PolicyState{SubjectRoles: r.SubjectRoles(subjectID)}
+	}
+}
+
+// sodState returns separation of duty constraints as policy state
+func (r *RBAC) sodState() *PolicyState {
+	return &PolicyState{SoD: r.SoDConstraints()}
+}
+
+// implicationState returns implications as policy state
+func (r *RBAC) implicationState() *PolicyState {
+	return &PolicyState{Implications: r.Implications()}
+}
+
+// compositeState returns composite actions as policy state
+func (r *RBAC) compositeState() *PolicyState {
+	return &PolicyState{Composites: r.CompositeActions()}
+}
+
+// RegisterPermissionContext is same as RegisterPermission, change is recorded to admin log with actor and reason
+// carried by ctx
+func (r *RBAC) RegisterPermissionContext(ctx context.Context, permissionID, description string, actions ...Action) (perm *Permission, err error) {
+	err = r.record(ctx, &Change{Type: EventPermissionRegistered, Permission: permissionID}, func() error {
+		perm, err = r.registerPermission(ctx, permissionID, description, actions...)
+		return err
+	})
+	return perm, err
+}
+
+// RegisterRoleContext is same as RegisterRole, change is recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) RegisterRoleContext(ctx context.Context, roleID string, description string) (role *Role, err error) {
+	err = r.record(ctx, &Change{Type: EventRoleRegistered, RoleID: roleID}, func() error {
+		role, err = r.registerRole(ctx, roleID, description)
+		return err
+	})
+	return role, err
+}
+
+// RemoveRoleContext is same as RemoveRole, change is recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) RemoveRoleContext(ctx context.Context, roleID string) error {
+	return r.record(ctx, &Change{Type: EventRoleRemoved, RoleID: roleID}, func() error {
+		return r.removeRole(ctx, roleID)
+	})
+}
+
+// PermitContext is same as Permit, change is recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) PermitContext(ctx context.Context, roleID string, perm *Permission, actions ...Action) error {
+	return r.permitIn(ctx, "", roleID, perm, actions...)
+}
+
+// RevokeContext is same as Revoke, change is recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) RevokeContext(ctx context.Context, roleID string, perm *Permission, actions ...Action) error {
+	return r.revokeIn(ctx, "", roleID, perm, actions...)
+}
+
+// DenyContext is same as Deny, change is recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) DenyContext(ctx context.Context, roleID string, perm *Permission, actions ...Action) error {
+	return r.denyIn(ctx, "", roleID, perm, actions...)
+}
+
+// UndenyContext is same as Undeny, change is recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) UndenyContext(ctx context.Context, roleID string, perm *Permission, actions ...Action) error {
+	return r.undenyIn(ctx, "", roleID, perm, actions...)
+}
+
+// AddParentContext adds parent role to a role like Role.AddParent, change is recorded to admin log with actor and
+// reason carried by ctx
+func (r *RBAC) AddParentContext(ctx context.Context, roleID, parentID string) error {
+	role, parent, err := r.rolePair(roleID, parentID)
+	if err != nil {
+		return err
+	}
+	return role.addParentContext(ctx, parent)
+}
+
+// RemoveParentContext removes parent role from a role like Role.RemoveParent, change is recorded to admin log with
+// actor and reason carried by ctx
+func (r *RBAC) RemoveParentContext(ctx context.Context, roleID, parentID string) error {
+	role, parent, err := r.rolePair(roleID, parentID)
+	if err != nil {
+		return err
+	}
+	return role.removeParentContext(ctx, parent)
+}
+
+// rolePair returns global role and parent role
+func (r *RBAC) rolePair(roleID, parentID string) (*Role, *Role, error) {
+	role := r.GetRole(roleID)
+	if role == nil {
+		return nil, nil, fmt.Errorf("role %s is not registered", roleID)
+	}
+	parent := r.GetRole(parentID)
+	if parent == nil {
+		return nil, nil, fmt.Errorf("role %s is not registered", parentID)
+	}
+	return role, parent, nil
+}
+
+// PermitIfContext is same as PermitIf, change is recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) PermitIfContext(ctx context.Context, roleID string, perm *Permission, condition string, actions ...Action) error {
+	return r.permitIf(ctx, "", roleID, perm, condition, actions...)
+}
+
+// RevokeIfContext is same as RevokeIf, change is recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) RevokeIfContext(ctx context.Context, roleID string, perm *Permission, condition string, actions ...Action) error {
+	return r.revokeIfIn(ctx, "", roleID, perm, condition, actions...)
+}
+
+// PermitInstanceContext is same as PermitInstance, change is recorded to admin log with actor and reason carried
+// by ctx
+func (r *RBAC) PermitInstanceContext(ctx context.Context, roleID string, perm *Permission, instanceID string, actions ...Action) error {
+	return r.permitInstance(ctx, "", roleID, perm, instanceID, actions...)
+}
+
+// RevokeInstanceContext is same as RevokeInstance, change is recorded to admin log with actor and reason carried
+// by ctx
+func (r *RBAC) RevokeInstanceContext(ctx context.Context, roleID string, perm *Permission, instanceID string, actions ...Action) error {
+	return r.revokeInstanceIn(ctx, "", roleID, perm, instanceID, actions...)
+}
+
+// PermitScheduledContext is same as PermitScheduled, change is recorded to admin log with actor and reason carried
+// by ctx
+func (r *RBAC) PermitScheduledContext(ctx context.Context, roleID string, perm *Permission, schedule *Schedule, actions ...Action) error {
+	return r.permitScheduled(ctx, "", roleID, perm, schedule, actions...)
+}
+
+// RevokeScheduledContext is same as RevokeScheduled, change is recorded to admin log with actor and reason carried
+// by ctx
+func (r *RBAC) RevokeScheduledContext(ctx context.Context, roleID string, perm *Permission, actions ...Action) error {
+	return r.revokeScheduledIn(ctx, "", roleID, perm, actions...)
+}
+
+// AssignRoleContext is same as AssignRole, change is recorded to admin log with roles of subject before and after
+// the change
+func (r *RBAC) AssignRoleContext(ctx context.Context, subjectID, roleID string) error {
+	c := &Change{Type: EventRoleAssigned, RoleID: roleID, Subject: subjectID}
+	return r.recordPolicy(ctx, c, r.subjectState(subjectID), func() error {
+		return r.assignRole(ctx, subjectID, roleID)
+	})
+}
+
+// UnassignRoleContext is same as UnassignRole, change is recorded to admin log with roles of subject before and
+// after the change
+func (r *RBAC) UnassignRoleContext(ctx context.Context, subjectID, roleID string) error {
+	c := &Change{Type: EventRoleUnassigned, RoleID: roleID, Subject: subjectID}
+	return r.recordPolicy(ctx, c, r.subjectState(subjectID), func() error {
+		return r.unassignRoleChecked(ctx, subjectID, roleID)
+	})
+}
+
+// SetRoleCardinalityContext is same as SetRoleCardinality, change is recorded to admin log with actor and reason
+// carried by ctx
+func (r *RBAC) SetRoleCardinalityContext(ctx context.Context, roleID string, min, max int) error {
+	c := &Change{Type: EventPolicyChanged, RoleID: roleID, Detail: "cardinality set"}
+	return r.record(ctx, c, func() error {
+		return r.setRoleCardinality(ctx, roleID, min, max)
+	})
+}
+
+// AddStaticSoDContext is same as AddStaticSoD, change is recorded to admin log with constraints before and after
+// the change
+func (r *RBAC) AddStaticSoDContext(ctx context.Context, id string, roleIDs ...string) error {
+	return r.addSoD(ctx, &SoDConstraint{ID: id, Roles: roleIDs})
+}
+
+// AddDynamicSoDContext is same as AddDynamicSoD, change is recorded to admin log with constraints before and after
+// the change
+func (r *RBAC) AddDynamicSoDContext(ctx context.Context, id string, roleIDs ...string) error {
+	return r.addSoD(ctx, &SoDConstraint{ID: id, Roles: roleIDs, Dynamic: true})
+}
+
+// RemoveSoDContext is same as RemoveSoD, change is recorded to admin log with constraints before and after the
+// change
+func (r *RBAC) RemoveSoDContext(ctx context.Context, id string) error {
+	c := &Change{Type: EventPolicyChanged, Detail: "separation of duty constraint " + id + " removed"}
+	return r.recordPolicy(ctx, c, r.sodState, func() error {
+		return r.removeSoD(ctx, id)
+	})
+}
+
+// AddImplicationContext is same as AddImplication, change is recorded to admin log with implications before and
+// after the change
+func (r *RBAC) AddImplicationContext(ctx context.Context, action, implied Action) error {
+	return r.addImplication(ctx, "", action, implied)
+}
+
+// AddPermissionImplicationContext is same as AddPermissionImplication, change is recorded to admin log with
+// implications before and after the change
+func (r *RBAC) AddPermissionImplicationContext(ctx context.Context, perm *Permission, action, implied Action) error {
+	if perm == nil {
+		log.Errorf("nil perm is sent for adding implication %s -> %s", action, implied)
+		return fmt.Errorf("permission can not be nil")
+	}
+	if err := r.validateActions(perm, action, implied); err != nil {
+		return err
+	}
+	return r.addImplication(ctx, perm.ID, action, implied)
+}
+
+// RemoveImplicationContext is same as RemoveImplication, change is recorded to admin log with implications before
+// and after the change
+func (r *RBAC) RemoveImplicationContext(ctx context.Context, action, implied Action) error {
+	return r.removeImplication(ctx, "", action, implied)
+}
+
+// RemovePermissionImplicationContext is same as RemovePermissionImplication, change is recorded to admin log with
+// implications before and after the change
+func (r *RBAC) RemovePermissionImplicationContext(ctx context.Context, perm *Permission, action, implied Action) error {
+	if perm == nil {
+		log.Errorf("nil perm is sent for removing implication %s -> %s", action, implied)
+		return fmt.Errorf("permission can not be nil")
+	}
+	return r.removeImplication(ctx, perm.ID, action, implied)
+}
+
+// RegisterCompositeActionContext is same as RegisterCompositeAction, change is recorded to admin log with
+// composite actions before and after the change
+func (r *RBAC) RegisterCompositeActionContext(ctx context.Context, action Action, actions ...Action) error {
+	c := &Change{Type: EventPolicyChanged, Actions: []Action{action}, Detail: "composite action " + string(action) + " registered"}
+	return r.recordPolicy(ctx, c, r.compositeState, func() error {
+		return r.registerCompositeAction(ctx, action, actions...)
+	})
+}
@@ -0,0 +1,236 @@
+package rbac
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdminLog(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	R.SetClock(func() time.Time { return now })
+	var buf bytes.Buffer
+	R.EnableAdminLog(&buf)
+
+	alice := WithActor(context.Background(), "alice", "onboarding")
+	bob := WithActor(context.Background(), "bob", "cleanup")
+	usersPerm, err := R.RegisterPermissionContext(alice, "users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	if _, err = R.RegisterRoleContext(alice, "user", "User role"); err != nil {
+		t.Fatalf("can not register user role, err: %v", err)
+	}
+	R.RegisterRole("admin", "Admin role")
+	if err = R.PermitContext(alice, "user", usersPerm, Read); err != nil {
+		t.Fatalf("can not permit read to user, err: %v", err)
+	}
+	if err = R.AddParentContext(alice, "admin", "user"); err != nil {
+		t.Fatalf("can not add parent to admin, err: %v", err)
+	}
+	now = now.Add(time.Hour)
+	if err = R.PermitContext(bob, "user", usersPerm, "unknown"); err == nil {
+		t.Fatalf("permitting unknown action should fail")
+	}
+	R.RevokeContext(bob, "user", usersPerm, Read)
+	R.RemoveRoleContext(bob, "admin")
+
+	all := R.AdminLog(ChangeFilter{})
+	types := []EventType{EventPermissionRegistered, EventRoleRegistered, EventRoleRegistered, EventPermit,
+		EventParentAdded, EventRevoke, EventRoleRemoved}
+	if len(all) != len(types) {
+		t.Fatalf("failed changes should not be recorded, expected %d changes, got %d", len(types), len(all))
+	}
+	for i, c := range all {
+		if c.Type != types[i] {
+			t.Fatalf("change %d should be %s, got %s", i, types[i], c.Type)
+		}
+		if i > 0 && c.Revision <= all[i-1].Revision {
+			t.Fatalf("change revisions should increase")
+		}
+	}
+	if all[2].Actor != "" {
+		t.Fatalf("changes without actor in context should be recorded with empty actor")
+	}
+
+	permit := all[3]
+	if permit.Actor != "alice" || permit.Reason != "onboarding" || permit.Permission != "users" {
+		t.Fatalf("permit change is not valid: %+v", permit)
+	}
+	if len(permit.Before.Grants) != 0 || len(permit.After.Grants["users"]) != 1 || permit.After.Grants["users"][0] != Read {
+		t.Fatalf("before and after state of permit are not valid: %+v, %+v", permit.Before, permit.After)
+	}
+	removal := all[6]
+	if removal.Before == nil || len(removal.Before.Parents) != 1 || removal.After != nil {
+		t.Fatalf("before and after state of role removal are not valid: %+v, %+v", removal.Before, removal.After)
+	}
+
+	if res := R.AdminLog(ChangeFilter{Actor: "bob"}); len(res) != 2 {
+		t.Fatalf("bob should have 2 changes, got %d", len(res))
+	}
+	if res := R.AdminLog(ChangeFilter{RoleID: "user", Permission: "users"}); len(res) != 2 {
+		t.Fatalf("user role should have 2 changes of users permission, got %d", len(res))
+	}
+	start := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	if res := R.AdminLog(ChangeFilter{Since: start, Until: start.Add(time.Hour)}); len(res) != 5 {
+		t.Fatalf("5 changes should be in the first hour, got %d", len(res))
+	}
+	if res := R.AdminLog(ChangeFilter{Since: start.Add(time.Hour)}); len(res) != 2 {
+		t.Fatalf("2 changes should be after the first hour, got %d", len(res))
+	}
+
+	all[0].Actor = "mallory"
+	if R.AdminLog(ChangeFilter{})[0].Actor != "alice" {
+		t.Fatalf("admin log should not be modified by callers")
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != len(types) {
+		t.Fatalf("each change should be written as a line, got %d lines", len(lines))
+	}
+}
+
+func TestAdminLogPolicy(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	R.EnableAdminLog(nil)
+	ctx := WithActor(context.Background(), "alice", "ticket #42")
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD, "approve")
+	R.RegisterRole("user", "User role")
+	R.RegisterRole("admin", "Admin role")
+	R.RegisterCondition("owner", func(ctx context.Context, req *Request) bool { return true })
+
+	if err := R.AssignRoleContext(ctx, "bob", "user"); err != nil {
+		t.Fatalf("can not assign user role to bob, err: %v", err)
+	}
+	if err := R.SetRoleCardinalityContext(ctx, "admin", 0, 1); err != nil {
+		t.Fatalf("can not set cardinality of admin, err: %v", err)
+	}
+	if err := R.AddStaticSoDContext(ctx, "exclusive", "user", "admin"); err != nil {
+		t.Fatalf("can not add static separation of duty, err: %v", err)
+	}
+	if err := R.AddImplicationContext(ctx, Update, Read); err != nil {
+		t.Fatalf("can not add implication, err: %v", err)
+	}
+	if err := R.RegisterCompositeActionContext(ctx, "write", Create, Update); err != nil {
+		t.Fatalf("can not register composite action, err: %v", err)
+	}
+	if err := R.PermitIfContext(ctx, "user", usersPerm, "owner", Update); err != nil {
+		t.Fatalf("can not permit conditionally, err: %v", err)
+	}
+	if err := R.PermitInstanceContext(ctx, "user", usersPerm, "42", Delete); err != nil {
+		t.Fatalf("can not permit instance, err: %v", err)
+	}
+	if err := R.PermitScheduledContext(ctx, "user", usersPerm, &Schedule{}, "approve"); err != nil {
+		t.Fatalf("can not permit scheduled, err: %v", err)
+	}
+	if err := R.UnassignRoleContext(ctx, "bob", "user"); err != nil {
+		t.Fatalf("can not unassign user role from bob, err: %v", err)
+	}
+	if err := R.AssignRoleContext(ctx, "bob", "nope"); err == nil {
+		t.Fatalf("assigning unknown role should fail")
+	}
+
+	changes := R.AdminLog(ChangeFilter{Actor: "alice"})
+	if len(changes) != 9 {
+		t.Fatalf("all changes with context should be recorded, expected 9 changes, got %d", len(changes))
+	}
+	assign, unassign := changes[0], changes[8]
+	if assign.Type != EventRoleAssigned || assign.Subject != "bob" || assign.Reason != "ticket #42" ||
+		len(assign.PolicyBefore.SubjectRoles) != 0 || len(assign.PolicyAfter.SubjectRoles) != 1 {
+		t.Fatalf("role assignment change is not valid: %+v", assign)
+	}
+	if unassign.Type != EventRoleUnassigned || len(unassign.PolicyBefore.SubjectRoles) != 1 || len(unassign.PolicyAfter.SubjectRoles) != 0 {
+		t.Fatalf("role unassignment change is not valid: %+v", unassign)
+	}
+	if c := changes[1]; c.Before.Cardinality != nil || c.After.Cardinality == nil || c.After.Cardinality.Max != 1 {
+		t.Fatalf("before and after state of cardinality change are not valid: %+v, %+v", c.Before, c.After)
+	}
+	if c := changes[2]; len(c.PolicyBefore.SoD) != 0 || len(c.PolicyAfter.SoD) != 1 {
+		t.Fatalf("before and after state of separation of duty change are not valid: %+v", c)
+	}
+	if c := changes[3]; len(c.PolicyBefore.Implications) != 0 || len(c.PolicyAfter.Implications) != 1 {
+		t.Fatalf("before and after state of implication change are not valid: %+v", c)
+	}
+	if c := changes[4]; len(c.PolicyAfter.Composites) != len(c.PolicyBefore.Composites)+1 {
+		t.Fatalf("before and after state of composite action change are not valid: %+v", c)
+	}
+	if c := changes[5]; c.Type != EventPermit || len(c.Before.Conditional) != 0 || len(c.After.Conditional) != 1 {
+		t.Fatalf("before and after state of conditional permit are not valid: %+v", c)
+	}
+	if c := changes[6]; len(c.Before.Instances) != 0 || len(c.After.Instances["users"]["42"]) != 1 {
+		t.Fatalf("before and after state of instance permit are not valid: %+v", c)
+	}
+	if c := changes[7]; len(c.Before.Scheduled) != 0 || len(c.After.Scheduled) != 1 {
+		t.Fatalf("before and after state of scheduled permit are not valid: %+v", c)
+	}
+	if res := R.AdminLog(ChangeFilter{Subject: "bob"}); len(res) != 2 {
+		t.Fatalf("bob should have 2 changes as a subject, got %d", len(res))
+	}
+}
+
+func TestAdminLogDuringLoad(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	R.EnableAdminLog(nil)
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterRole("user", "User role")
+	ctx := WithActor(context.Background(), "alice", "ticket #42")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			doc := fmt.Sprintf(`{"roles": [{"id": "role%d", "grants": {"users": ["read"]}, "parents": []}]}`, i)
+			R.LoadJSON(strings.NewReader(doc))
+		}(i)
+	}
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			R.PermitContext(ctx, "user", usersPerm, Read)
+		}()
+	}
+	wg.Wait()
+
+	if res := R.AdminLog(ChangeFilter{Actor: "alice"}); len(res) != 200 {
+		t.Fatalf("changes concurrent with loading should be recorded, expected 200 changes, got %d", len(res))
+	}
+	if res := R.AdminLog(ChangeFilter{RoleID: "role0"}); len(res) != 0 {
+		t.Fatalf("changes made by loading should be recorded as a bulk load only")
+	}
+}
+
+func TestAdminLogPatch(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	R.EnableAdminLog(nil)
+	R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterPermission("reports", "Report resource", Read)
+
+	B := New(nil)
+	B.RegisterPermission("users", "Users", CRUD, "approve")
+
+	ctx := WithActor(context.Background(), "alice", "ticket #42")
+	if err := R.ApplyPatchContext(ctx, Diff(R, B)); err != nil {
+		t.Fatalf("can not apply patch, err: %v", err)
+	}
+	res := R.AdminLog(ChangeFilter{Actor: "alice"})
+	details := []string{"permission description is updated", "actions are added", "permission is removed"}
+	if len(res) != len(details) {
+		t.Fatalf("permission changes of patch should be recorded, expected %d changes, got %d", len(details), len(res))
+	}
+	for i, c := range res {
+		if c.Detail != details[i] || c.Type != EventPolicyChanged {
+			t.Fatalf("change %d should be %s, got %+v", i, details[i], c)
+		}
+	}
+	if res[1].Permission != "users" || len(res[1].Actions) != 1 || res[1].Actions[0] != "approve" {
+		t.Fatalf("added actions should be recorded with their permission, got %+v", res[1])
+	}
+	if res[2].Permission != "reports" {
+		t.Fatalf("removed permission should be recorded, got %+v", res[2])
+	}
+}
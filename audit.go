@@ -0,0 +1,226 @@
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Decision is an access decision recorded to an AuditSink
+type Decision struct {
+	Time time.Time `json:"time"`
+	// Check is the name of the check method, like IsGranted or AnyGrantInherited
+	Check      string   `json:"check"`
+	Domain     string   `json:"domain,omitempty"`
+	Subject    string   `json:"subject,omitempty"`
+	Roles      []string `json:"roles"`
+	Permission string   `json:"permission"`
+	Actions    []Action `json:"actions"`
+	Instance   string   `json:"instance,omitempty"`
+	Allowed    bool     `json:"allowed"`
+	// Reason describes why access is allowed or denied, like the inheritance chain of the grant
+	Reason string `json:"reason"`
+}
+
+// AuditSink records access decisions. Record is called synchronously from checks which may run concurrently, so it
+// should be fast and safe for concurrent use. Errors are logged.
+type AuditSink interface {
+	Record(d *Decision) error
+}
+
+// AuditOptions are options of decision auditing
+type AuditOptions struct {
+	// DenyOnly records denied decisions only
+	DenyOnly bool
+	// SampleRate is the fraction of decisions recorded, between 0 and 1. Zero records all decisions. Sampling is
+	// deterministic, exactly one of each 1/SampleRate decisions is recorded.
+	SampleRate float64
+}
+
+type auditor struct {
+	n    uint64 // number of decisions passed DenyOnly, kept first for 64-bit alignment of atomic access
+	sink AuditSink
+	opts AuditOptions
+}
+
+// SetAuditSink sets sink which IsGranted*, AnyGranted*, AllGranted*, AnyGrantInherited*, AllGrantInherited*,
+// IsSubjectGranted*, IsInstanceGranted, AnyInstanceGranted, Check, CheckRoles and Session.IsGranted decisions are
+// recorded to, nil sink disables auditing. Checks of compiled snapshots are not recorded.
+func (r *RBAC) SetAuditSink(sink AuditSink, opts AuditOptions) error {
+	if opts.SampleRate < 0 || opts.SampleRate > 1 {
+		log.Errorf("audit sample rate %v should be between 0 and 1", opts.SampleRate)
+		return fmt.Errorf("audit sample rate %v should be between 0 and 1", opts.SampleRate)
+	}
+	if sink == nil {
+		r.auditor.Store((*auditor)(nil))
+		return nil
+	}
+	r.auditor.Store(&auditor{sink: sink, opts: opts})
+	return nil
+}
+
+// sampled checks if a decision should be recorded
+func (a *auditor) sampled(allowed bool) bool {
+	if a.opts.DenyOnly && allowed {
+		return false
+	}
+	n := atomic.AddUint64(&a.n, 1)
+	if a.opts.SampleRate == 0 || a.opts.SampleRate == 1 {
+		return true
+	}
+	return uint64(float64(n)*a.opts.SampleRate) != uint64(float64(n-1)*a.opts.SampleRate)
+}
+
+// decide evaluates check fn while no transaction is being committed, then records the decision described by d to
+// audit sink if it is set and decision is sampled. d is only called when decision is recorded, so checks do not
+// allocate for auditing when it is disabled. fn must not call checks or user code which may call them, a nested
+// read lock blocks behind a waiting commit.
+func (r *RBAC) decide(fn func() bool, d func() *Decision) bool {
+	r.txMu.RLock()
+	allowed := fn()
+	a, _ := r.auditor.Load().(*auditor)
+	if a == nil || !a.sampled(allowed) {
+		r.txMu.RUnlock()
+		return allowed
+	}
+	dec := d()
+	dec.Time = r.now()
+	dec.Allowed = allowed
+	dec.Reason = r.decisionReason(dec)
+	r.txMu.RUnlock()
+	if err := a.sink.Record(dec); err != nil {
+		log.Errorf("can not record %s decision for roles %v, err: %v", dec.Check, dec.Roles, err)
+	}
+	return allowed
+}
+
+// decisionReason describes why decision is allowed or denied
+func (r *RBAC) decisionReason(d *Decision) string {
+	if !d.Allowed && len(d.Roles) > 1 {
+		if err := r.checkSoD(r.effectiveRoleIDs(d.Domain, d.Roles), true); err != nil {
+			return err.Error()
+		}
+	}
+	roles := []*Role{}
+	for _, roleID := range d.Roles {
+		if role := r.resolveRole(d.Domain, roleID); role != nil {
+			roles = append(roles, role)
+		}
+	}
+	if len(roles) == 0 {
+		return fmt.Sprintf("roles %v are not found", d.Roles)
+	}
+	actions := r.expandComposites(d.Actions)
+	if !d.Allowed {
+		for _, a := range actions {
+			if !r.IsPermissionExist(d.Permission, a) {
+				return fmt.Sprintf("action %s is not registered for permission %s", a, d.Permission)
+			}
+		}
+	}
+	q := r.newQuery(d.Permission, actions...)
+	q.instance = d.Instance
+	for i, a := range q.actions {
+		granted := false
+		for _, role := range roles {
+			if chain := role.denyChain(q.perms, a); chain != nil {
+				if !d.Allowed {
+					return fmt.Sprintf("action %s is denied by %s", a, strings.Join(chain, " -> "))
+				}
+				continue
+			}
+			if chain := role.grantChain(q, i); chain != nil {
+				if d.Allowed {
+					return fmt.Sprintf("granted by %s", strings.Join(chain, " -> "))
+				}
+				granted = true
+			}
+		}
+		if !granted && !d.Allowed {
+			return fmt.Sprintf("action %s is not granted", a)
+		}
+	}
+	if d.Allowed {
+		return "granted"
+	}
+	return "not granted"
+}
+
+// MemoryAuditSink keeps decisions in memory, it is useful for tests
+type MemoryAuditSink struct {
+	mu        sync.Mutex
+	decisions []*Decision
+}
+
+// NewMemoryAuditSink returns a new MemoryAuditSink
+func NewMemoryAuditSink() *MemoryAuditSink {
+	return &MemoryAuditSink{}
+}
+
+// Record appends decision
+func (s *MemoryAuditSink) Record(d *Decision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decisions = append(s.decisions, d)
+	return nil
+}
+
+// Decisions returns recorded decisions in order
+func (s *MemoryAuditSink) Decisions() []*Decision {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*Decision{}, s.decisions...)
+}
+
+// Reset removes recorded decisions
+func (s *MemoryAuditSink) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.decisions = nil
+}
+
+// JSONLinesAuditSink writes each decision as a JSON object on its own line
+type JSONLinesAuditSink struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+// NewJSONLinesAuditSink returns a new JSONLinesAuditSink writing to w
+func NewJSONLinesAuditSink(w io.Writer) *JSONLinesAuditSink {
+	return &JSONLinesAuditSink{enc: json.NewEncoder(w)}
+}
+
+// NewFileAuditSink returns a new JSONLinesAuditSink appending to file at path, file is created if it does not exist
+func NewFileAuditSink(path string) (*JSONLinesAuditSink, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Errorf("can not open audit file %s, err: %v", path, err)
+		return nil, err
+	}
+	s := NewJSONLinesAuditSink(f)
+	s.closer = f
+	return s, nil
+}
+
+// Record writes decision as a line
+func (s *JSONLinesAuditSink) Record(d *Decision) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(d)
+}
+
+// Close closes the file if sink is created by NewFileAuditSink
+func (s *JSONLinesAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
@@ -0,0 +1,133 @@
+package rbac
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAudit(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	now := time.Date(2019, 1, 1, 12, 0, 0, 0, time.UTC)
+	R.SetClock(func() time.Time { return now })
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	userRole, _ := R.RegisterRole("user", "User role")
+	adminRole, _ := R.RegisterRole("admin", "Admin role")
+	R.Permit(userRole.ID, usersPerm, Read)
+	R.Permit(adminRole.ID, usersPerm, Create, Update, Delete)
+	R.Deny(adminRole.ID, usersPerm, Delete)
+	adminRole.AddParent(userRole)
+
+	if err := R.SetAuditSink(NewMemoryAuditSink(), AuditOptions{SampleRate: 2}); err == nil {
+		t.Fatalf("sample rate greater than 1 should not be accepted")
+	}
+	sink := NewMemoryAuditSink()
+	R.SetAuditSink(sink, AuditOptions{})
+
+	if !R.IsGrantInherited(adminRole.ID, usersPerm, Read) {
+		t.Fatalf("admin should be granted read")
+	}
+	if R.AnyGrantInherited([]string{adminRole.ID}, usersPerm, Delete) {
+		t.Fatalf("admin should not be granted delete")
+	}
+	R.IsGrantedStr(userRole.ID, "users", Update)
+	R.IsGrantedStr("unknown", "users", Read)
+
+	decisions := sink.Decisions()
+	if len(decisions) != 4 {
+		t.Fatalf("each check should be recorded once, got %d decisions", len(decisions))
+	}
+	expected := []struct {
+		check   string
+		allowed bool
+		reason  string
+	}{
+		{"IsGrantInherited", true, "granted by admin -> user"},
+		{"AnyGrantInherited", false, "action delete is denied by admin"},
+		{"IsGranted", false, "action update is not granted"},
+		{"IsGranted", false, "roles [unknown] are not found"},
+	}
+	for i, e := range expected {
+		d := decisions[i]
+		if d.Check != e.check || d.Allowed != e.allowed || d.Reason != e.reason || !d.Time.Equal(now) {
+			t.Fatalf("decision %d is not valid, expected %+v, got %+v", i, e, d)
+		}
+	}
+	if decisions[1].Permission != "users" || len(decisions[1].Actions) != 1 || decisions[1].Actions[0] != Delete {
+		t.Fatalf("decision permission and actions are not valid: %+v", decisions[1])
+	}
+
+	sink.Reset()
+	R.SetAuditSink(sink, AuditOptions{DenyOnly: true})
+	R.IsGrantInherited(adminRole.ID, usersPerm, Read)
+	R.IsGrantInherited(adminRole.ID, usersPerm, Delete)
+	if d := sink.Decisions(); len(d) != 1 || d[0].Allowed {
+		t.Fatalf("only denied decisions should be recorded, got %d", len(d))
+	}
+
+	sink.Reset()
+	R.SetAuditSink(sink, AuditOptions{SampleRate: 0.25})
+	for i := 0; i < 100; i++ {
+		R.IsGrantInherited(adminRole.ID, usersPerm, Read)
+	}
+	if d := sink.Decisions(); len(d) != 25 {
+		t.Fatalf("a quarter of decisions should be recorded, got %d", len(d))
+	}
+
+	sink.Reset()
+	R.SetAuditSink(nil, AuditOptions{})
+	R.IsGrantInherited(adminRole.ID, usersPerm, Read)
+	if d := sink.Decisions(); len(d) != 0 {
+		t.Fatalf("decisions should not be recorded after auditing is disabled")
+	}
+}
+
+func TestFileAuditSink(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	userRole, _ := R.RegisterRole("user", "User role")
+	R.Permit(userRole.ID, usersPerm, Read)
+
+	dir, err := ioutil.TempDir("", "rbac")
+	if err != nil {
+		t.Fatalf("can not create temp dir, err: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.jsonl")
+	sink, err := NewFileAuditSink(path)
+	if err != nil {
+		t.Fatalf("can not create file audit sink, err: %v", err)
+	}
+	R.SetAuditSink(sink, AuditOptions{})
+	R.IsGrantInherited(userRole.ID, usersPerm, Read)
+	R.IsGrantInherited(userRole.ID, usersPerm, Update)
+	if err := sink.Close(); err != nil {
+		t.Fatalf("can not close file audit sink, err: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("can not open audit file, err: %v", err)
+	}
+	defer f.Close()
+	lines := []*Decision{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if !strings.HasPrefix(scanner.Text(), "{") {
+			t.Fatalf("audit line should be a JSON object: %s", scanner.Text())
+		}
+		d := &Decision{}
+		if err := json.Unmarshal(scanner.Bytes(), d); err != nil {
+			t.Fatalf("can not unmarshal audit line, err: %v", err)
+		}
+		lines = append(lines, d)
+	}
+	if len(lines) != 2 || !lines[0].Allowed || lines[1].Allowed || lines[1].Roles[0] != userRole.ID {
+		t.Fatalf("audit file is not valid: %+v", lines)
+	}
+}
@@ -0,0 +1,134 @@
+package rbac
+
+import (
+	"container/list"
+	"strings"
+	"sync"
+)
+
+// CacheStats are statistics of decision cache
+type CacheStats struct {
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Size     int    `json:"size"`
+	Capacity int    `json:"capacity"`
+}
+
+type cacheKey struct {
+	inherited bool
+	domain    string
+	roleID    string
+	permID    string
+	actions   string
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	granted bool
+}
+
+// decisionCache is a bounded LRU cache of check decisions, it is cleared when revision of the instance changes
+type decisionCache struct {
+	mu       sync.Mutex
+	capacity int
+	revision uint64
+	ll       *list.List
+	entries  map[cacheKey]*list.Element
+	hits     uint64
+	misses   uint64
+}
+
+// EnableCache enables a bounded LRU cache of IsGranted* and AnyGranted* decisions with capacity entries, zero or
+// negative capacity disables it. Cache is invalidated on each mutation of the instance. Decisions which evaluate a
+// scheduled grant are not cached, as they depend on time.
+func (r *RBAC) EnableCache(capacity int) {
+	if capacity <= 0 {
+		r.cache.Store((*decisionCache)(nil))
+		return
+	}
+	r.cache.Store(&decisionCache{capacity: capacity, ll: list.New(), entries: map[cacheKey]*list.Element{}})
+}
+
+// CacheStats returns statistics of decision cache, it is zero if cache is not enabled
+func (r *RBAC) CacheStats() CacheStats {
+	c := r.decisionCache()
+	if c == nil {
+		return CacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return CacheStats{Hits: c.hits, Misses: c.misses, Size: c.ll.Len(), Capacity: c.capacity}
+}
+
+func (r *RBAC) decisionCache() *decisionCache {
+	c, _ := r.cache.Load().(*decisionCache)
+	return c
+}
+
+// cached returns cached decision for key, fn is called on a miss and its result is cached unless it is timed
+func (r *RBAC) cached(key cacheKey, fn func() (granted, timed bool)) bool {
+	c := r.decisionCache()
+	if c == nil {
+		granted, _ := fn()
+		return granted
+	}
+	revision := r.Revision()
+	if granted, ok := c.get(key, revision); ok {
+		return granted
+	}
+	granted, timed := fn()
+	if !timed {
+		c.put(key, granted, revision)
+	}
+	return granted
+}
+
+func newCacheKey(inherited bool, domain, roleID, permID string, actions []Action) cacheKey {
+	acts := make([]string, len(actions))
+	for i, a := range actions {
+		acts[i] = string(a)
+	}
+	return cacheKey{inherited: inherited, domain: domain, roleID: roleID, permID: permID, actions: strings.Join(acts, ",")}
+}
+
+// reset clears entries if revision is changed, c.mu should be held
+func (c *decisionCache) reset(revision uint64) {
+	if c.revision != revision {
+		c.revision = revision
+		c.ll.Init()
+		c.entries = map[cacheKey]*list.Element{}
+	}
+}
+
+func (c *decisionCache) get(key cacheKey, revision uint64) (bool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reset(revision)
+	if e, ok := c.entries[key]; ok {
+		c.hits++
+		c.ll.MoveToFront(e)
+		return e.Value.(*cacheEntry).granted, true
+	}
+	c.misses++
+	return false, false
+}
+
+func (c *decisionCache) put(key cacheKey, granted bool, revision uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.revision != revision {
+		// instance is changed while deciding
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*cacheEntry).granted = granted
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.ll.PushFront(&cacheEntry{key: key, granted: granted})
+	if c.ll.Len() > c.capacity {
+		e := c.ll.Back()
+		c.ll.Remove(e)
+		delete(c.entries, e.Value.(*cacheEntry).key)
+	}
+}
@@ -0,0 +1,113 @@
+package rbac
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestDecisionCache(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	userRole, _ := R.RegisterRole("user", "User role")
+	adminRole, _ := R.RegisterRole("admin", "Admin role")
+	R.Permit(userRole.ID, usersPerm, Read)
+
+	if R.CacheStats() != (CacheStats{}) {
+		t.Fatalf("cache stats should be zero when cache is disabled")
+	}
+	R.EnableCache(2)
+	for i := 0; i < 3; i++ {
+		if !R.IsGranted(userRole.ID, usersPerm, Read) {
+			t.Fatalf("user should have users.read")
+		}
+	}
+	if stats := R.CacheStats(); stats.Hits != 2 || stats.Misses != 1 || stats.Size != 1 || stats.Capacity != 2 {
+		t.Fatalf("cache stats are not valid, got %+v", stats)
+	}
+	R.IsGrantInherited(userRole.ID, usersPerm, Read)
+	R.AnyGranted([]string{userRole.ID, adminRole.ID}, usersPerm, Update)
+	if stats := R.CacheStats(); stats.Size != 2 {
+		t.Fatalf("cache should be bounded, got %+v", stats)
+	}
+
+	invalidations := []func(){
+		func() { R.Permit(adminRole.ID, usersPerm, Update) },
+		func() { R.Revoke(adminRole.ID, usersPerm, Update) },
+		func() { R.RegisterRole("guest", "Guest role") },
+		func() { R.RemoveRole("guest") },
+		func() { adminRole.AddParent(userRole) },
+		func() { adminRole.RemoveParent(userRole) },
+		func() {
+			var buf bytes.Buffer
+			R.Clone(true).SaveJSON(&buf)
+			R.RemoveRole(userRole.ID)
+			R.RemoveRole(adminRole.ID)
+			R.LoadJSON(&buf)
+			userRole, adminRole = R.GetRole("user"), R.GetRole("admin")
+		},
+	}
+	for i, fn := range invalidations {
+		R.IsGrantInherited(adminRole.ID, usersPerm, Read)
+		fn()
+		before := R.CacheStats()
+		R.IsGrantInherited(adminRole.ID, usersPerm, Read)
+		if after := R.CacheStats(); after.Misses != before.Misses+1 {
+			t.Fatalf("cache should be invalidated by mutation %d", i)
+		}
+	}
+	adminRole.AddParent(userRole)
+	if !R.IsGrantInherited(adminRole.ID, usersPerm, Read) {
+		t.Fatalf("cached decision should not be stale after AddParent")
+	}
+
+	// Scheduled grants depend on time, decisions are not cached
+	now := time.Now()
+	R.SetClock(func() time.Time { return now })
+	R.PermitScheduled(adminRole.ID, usersPerm, &Schedule{NotAfter: now.Add(time.Hour)}, Delete)
+	if !R.IsGranted(adminRole.ID, usersPerm, Delete) {
+		t.Fatalf("admin should have scheduled users.delete")
+	}
+	S := R.Clone(true)
+	S.EnableCache(10)
+	if !S.IsGranted(adminRole.ID, usersPerm, Delete) {
+		t.Fatalf("admin should have scheduled users.delete in clone")
+	}
+	now = now.Add(2 * time.Hour)
+	if R.IsGranted(adminRole.ID, usersPerm, Delete) {
+		t.Fatalf("expired scheduled grant should not be cached")
+	}
+	if S.IsGranted(adminRole.ID, usersPerm, Delete) {
+		t.Fatalf("expired scheduled grant should not be cached in clone")
+	}
+	before := R.CacheStats()
+	R.IsGranted(userRole.ID, usersPerm, Read)
+	R.IsGranted(userRole.ID, usersPerm, Read)
+	if after := R.CacheStats(); after.Hits != before.Hits+1 || after.Capacity != 2 {
+		t.Fatalf("decisions without scheduled grants should still be cached, got %+v", after)
+	}
+
+	// Mutations of roles of a clone invalidate cache of the clone
+	C := New(nil)
+	C.RegisterPermission("users", "User resource", CRUD)
+	C.RegisterRole("a", "A role")
+	C.RegisterRole("b", "B role")
+	C.Permit("b", C.GetPermission("users"), Read)
+	C = C.Clone(true)
+	C.EnableCache(10)
+	if C.IsGrantInheritedStr("a", "users", Read) {
+		t.Fatalf("a should not have users.read")
+	}
+	C.GetRole("a").AddParent(C.GetRole("b"))
+	if !C.IsGrantInheritedStr("a", "users", Read) {
+		t.Fatalf("cached decision of clone should not be stale after AddParent")
+	}
+
+	R.EnableCache(0)
+	if R.CacheStats() != (CacheStats{}) {
+		t.Fatalf("cache should be disabled")
+	}
+}
@@ -0,0 +1,144 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// Cardinality limits number of subjects a role can be assigned to, zero Max is unbounded
+type Cardinality struct {
+	Min int `json:"min,omitempty"`
+	Max int `json:"max,omitempty"`
+}
+
+// CardinalityViolation is a role which has holders out of its cardinality limits
+type CardinalityViolation struct {
+	RoleID      string
+	Holders     int
+	Cardinality Cardinality
+}
+
+func (v *CardinalityViolation) Error() string {
+	if v.Holders < v.Cardinality.Min {
+		return fmt.Sprintf("role %s has %d holders, at least %d required", v.RoleID, v.Holders, v.Cardinality.Min)
+	}
+	return fmt.Sprintf("role %s has %d holders, at most %d allowed", v.RoleID, v.Holders, v.Cardinality.Max)
+}
+
+// SetRoleCardinality sets minimum and maximum number of subjects a role can be assigned to, zero max is
+// unbounded. Maximum is enforced by AssignRole, minimum by UnassignRole once it is reached, use
+// ValidateCardinality to find roles which have less holders than minimum.
+func (r *RBAC) SetRoleCardinality(roleID string, min, max int) error {
+	return r.SetRoleCardinalityContext(context.Background(), roleID, min, max)
+}
+
+func (r *RBAC) setRoleCardinality(ctx context.Context, roleID string, min, max int) error {
+	role := r.roleIn("", roleID)
+	if role == nil {
+		log.Errorf("role %s is not registered", roleID)
+		return fmt.Errorf("role %s is not registered", roleID)
+	}
+	r.subjectsMu.Lock()
+	defer r.subjectsMu.Unlock()
+	if holders := len(r.RoleHolders(roleID)); max > 0 && holders > max {
+		log.Errorf("role %s has %d holders more than maximum %d", roleID, holders, max)
+		return fmt.Errorf("role %s has %d holders more than maximum %d", roleID, holders, max)
+	}
+	if err := role.setCardinality(&Cardinality{Min: min, Max: max}); err != nil {
+		return err
+	}
+	r.emit(ctx, Event{Type: EventPolicyChanged, RoleID: roleID, Detail: "cardinality set"})
+	return nil
+}
+
+// RoleCardinality returns cardinality limits of a role, nil if not set
+func (r *RBAC) RoleCardinality(roleID string) *Cardinality {
+	role := r.roleIn("", roleID)
+	if role == nil {
+		return nil
+	}
+	return role.getCardinality()
+}
+
+// RoleHolders returns sorted list of subject IDs which role is assigned to
+func (r *RBAC) RoleHolders(roleID string) []string {
+	res := []string{}
+	r.subjects.Range(func(k, v interface{}) bool {
+		if _, ok := v.(*Subject).Load(roleID); ok {
+			res = append(res, k.(string))
+		}
+		return true
+	})
+	sort.Strings(res)
+	return res
+}
+
+// ValidateCardinality returns roles which have holders out of their cardinality limits, sorted by role ID
+func (r *RBAC) ValidateCardinality() []*CardinalityViolation {
+	res := []*CardinalityViolation{}
+	for _, role := range r.Roles() {
+		c := role.getCardinality()
+		if c == nil {
+			continue
+		}
+		holders := len(r.RoleHolders(role.ID))
+		if holders < c.Min || (c.Max > 0 && holders > c.Max) {
+			res = append(res, &CardinalityViolation{RoleID: role.ID, Holders: holders, Cardinality: *c})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].RoleID < res[j].RoleID })
+	return res
+}
+
+// checkAssign checks if one more subject can be assigned to role, subjectsMu should be held
+func (r *RBAC) checkAssign(roleID string) error {
+	c := r.RoleCardinality(roleID)
+	if c == nil || c.Max == 0 {
+		return nil
+	}
+	if holders := len(r.RoleHolders(roleID)); holders >= c.Max {
+		return fmt.Errorf("role %s has reached maximum %d holders", roleID, c.Max)
+	}
+	return nil
+}
+
+// checkUnassign checks if a subject can be unassigned from role without going below minimum holders, subjectsMu
+// should be held
+func (r *RBAC) checkUnassign(roleID string) error {
+	c := r.RoleCardinality(roleID)
+	if c == nil || c.Min == 0 {
+		return nil
+	}
+	if holders := len(r.RoleHolders(roleID)); holders <= c.Min {
+		return fmt.Errorf("role %s requires at least %d holders", roleID, c.Min)
+	}
+	return nil
+}
+
+// valid checks if limits are not negative and Min is not more than a bounded Max
+func (c *Cardinality) valid() bool {
+	return c.Min >= 0 && c.Max >= 0 && (c.Max == 0 || c.Min <= c.Max)
+}
+
+func (r *Role) setCardinality(c *Cardinality) error {
+	if !c.valid() {
+		log.Errorf("invalid cardinality min:%d max:%d for role %s", c.Min, c.Max, r.ID)
+		return fmt.Errorf("invalid cardinality min:%d max:%d for role %s", c.Min, c.Max, r.ID)
+	}
+	if c.Min == 0 && c.Max == 0 {
+		r.cardinality.Store((*Cardinality)(nil))
+		return nil
+	}
+	r.cardinality.Store(c)
+	return nil
+}
+
+func (r *Role) getCardinality() *Cardinality {
+	c, _ := r.cardinality.Load().(*Cardinality)
+	if c == nil {
+		return nil
+	}
+	res := *c
+	return &res
+}
@@ -0,0 +1,122 @@
+package rbac
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestCardinality(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	ownerRole, _ := R.RegisterRole("owner", "Owner role")
+	dpoRole, _ := R.RegisterRole("dpo", "Data protection officer role")
+
+	if err := R.SetRoleCardinality(ownerRole.ID, 1, 2); err != nil {
+		t.Fatalf("can not set owner cardinality, err: %v", err)
+	}
+	if err := R.SetRoleCardinality(dpoRole.ID, 1, 1); err != nil {
+		t.Fatalf("can not set dpo cardinality, err: %v", err)
+	}
+	if err := R.SetRoleCardinality(dpoRole.ID, 2, 1); err == nil {
+		t.Fatalf("should not be able to set min greater than max")
+	}
+	if err := R.SetRoleCardinality("fake_role", 0, 1); err == nil {
+		t.Fatalf("should not be able to set cardinality of nonexisting role")
+	}
+	if !reflect.DeepEqual(R.RoleCardinality(ownerRole.ID), &Cardinality{Min: 1, Max: 2}) {
+		t.Fatalf("owner cardinality is not valid, got %v", R.RoleCardinality(ownerRole.ID))
+	}
+
+	violations := R.ValidateCardinality()
+	if len(violations) != 2 || violations[0].RoleID != dpoRole.ID || violations[1].RoleID != ownerRole.ID {
+		t.Fatalf("roles without holders should violate minimum cardinality, got %v", violations)
+	}
+	if violations[0].Error() != "role dpo has 0 holders, at least 1 required" {
+		t.Fatalf("violation message is not valid, got %s", violations[0].Error())
+	}
+
+	if err := R.AssignRole("alice", dpoRole.ID); err != nil {
+		t.Fatalf("can not assign dpo to alice, err: %v", err)
+	}
+	if err := R.AssignRole("bob", dpoRole.ID); err == nil {
+		t.Fatalf("should not be able to assign dpo to more than one subject")
+	}
+	if err := R.UnassignRole("alice", dpoRole.ID); err == nil {
+		t.Fatalf("should not be able to unassign last dpo")
+	}
+	for _, s := range []string{"alice", "bob"} {
+		if err := R.AssignRole(s, ownerRole.ID); err != nil {
+			t.Fatalf("can not assign owner to %s, err: %v", s, err)
+		}
+	}
+	if err := R.AssignRole("carol", ownerRole.ID); err == nil {
+		t.Fatalf("should not be able to assign owner to more than two subjects")
+	}
+	if err := R.SetRoleCardinality(ownerRole.ID, 0, 1); err == nil {
+		t.Fatalf("should not be able to set max less than current holders")
+	}
+	if !reflect.DeepEqual(R.RoleHolders(ownerRole.ID), []string{"alice", "bob"}) {
+		t.Fatalf("owner holders are not valid, got %v", R.RoleHolders(ownerRole.ID))
+	}
+	if violations = R.ValidateCardinality(); len(violations) != 0 {
+		t.Fatalf("there should be no violations, got %v", violations)
+	}
+	if err := R.UnassignRole("bob", ownerRole.ID); err != nil {
+		t.Fatalf("can not unassign owner from bob, err: %v", err)
+	}
+
+	// Round trip
+	var buf bytes.Buffer
+	if err := R.SaveJSON(&buf); err != nil {
+		t.Fatalf("unable to save to json, err:%v", err)
+	}
+	R2 := New(nil)
+	if err := R2.LoadJSON(&buf); err != nil {
+		t.Fatalf("unable to load from json, err:%v", err)
+	}
+	if !reflect.DeepEqual(R2.RoleCardinality(dpoRole.ID), &Cardinality{Min: 1, Max: 1}) {
+		t.Fatalf("loaded dpo cardinality is not valid, got %v", R2.RoleCardinality(dpoRole.ID))
+	}
+	if err := R2.AssignRole("bob", dpoRole.ID); err == nil {
+		t.Fatalf("loaded cardinality should be enforced")
+	}
+
+	if err := R.RemoveRole(dpoRole.ID); err != nil {
+		t.Fatalf("can not remove dpo role, err: %v", err)
+	}
+	if len(R.SubjectRoles("alice")) != 1 {
+		t.Fatalf("removed role should be unassigned regardless of cardinality")
+	}
+}
+
+func TestCardinalityConcurrent(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		R := New(nil) //NewConsoleLogger()
+		R.RegisterRole("owner", "Owner role")
+		R.SetRoleCardinality("owner", 0, 1)
+		R.RegisterRole("dpo", "Data protection officer role")
+		R.AssignRole("alice", "dpo")
+		R.AssignRole("bob", "dpo")
+		R.SetRoleCardinality("dpo", 1, 0)
+		var wg sync.WaitGroup
+		for _, subjectID := range []string{"alice", "bob", "carol"} {
+			wg.Add(2)
+			go func(subjectID string) {
+				defer wg.Done()
+				R.AssignRole(subjectID, "owner")
+			}(subjectID)
+			go func(subjectID string) {
+				defer wg.Done()
+				R.UnassignRole(subjectID, "dpo")
+			}(subjectID)
+		}
+		wg.Wait()
+		if holders := R.RoleHolders("owner"); len(holders) != 1 {
+			t.Fatalf("concurrent assignments should not exceed maximum cardinality, got %v", holders)
+		}
+		if holders := R.RoleHolders("dpo"); len(holders) != 1 {
+			t.Fatalf("concurrent unassignments should not go below minimum cardinality, got %v", holders)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package rbac
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Snapshot is an immutable compiled view of global roles of an RBAC instance. Permissions and actions are interned
+// to bit indices and transitive closure of grants of each role is stored in a bitset, so checks are map lookups
+// and bit tests without allocation. Denies, wildcards, implied actions, composite actions and hierarchical
+// permissions are resolved at compile time, conditional, instance and scheduled grants are not included.
+type Snapshot struct {
+	revision   uint64
+	perms      map[string]map[Action]int // key: permissionID, value: action -> bit index, None is any action
+	composites map[Action][]Action
+	roles      map[string]*compiledRole
+}
+
+type compiledRole struct {
+	granted []uint64
+	// sod are dynamic separation of duty constraint indices and members the role carries
+	sod []sodMember
+}
+
+type sodMember struct {
+	constraint int
+	roleID     string
+}
+
+// Revision returns a number which is increased on each mutation of the instance
+func (r *RBAC) Revision() uint64 {
+	return atomic.LoadUint64(&r.revision)
+}
+
+// Compile compiles current state into a snapshot and swaps it atomically with the snapshot returned by Snapshot.
+// Compilations are serialized and a revision is compiled once, concurrent callers get the same snapshot.
+func (r *RBAC) Compile() *Snapshot {
+	r.compileMu.Lock()
+	defer r.compileMu.Unlock()
+	old, _ := r.snapshot.Load().(*Snapshot)
+	if old != nil && old.revision == r.Revision() {
+		return old
+	}
+	s := r.compile()
+	if old == nil || s.revision >= old.revision {
+		r.snapshot.Store(s)
+	}
+	return s
+}
+
+// Snapshot returns the latest compiled snapshot, it is compiled again if the instance is changed since. Readers
+// holding a snapshot are not affected by later mutations.
+func (r *RBAC) Snapshot() *Snapshot {
+	if s, ok := r.snapshot.Load().(*Snapshot); ok && s.revision == r.Revision() {
+		return s
+	}
+	return r.Compile()
+}
+
+func (r *RBAC) compile() *Snapshot {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
+	s := &Snapshot{
+		revision:   r.Revision(),
+		perms:      map[string]map[Action]int{},
+		composites: map[Action][]Action{},
+		roles:      map[string]*compiledRole{},
+	}
+	type pair struct {
+		permID string
+		action Action
+	}
+	pairs := []pair{}
+	for _, perm := range r.sortedPermissions() {
+		s.perms[perm.ID] = map[Action]int{}
+		for _, a := range append([]Action{None, AnyAction}, perm.Actions()...) {
+			s.perms[perm.ID][a] = len(pairs)
+			pairs = append(pairs, pair{perm.ID, a})
+		}
+	}
+	for _, c := range r.CompositeActions() {
+		s.composites[c.Action] = c.Actions
+	}
+	constraints := []*SoDConstraint{}
+	for _, c := range r.SoDConstraints() {
+		if c.Dynamic {
+			constraints = append(constraints, c)
+		}
+	}
+	for _, role := range r.Roles() {
+		cr := &compiledRole{granted: make([]uint64, (len(pairs)+63)/64)}
+		for i, p := range pairs {
+			q := r.newQuery(p.permID)
+			if p.action != None {
+				q = r.newQuery(p.permID, p.action)
+			}
+			q.now = time.Time{}
+			if role.isGrantInheritedQ(q) {
+				cr.granted[i/64] |= 1 << uint(i%64)
+			}
+		}
+		ids := roleIDSet(role)
+		for i, c := range constraints {
+			for _, roleID := range c.Roles {
+				if ids[roleID] {
+					cr.sod = append(cr.sod, sodMember{i, roleID})
+				}
+			}
+		}
+		s.roles[role.ID] = cr
+	}
+	return s
+}
+
+// Revision returns revision of the instance the snapshot is compiled at
+func (s *Snapshot) Revision() uint64 {
+	return s.revision
+}
+
+// IsGranted checks if a role(including inherited permissions from parents) is granted actions of permission, no
+// actions checks if any action is granted. Actions which are not registered for permission are not granted.
+func (s *Snapshot) IsGranted(roleID string, permID string, actions ...Action) bool {
+	role, ok := s.roles[roleID]
+	if !ok {
+		return false
+	}
+	return s.isGranted(role, permID, actions)
+}
+
+// AnyGranted checks if any role(including inherited permissions from parents) is granted actions of permission.
+// Roles violating a dynamic separation of duty constraint together are not granted.
+func (s *Snapshot) AnyGranted(roleIDs []string, permID string, actions ...Action) bool {
+	for i, roleID := range roleIDs {
+		role, ok := s.roles[roleID]
+		if !ok {
+			continue
+		}
+		for _, other := range roleIDs[i+1:] {
+			if o, ok := s.roles[other]; ok && role.conflicts(o) {
+				return false
+			}
+		}
+	}
+	for _, roleID := range roleIDs {
+		if role, ok := s.roles[roleID]; ok && s.isGranted(role, permID, actions) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Snapshot) isGranted(role *compiledRole, permID string, actions []Action) bool {
+	bits, ok := s.perms[permID]
+	if !ok {
+		return false
+	}
+	if len(actions) == 0 {
+		return role.has(bits[None])
+	}
+	for _, a := range actions {
+		if expanded, ok := s.composites[a]; ok {
+			for _, e := range expanded {
+				if i, ok := bits[e]; !ok || !role.has(i) {
+					return false
+				}
+			}
+			continue
+		}
+		if i, ok := bits[a]; !ok || a == None || !role.has(i) {
+			return false
+		}
+	}
+	return true
+}
+
+func (cr *compiledRole) has(i int) bool {
+	return cr.granted[i/64]&(1<<uint(i%64)) != 0
+}
+
+// conflicts checks if roles carry different members of a dynamic separation of duty constraint
+func (cr *compiledRole) conflicts(other *compiledRole) bool {
+	for _, m := range cr.sod {
+		for _, o := range other.sod {
+			if m.constraint == o.constraint && m.roleID != o.roleID {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package rbac
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestCompile(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	postPerm, _ := R.RegisterPermission("post", "Post resource", CRUD, "publish")
+	R.AddImplication(Update, Read)
+	userRole, _ := R.RegisterRole("user", "User role")
+	editorRole, _ := R.RegisterRole("editor", "Editor role")
+	adminRole, _ := R.RegisterRole("admin", "Admin role")
+	rootRole, _ := R.RegisterRole("root", "Root role")
+	editorRole.AddParent(userRole)
+	adminRole.AddParent(editorRole)
+	R.Permit(userRole.ID, usersPerm, Read)
+	R.Permit(editorRole.ID, postPerm, Update, Create)
+	R.Permit(adminRole.ID, postPerm, AnyAction)
+	R.Deny(adminRole.ID, postPerm, "publish")
+	R.Permit(rootRole.ID, R.GetPermission(AnyPermission), AnyAction)
+
+	s := R.Snapshot()
+	roleIDs := []string{"user", "editor", "admin", "root", "fake_role"}
+	for _, roleID := range roleIDs {
+		for _, perm := range []*Permission{usersPerm, postPerm} {
+			for _, actions := range [][]Action{{}, {Read}, {Update}, {Create, Read}, {CRUD}, {"publish"}, {AnyAction}} {
+				expected := R.IsGrantInheritedStr(roleID, perm.ID, actions...)
+				if R.validateActions(perm, R.expandComposites(actions)...) != nil {
+					// unregistered actions are never granted by snapshot
+					expected = false
+				}
+				if got := s.IsGranted(roleID, perm.ID, actions...); got != expected {
+					t.Fatalf("snapshot check for %s %s %v should be %v", roleID, perm.ID, actions, expected)
+				}
+			}
+		}
+	}
+	if s.IsGranted(userRole.ID, "fake_perm", Read) || s.IsGranted(userRole.ID, usersPerm.ID, "approve") {
+		t.Fatalf("unknown permission and action should not be granted")
+	}
+	if !s.AnyGranted([]string{"user", "editor"}, postPerm.ID, Read) {
+		t.Fatalf("editor should have post.read implied by post.update")
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		s.IsGranted("admin", "post", Read, Update)
+		s.AnyGranted(roleIDs, "users", CRUD)
+	})
+	if allocs != 0 {
+		t.Fatalf("snapshot checks should not allocate, got %v allocations", allocs)
+	}
+
+	// Snapshot is not affected by later mutations, a new one is compiled
+	R.Revoke(userRole.ID, usersPerm, Read)
+	if !s.IsGranted(userRole.ID, usersPerm.ID, Read) {
+		t.Fatalf("old snapshot should not be changed")
+	}
+	s2 := R.Snapshot()
+	if s2 == s || s2.Revision() != R.Revision() || s2.IsGranted(userRole.ID, usersPerm.ID, Read) {
+		t.Fatalf("snapshot should be compiled again after mutation")
+	}
+	if R.Snapshot() != s2 {
+		t.Fatalf("snapshot should be reused if there is no mutation")
+	}
+
+	R.AssignRole("alice", editorRole.ID)
+	R.AddDynamicSoD("user-root", userRole.ID, rootRole.ID)
+	if R.Snapshot().AnyGranted([]string{"editor", "root"}, postPerm.ID, Read) {
+		t.Fatalf("roles violating dynamic SoD should not be granted")
+	}
+}
+
+func TestCompileConcurrent(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterRole("user", "User role")
+	R.Permit("user", usersPerm, Read)
+
+	snapshots := make([]*Snapshot, 8)
+	var wg sync.WaitGroup
+	for i := range snapshots {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			snapshots[i] = R.Snapshot()
+		}(i)
+	}
+	wg.Wait()
+	for _, s := range snapshots {
+		if s != snapshots[0] {
+			t.Fatalf("a revision should be compiled once for concurrent readers")
+		}
+	}
+	if R.Compile() != snapshots[0] {
+		t.Fatalf("compiling an unchanged instance should return the latest snapshot")
+	}
+	R.Permit("user", usersPerm, Update)
+	if s := R.Compile(); s.Revision() != R.Revision() || R.Snapshot() != s || !s.IsGranted("user", "users", Update) {
+		t.Fatalf("snapshot should be compiled at the latest revision")
+	}
+}
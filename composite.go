@@ -0,0 +1,115 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// CompositeAction is an action which expands to other actions, used during JSON Marshalling
+type CompositeAction struct {
+	Action  Action   `json:"action"`
+	Actions []Action `json:"actions"`
+}
+
+// builtinComposites are composite actions valid for all RBAC instances
+var builtinComposites = map[Action][]Action{
+	CRUD: {Create, Read, Update, Delete},
+}
+
+// expandBuiltinComposites replaces builtin composite actions with their actions
+func expandBuiltinComposites(actions []Action) []Action {
+	res := []Action{}
+	for _, a := range actions {
+		if acts, ok := builtinComposites[a]; ok {
+			res = append(res, acts...)
+		} else {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// RegisterCompositeAction defines a composite action like `Write = Create+Update` or `Manage = CRUD+Approve`.
+// Composite actions are not stored in permissions or grants, they are expanded to their actions in
+// RegisterPermission, Permit, Revoke, Deny and all check functions.
+func (r *RBAC) RegisterCompositeAction(action Action, actions ...Action) error {
+	return r.RegisterCompositeActionContext(context.Background(), action, actions...)
+}
+
+func (r *RBAC) registerCompositeAction(ctx context.Context, action Action, actions ...Action) error {
+	if action == None || action == AnyAction {
+		log.Errorf("invalid composite action %s", action)
+		return fmt.Errorf("invalid composite action %s", action)
+	}
+	if r.IsCompositeAction(action) {
+		log.Errorf("composite action %s is already registered", action)
+		return fmt.Errorf("composite action %s is already registered", action)
+	}
+	if r.isActionRegistered(action) {
+		log.Errorf("action %s is already registered for a permission", action)
+		return fmt.Errorf("action %s is already registered for a permission", action)
+	}
+	if len(actions) == 0 {
+		log.Errorf("composite action %s has no actions", action)
+		return fmt.Errorf("composite action %s has no actions", action)
+	}
+	for _, c := range r.CompositeActions() {
+		if hasAction(c.Actions, action) {
+			log.Errorf("action %s is already used in composite action %s", action, c.Action)
+			return fmt.Errorf("action %s is already used in composite action %s", action, c.Action)
+		}
+	}
+	expanded := r.expandComposites(actions)
+	for _, a := range expanded {
+		if a == action || a == None || a == AnyAction {
+			log.Errorf("invalid action %s in composite action %s", a, action)
+			return fmt.Errorf("invalid action %s in composite action %s", a, action)
+		}
+	}
+	r.composites.Store(action, expanded)
+	r.emit(ctx, Event{Type: EventPolicyChanged, Detail: "composite action " + string(action) + " registered"})
+	return nil
+}
+
+// IsCompositeAction checks if action is a registered or builtin composite action
+func (r *RBAC) IsCompositeAction(action Action) bool {
+	if _, ok := builtinComposites[action]; ok {
+		return true
+	}
+	_, ok := r.composites.Load(action)
+	return ok
+}
+
+// CompositeActions returns all composite actions including builtin ones sorted by action
+func (r *RBAC) CompositeActions() []*CompositeAction {
+	res := []*CompositeAction{}
+	for a, acts := range builtinComposites {
+		res = append(res, &CompositeAction{Action: a, Actions: acts})
+	}
+	r.composites.Range(func(k, v interface{}) bool {
+		res = append(res, &CompositeAction{Action: k.(Action), Actions: v.([]Action)})
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].Action < res[j].Action })
+	return res
+}
+
+// expandComposites replaces composite actions with their actions, duplicates are removed
+func (r *RBAC) expandComposites(actions []Action) []Action {
+	res := make([]Action, 0, len(actions))
+	for _, a := range actions {
+		acts := []Action{a}
+		if builtin, ok := builtinComposites[a]; ok {
+			acts = builtin
+		} else if v, ok := r.composites.Load(a); ok {
+			acts = v.([]Action)
+		}
+		for _, act := range acts {
+			if !hasAction(res, act) {
+				res = append(res, act)
+			}
+		}
+	}
+	return res
+}
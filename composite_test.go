@@ -0,0 +1,114 @@
+package rbac
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestCompositeAction(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	ApproveAction := Action("approve")
+	WriteAction := Action("write")
+	ManageAction := Action("manage")
+
+	if err := R.RegisterCompositeAction(WriteAction, Create, Update); err != nil {
+		t.Fatalf("can not register write composite action, err: %v", err)
+	}
+	if err := R.RegisterCompositeAction(ManageAction, CRUD, ApproveAction); err != nil {
+		t.Fatalf("can not register manage composite action, err: %v", err)
+	}
+	if err := R.RegisterCompositeAction(WriteAction, Create); err == nil {
+		t.Fatalf("should not be able to register write composite action twice")
+	}
+	if err := R.RegisterCompositeAction(CRUD, Create); err == nil {
+		t.Fatalf("should not be able to register builtin composite action")
+	}
+	if err := R.RegisterCompositeAction(ApproveAction, Read); err == nil {
+		t.Fatalf("should not be able to register an action used in another composite action")
+	}
+	if err := R.RegisterCompositeAction("self", "self"); err == nil {
+		t.Fatalf("should not be able to register self referencing composite action")
+	}
+	if err := R.RegisterCompositeAction("empty"); err == nil {
+		t.Fatalf("should not be able to register empty composite action")
+	}
+	if !R.IsCompositeAction(CRUD) || !R.IsCompositeAction(ManageAction) || R.IsCompositeAction(Read) {
+		t.Fatalf("composite action checks are not valid")
+	}
+
+	postPerm, err := R.RegisterPermission("post", "Post resource", ManageAction)
+	if err != nil {
+		t.Fatalf("can not register post permission, err: %v", err)
+	}
+	if !reflect.DeepEqual(postPerm.ActionsStrSlice(), []string{"approve", "create", "delete", "read", "update"}) {
+		t.Fatalf("post permission actions are not valid, got %v", postPerm.ActionsStrSlice())
+	}
+	if R.IsPermissionExist(postPerm.ID, ManageAction) {
+		t.Fatalf("composite action should not be stored in permission")
+	}
+	if err = R.RegisterCompositeAction(Read, Update); err == nil {
+		t.Fatalf("should not be able to register an action registered for a permission as composite action")
+	}
+
+	editorRole, _ := R.RegisterRole("editor", "Editor role")
+	if err = R.Permit(editorRole.ID, postPerm, WriteAction); err != nil {
+		t.Fatalf("can not permit write to role %s, err: %v", editorRole.ID, err)
+	}
+	if !R.IsGranted(editorRole.ID, postPerm, Create, Update) || !R.IsGranted(editorRole.ID, postPerm, WriteAction) {
+		t.Fatalf("editor should have post.create and post.update")
+	}
+	if R.IsGranted(editorRole.ID, postPerm, CRUD) {
+		t.Fatalf("editor should not have post.crud")
+	}
+	if err = R.Permit(editorRole.ID, postPerm, Read, Delete); err != nil {
+		t.Fatalf("can not permit read and delete to role %s, err: %v", editorRole.ID, err)
+	}
+	if !R.IsGranted(editorRole.ID, postPerm, CRUD) {
+		t.Fatalf("editor should have post.crud when all four actions are granted")
+	}
+	if R.IsGrantInherited(editorRole.ID, postPerm, ManageAction) {
+		t.Fatalf("editor should not have post.manage without approve")
+	}
+	if err = R.Revoke(editorRole.ID, postPerm, WriteAction); err != nil {
+		t.Fatalf("can not revoke write from role %s, err: %v", editorRole.ID, err)
+	}
+	if R.IsGranted(editorRole.ID, postPerm, Update) || !R.IsGranted(editorRole.ID, postPerm, Read) {
+		t.Fatalf("editor should have only post.update revoked")
+	}
+	if err = R.Deny(editorRole.ID, postPerm, CRUD); err != nil {
+		t.Fatalf("can not deny crud to role %s, err: %v", editorRole.ID, err)
+	}
+	if !R.IsDenied(editorRole.ID, postPerm, Read) || R.IsGranted(editorRole.ID, postPerm, Read) {
+		t.Fatalf("editor should have post.read denied")
+	}
+
+	// Round trip
+	var buf bytes.Buffer
+	if err = R.SaveJSON(&buf); err != nil {
+		t.Fatalf("unable to save to json, err:%v", err)
+	}
+	R2 := New(nil)
+	if err = R2.RegisterCompositeAction(WriteAction, Create, Update); err != nil {
+		t.Fatalf("can not register write composite action, err: %v", err)
+	}
+	R2.RegisterPermission("post", "Post resource", CRUD, ApproveAction)
+	if err = R2.LoadJSON(&buf); err != nil {
+		t.Fatalf("unable to load from json, err:%v", err)
+	}
+	if !reflect.DeepEqual(R.CompositeActions(), R2.CompositeActions()) {
+		t.Fatalf("loaded composite actions differ")
+	}
+	if !R2.IsCompositeAction(ManageAction) {
+		t.Fatalf("manage composite action should be loaded")
+	}
+
+	R3 := New(nil)
+	R3.RegisterCompositeAction(WriteAction, Create)
+	R3.RegisterPermission("post", "Post resource", CRUD, ApproveAction)
+	buf.Reset()
+	R.SaveJSON(&buf)
+	if err = R3.LoadJSON(&buf); err == nil {
+		t.Fatalf("loading a different definition of write composite action should fail")
+	}
+}
@@ -0,0 +1,225 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Attributes are subject, resource or environment attributes used by conditions, like `owner_id`
+type Attributes map[string]interface{}
+
+// Request is an access request evaluated by conditions
+type Request struct {
+	Subject    string
+	Permission string
+	Action     Action
+	Attributes Attributes
+}
+
+// ConditionFunc decides if a conditional grant applies to a request. Conditions are called while no lock of the
+// instance is held, so they can call checks of the instance.
+type ConditionFunc func(ctx context.Context, req *Request) bool
+
+// ConditionalGrant is a grant which is valid only when its condition holds, used during JSON Marshalling
+type ConditionalGrant struct {
+	Permission string   `json:"permission"`
+	Condition  string   `json:"condition"`
+	Actions    []Action `json:"actions"`
+}
+
+// RegisterCondition registers a named condition to be used in conditional grants. Conditions are referenced by
+// name in dumped JSON, so they must be registered before LoadJSON.
+func (r *RBAC) RegisterCondition(name string, fn ConditionFunc) error {
+	if name == "" || fn == nil {
+		log.Errorf("condition name and function can not be empty")
+		return fmt.Errorf("condition name and function can not be empty")
+	}
+	if _, loaded := r.conditions.LoadOrStore(name, fn); loaded {
+		log.Errorf("condition %s is already registered", name)
+		return fmt.Errorf("condition %s is already registered", name)
+	}
+	return nil
+}
+
+// IsConditionExist checks if a condition with name is registered
+func (r *RBAC) IsConditionExist(name string) bool {
+	_, ok := r.conditions.Load(name)
+	return ok
+}
+
+// PermitIf grants a permission with defined actions to a role when the named condition holds. Conditional grants
+// are evaluated only by Check and CheckRoles, other check functions ignore them.
+func (r *RBAC) PermitIf(roleID string, perm *Permission, condition string, actions ...Action) error {
+	return r.permitIf(context.Background(), "", roleID, perm, condition, actions...)
+}
+
+func (r *RBAC) permitIf(ctx context.Context, domain, roleID string, perm *Permission, condition string, actions ...Action) error {
+	if !r.IsConditionExist(condition) {
+		log.Errorf("condition %s is not registered", condition)
+		return fmt.Errorf("condition %s is not registered", condition)
+	}
+	return r.modifyScopedRole(ctx, domain, roleID, perm, "permitting to", actions, grantScope{condition: condition}, func(role *Role, actions []Action) {
+		role.grantIf(perm, condition, actions...)
+	})
+}
+
+func (r *RBAC) revokeIfIn(ctx context.Context, domain, roleID string, perm *Permission, condition string, actions ...Action) error {
+	return r.modifyScopedRole(ctx, domain, roleID, perm, "revoking from", actions, grantScope{condition: condition}, func(role *Role, actions []Action) {
+		role.revokeIf(perm, condition, actions...)
+	})
+}
+
+// RevokeIf removes a conditional grant from a role
+func (r *RBAC) RevokeIf(roleID string, perm *Permission, condition string, actions ...Action) error {
+	return r.RevokeIfContext(context.Background(), roleID, perm, condition, actions...)
+}
+
+// Check checks if any role assigned to subject is granted action of permission(including inherited permissions
+// from parents), conditional grants are evaluated with ctx and attrs.
+func (r *RBAC) Check(ctx context.Context, subjectID string, perm *Permission, action Action, attrs Attributes) bool {
+	if perm == nil {
+		log.Errorf("Nil perm is sent for check for subject %s", subjectID)
+		return false
+	}
+	roleIDs := r.SubjectRoles(subjectID)
+	req := &Request{Subject: subjectID, Permission: perm.ID, Action: action, Attributes: attrs}
+	results := r.evalConditions(ctx, roleIDs, req)
+	return r.decide(func() bool { return r.check(roleIDs, req, results, nil) }, func() *Decision {
+		return &Decision{Check: "Check", Subject: subjectID, Roles: roleIDs, Permission: perm.ID, Actions: []Action{action}}
+	})
+}
+
+// CheckRoles checks if any of roles is granted action of permission(including inherited permissions from parents),
+// conditional grants are evaluated with ctx and attrs.
+func (r *RBAC) CheckRoles(ctx context.Context, roleIDs []string, perm *Permission, action Action, attrs Attributes) bool {
+	if perm == nil {
+		log.Errorf("Nil perm is sent for check for roles %v", roleIDs)
+		return false
+	}
+	req := &Request{Permission: perm.ID, Action: action, Attributes: attrs}
+	results := r.evalConditions(ctx, roleIDs, req)
+	return r.decide(func() bool { return r.check(roleIDs, req, results, nil) }, func() *Decision {
+		return &Decision{Check: "CheckRoles", Roles: roleIDs, Permission: perm.ID, Actions: []Action{action}}
+	})
+}
+
+// evalConditions returns results of conditions needed to check req. Conditions are called without holding txMu, so
+// they can call checks: grants are looked up under txMu with results known so far, then conditions found missing
+// are called, until no condition is missing.
+func (r *RBAC) evalConditions(ctx context.Context, roleIDs []string, req *Request) map[string]bool {
+	results := map[string]bool{}
+	for {
+		missing := map[string]bool{}
+		r.txMu.RLock()
+		r.check(roleIDs, req, results, missing)
+		r.txMu.RUnlock()
+		if len(missing) == 0 {
+			return results
+		}
+		for name := range missing {
+			fn, _ := r.conditions.Load(name)
+			results[name] = fn.(ConditionFunc)(ctx, req)
+		}
+	}
+}
+
+// check checks req for roles with condition results, registered conditions which have no result are added to
+// missing if it is not nil and they are evaluated as false
+func (r *RBAC) check(roleIDs []string, req *Request, results, missing map[string]bool) bool {
+	permID := req.Permission
+	if !r.canActivate("", roleIDs) {
+		return false
+	}
+	actions := r.expandComposites([]Action{req.Action})
+	for _, a := range actions {
+		if !r.IsPermissionExist(permID, a) {
+			log.Errorf("Action %s for permission %s is not defined, while checking grants for roles %v", a, permID, roleIDs)
+			return false
+		}
+	}
+	q := r.newQuery(permID, actions...)
+	q.cond = func(v interface{}) (res bool) {
+		v.(*sync.Map).Range(func(name, _ interface{}) bool {
+			if result, ok := results[name.(string)]; ok {
+				res = result
+			} else if _, ok := r.conditions.Load(name); ok && missing != nil {
+				missing[name.(string)] = true
+			}
+			return !res
+		})
+		return res
+	}
+	for _, roleID := range roleIDs {
+		if role := r.resolveRole("", roleID); role != nil && role.isGrantInheritedQ(q) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Role) grantIf(p *Permission, condition string, actions ...Action) {
+	acts, _ := r.conditional.LoadOrStore(p.ID, &sync.Map{})
+	for _, a := range actions {
+		conds, _ := acts.(*sync.Map).LoadOrStore(a, &sync.Map{})
+		conds.(*sync.Map).Store(condition, nil)
+	}
+}
+
+func (r *Role) revokeIf(p *Permission, condition string, actions ...Action) {
+	acts, ok := r.conditional.Load(p.ID)
+	if !ok {
+		return
+	}
+	for _, a := range actions {
+		if conds, ok := acts.(*sync.Map).Load(a); ok {
+			conds.(*sync.Map).Delete(condition)
+			if isEmpty(conds.(*sync.Map)) {
+				acts.(*sync.Map).Delete(a)
+			}
+		}
+	}
+	if isEmpty(acts.(*sync.Map)) {
+		r.conditional.Delete(p.ID)
+	}
+}
+
+func (r *Role) getConditionalGrants() []*ConditionalGrant {
+	byKey := map[[2]string]*ConditionalGrant{}
+	r.conditional.Range(func(permID, acts interface{}) bool {
+		acts.(*sync.Map).Range(func(a, conds interface{}) bool {
+			conds.(*sync.Map).Range(func(cond, _ interface{}) bool {
+				key := [2]string{permID.(string), cond.(string)}
+				if _, ok := byKey[key]; !ok {
+					byKey[key] = &ConditionalGrant{Permission: key[0], Condition: key[1], Actions: []Action{}}
+				}
+				byKey[key].Actions = append(byKey[key].Actions, a.(Action))
+				return true
+			})
+			return true
+		})
+		return true
+	})
+	res := []*ConditionalGrant{}
+	for _, cg := range byKey {
+		sort.Slice(cg.Actions, func(i, j int) bool { return cg.Actions[i] < cg.Actions[j] })
+		res = append(res, cg)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Permission != res[j].Permission {
+			return res[i].Permission < res[j].Permission
+		}
+		return res[i].Condition < res[j].Condition
+	})
+	return res
+}
+
+func isEmpty(m *sync.Map) (res bool) {
+	res = true
+	m.Range(func(_, _ interface{}) bool {
+		res = false
+		return false
+	})
+	return res
+}
@@ -0,0 +1,189 @@
+package rbac
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCondition(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	isOwner := func(ctx context.Context, req *Request) bool {
+		return req.Subject != "" && req.Attributes["owner_id"] == req.Subject
+	}
+	type ctxKey struct{}
+	isBusinessHours := func(ctx context.Context, req *Request) bool {
+		hour, ok := ctx.Value(ctxKey{}).(int)
+		return ok && hour >= 9 && hour < 18
+	}
+	if err = R.RegisterCondition("owner", isOwner); err != nil {
+		t.Fatalf("can not register owner condition, err: %v", err)
+	}
+	if err = R.RegisterCondition("owner", isOwner); err == nil {
+		t.Fatalf("should not be able to register owner condition twice")
+	}
+	if err = R.RegisterCondition("nil", nil); err == nil {
+		t.Fatalf("should not be able to register nil condition")
+	}
+	if err = R.RegisterCondition("business_hours", isBusinessHours); err != nil {
+		t.Fatalf("can not register business_hours condition, err: %v", err)
+	}
+
+	userRole, _ := R.RegisterRole("user", "User role")
+	supportRole, _ := R.RegisterRole("support", "Support role")
+	if err = R.Permit(userRole.ID, usersPerm, Read); err != nil {
+		t.Fatalf("can not permit users.read, err: %v", err)
+	}
+	if err = R.PermitIf(userRole.ID, usersPerm, "owner", Update); err != nil {
+		t.Fatalf("can not permit users.update if owner, err: %v", err)
+	}
+	if err = R.PermitIf(userRole.ID, usersPerm, "unknown", Update); err == nil {
+		t.Fatalf("PermitIf should fail with unregistered condition")
+	}
+	if err = R.PermitIf(userRole.ID, nil, "owner", Update); err == nil {
+		t.Fatalf("PermitIf should fail with nil permission")
+	}
+	if err = R.PermitIf("fake_role", usersPerm, "owner", Update); err == nil {
+		t.Fatalf("PermitIf should fail with nonexisting role")
+	}
+	if err = R.PermitIf(supportRole.ID, usersPerm, "business_hours", CRUD); err != nil {
+		t.Fatalf("can not permit users.crud during business hours, err: %v", err)
+	}
+	if err = supportRole.AddParent(userRole); err != nil {
+		t.Fatalf("adding parent role failed with: %v", err)
+	}
+	R.AssignRole("alice", userRole.ID)
+	R.AssignRole("bob", supportRole.ID)
+
+	ctx := context.Background()
+	own := Attributes{"owner_id": "alice"}
+	other := Attributes{"owner_id": "carol"}
+	if R.IsGranted(userRole.ID, usersPerm, Update) {
+		t.Fatalf("conditional grants should be ignored by IsGranted")
+	}
+	if !R.Check(ctx, "alice", usersPerm, Read, nil) {
+		t.Fatalf("alice should have users.read")
+	}
+	if !R.Check(ctx, "alice", usersPerm, Update, own) {
+		t.Fatalf("alice should have users.update on own user")
+	}
+	if R.Check(ctx, "alice", usersPerm, Update, other) {
+		t.Fatalf("alice should not have users.update on other user")
+	}
+	if R.Check(ctx, "alice", usersPerm, Delete, own) {
+		t.Fatalf("alice should not have users.delete")
+	}
+	if R.Check(ctx, "alice", nil, Read, nil) {
+		t.Fatalf("Check should fail with nil permission")
+	}
+	if R.Check(ctx, "alice", usersPerm, "unknown", own) {
+		t.Fatalf("Check should fail with unknown action")
+	}
+
+	// Inherited conditional grant and context
+	workCtx := context.WithValue(ctx, ctxKey{}, 10)
+	nightCtx := context.WithValue(ctx, ctxKey{}, 23)
+	if !R.Check(workCtx, "bob", usersPerm, Delete, other) {
+		t.Fatalf("bob should have users.delete during business hours")
+	}
+	if R.Check(nightCtx, "bob", usersPerm, Delete, other) {
+		t.Fatalf("bob should not have users.delete out of business hours")
+	}
+	if !R.Check(nightCtx, "bob", usersPerm, Update, Attributes{"owner_id": "bob"}) {
+		t.Fatalf("bob should inherit users.update on own user")
+	}
+	if !R.CheckRoles(workCtx, []string{supportRole.ID}, usersPerm, CRUD, nil) {
+		t.Fatalf("support role should have users.crud during business hours")
+	}
+
+	// Deny wins over conditional grant
+	R.Deny(supportRole.ID, usersPerm, Delete)
+	if R.Check(workCtx, "bob", usersPerm, Delete, other) {
+		t.Fatalf("bob should not have denied users.delete")
+	}
+
+	// Round trip
+	var buf bytes.Buffer
+	if err = R.SaveJSON(&buf); err != nil {
+		t.Fatalf("unable to save to json, err:%v", err)
+	}
+	R2 := R.Clone(false)
+	if err = R2.LoadJSON(&buf); err != nil {
+		t.Fatalf("unable to load from json, err:%v", err)
+	}
+	if !reflect.DeepEqual(R2.GetRole(userRole.ID).getConditionalGrants(), userRole.getConditionalGrants()) {
+		t.Fatalf("loaded conditional grants differ")
+	}
+	if !R2.Check(ctx, "alice", usersPerm, Update, own) || R2.Check(ctx, "alice", usersPerm, Update, other) {
+		t.Fatalf("loaded alice should have users.update only on own user")
+	}
+	buf.Reset()
+	R.SaveJSON(&buf)
+	R3 := New(nil)
+	R3.RegisterPermission("users", "User resource", CRUD)
+	if err = R3.LoadJSON(&buf); err == nil {
+		t.Fatalf("loading should fail when conditions are not registered")
+	}
+
+	// Revoke
+	if err = R.RevokeIf(userRole.ID, usersPerm, "owner", Update); err != nil {
+		t.Fatalf("can not revoke conditional grant, err: %v", err)
+	}
+	if R.Check(ctx, "alice", usersPerm, Update, own) {
+		t.Fatalf("alice should not have users.update after revoke")
+	}
+	if len(userRole.getConditionalGrants()) != 0 {
+		t.Fatalf("user role should not have conditional grants left")
+	}
+}
+
+func TestConditionNestedCheck(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterRole("user", "User role")
+	R.RegisterRole("auditor", "Auditor role")
+	R.Permit("auditor", usersPerm, Read)
+	R.RegisterCondition("audited", func(ctx context.Context, req *Request) bool {
+		time.Sleep(100 * time.Microsecond) // let a commit wait for the lock meanwhile
+		return R.IsGrantedStr("auditor", "users", Read)
+	})
+	R.PermitIf("user", usersPerm, "audited", Update)
+
+	committed, checked := make(chan struct{}), make(chan bool)
+	go func() {
+		defer close(committed)
+		for i := 0; i < 200; i++ {
+			R.Tx(func(tx *Tx) error {
+				return tx.RegisterRole(fmt.Sprintf("role%d", i), "Role")
+			})
+		}
+	}()
+	go func() {
+		granted := true
+		for i := 0; i < 100; i++ {
+			granted = granted && R.CheckRoles(context.Background(), []string{"user"}, usersPerm, Update, nil)
+		}
+		checked <- granted
+	}()
+	timeout := time.After(10 * time.Second)
+	select {
+	case granted := <-checked:
+		if !granted {
+			t.Fatalf("user should be granted update when condition holds")
+		}
+	case <-timeout:
+		t.Fatalf("checks should not be blocked by conditions calling checks")
+	}
+	select {
+	case <-committed:
+	case <-timeout:
+		t.Fatalf("transactions should not be blocked by conditions calling checks")
+	}
+}
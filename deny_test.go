@@ -0,0 +1,113 @@
+package rbac
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDeny(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	crudActions := []Action{Create, Read, Update, Delete}
+	usersPerm, err := R.RegisterPermission("users", "User resource", crudActions...)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+
+	// viewer - has users.Read
+	//   `-> editor - inherits viewer, has users.Update, denies users.Delete
+	//     `-> admin - inherits editor, has users.CRUD
+	viewerRole, _ := R.RegisterRole("viewer", "Viewer role")
+	editorRole, _ := R.RegisterRole("editor", "Editor role")
+	adminRole, _ := R.RegisterRole("admin", "Admin role")
+	if err = R.Permit(viewerRole.ID, usersPerm, Read); err != nil {
+		t.Fatalf("can not permit Read action to role %s", viewerRole.ID)
+	}
+	if err = R.Permit(editorRole.ID, usersPerm, Update, Delete); err != nil {
+		t.Fatalf("can not permit Update action to role %s", editorRole.ID)
+	}
+	if err = R.Permit(adminRole.ID, usersPerm, crudActions...); err != nil {
+		t.Fatalf("can not permit crud actions to role %s", adminRole.ID)
+	}
+	if err = editorRole.AddParent(viewerRole); err != nil {
+		t.Fatalf("adding parent role failed with: %v", err)
+	}
+	if err = adminRole.AddParent(editorRole); err != nil {
+		t.Fatalf("adding parent role failed with: %v", err)
+	}
+
+	// Actions are inherited one by one
+	if !R.IsGrantInherited(editorRole.ID, usersPerm, Read, Update) {
+		t.Fatalf("editor role should have users.read inherited and users.update granted")
+	}
+
+	// Deny branches
+	if err = R.Deny(editorRole.ID, nil, Delete); err == nil {
+		t.Fatalf("Deny should fail with nil permission")
+	}
+	if err = R.Deny(editorRole.ID, usersPerm, "approve"); err == nil {
+		t.Fatalf("Deny should fail with invalid action for permission")
+	}
+	if err = R.Deny("fake_role", usersPerm, Delete); err == nil {
+		t.Fatalf("Deny should fail with nonexisting role")
+	}
+	if err = R.Deny(editorRole.ID, usersPerm, Delete); err != nil {
+		t.Fatalf("can not deny Delete action to role %s, err: %v", editorRole.ID, err)
+	}
+
+	// Deny wins over own grant
+	if R.IsGranted(editorRole.ID, usersPerm, Delete) {
+		t.Fatalf("editor role should not have users.delete as it is denied")
+	}
+	if R.IsGrantInherited(editorRole.ID, usersPerm, Delete) {
+		t.Fatalf("editor role should not inherit users.delete as it is denied")
+	}
+	if !R.IsGranted(editorRole.ID, usersPerm, Update) {
+		t.Fatalf("editor role should still have users.update")
+	}
+	// Deny of an ancestor wins over grant of child
+	if R.IsGranted(adminRole.ID, usersPerm, Delete) {
+		t.Fatalf("admin role should not have users.delete as it is denied by editor role")
+	}
+	if !R.IsDenied(adminRole.ID, usersPerm, Delete) {
+		t.Fatalf("admin role should have users.delete denied by editor role")
+	}
+	if R.IsDenied(viewerRole.ID, usersPerm, Delete) {
+		t.Fatalf("viewer role should not be affected by deny of child role")
+	}
+	if R.AnyGranted([]string{editorRole.ID, adminRole.ID}, usersPerm, Delete) {
+		t.Fatalf("none of the roles should have users.delete")
+	}
+	if R.AllGrantInherited([]string{editorRole.ID, adminRole.ID}, usersPerm, Read, Delete) {
+		t.Fatalf("roles should not have users.delete")
+	}
+	if !R.AllGrantInherited([]string{editorRole.ID, adminRole.ID}, usersPerm, Read, Update) {
+		t.Fatalf("roles should all have users.read and users.update")
+	}
+
+	// Round trip
+	b, err := json.Marshal(R)
+	if err != nil {
+		t.Fatalf("rbac marshall failed with %v", err)
+	}
+	R2 := R.Clone(false)
+	if err = json.Unmarshal(b, R2); err != nil {
+		t.Fatalf("rbac unmarshall failed with %v", err)
+	}
+	if R2.IsGranted(adminRole.ID, usersPerm, Delete) {
+		t.Fatalf("loaded admin role should not have users.delete")
+	}
+	if !R2.IsGranted(adminRole.ID, usersPerm, Update) {
+		t.Fatalf("loaded admin role should have users.update")
+	}
+
+	// Undeny
+	if err = R.Undeny(editorRole.ID, usersPerm, Delete); err != nil {
+		t.Fatalf("can not undeny Delete action from role %s, err: %v", editorRole.ID, err)
+	}
+	if !R.IsGranted(adminRole.ID, usersPerm, Delete) {
+		t.Fatalf("admin role should have users.delete after undeny")
+	}
+	if len(editorRole.getDenies()) != 0 {
+		t.Fatalf("editor role should not have denies left")
+	}
+}
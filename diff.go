@@ -0,0 +1,436 @@
+package rbac
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DiffOp is operation of a policy change
+type DiffOp string
+
+const (
+	// DiffAdd adds an item
+	DiffAdd DiffOp = "add"
+	// DiffRemove removes an item
+	DiffRemove DiffOp = "remove"
+	// DiffUpdate updates description of a role or permission, or cardinality of a role
+	DiffUpdate DiffOp = "update"
+)
+
+// DiffKind is kind of item a policy change is about
+type DiffKind string
+
+const (
+	// DiffPermission is a permission, Permission, Actions and description To are set
+	DiffPermission DiffKind = "permission"
+	// DiffAction are actions of a permission
+	DiffAction DiffKind = "action"
+	// DiffRole is a role, RoleID and description To are set
+	DiffRole DiffKind = "role"
+	// DiffGrant are granted actions of a permission of a role
+	DiffGrant DiffKind = "grant"
+	// DiffDeny are denied actions of a permission of a role
+	DiffDeny DiffKind = "deny"
+	// DiffParent is a parent of a role
+	DiffParent DiffKind = "parent"
+	// DiffDescription is description of a role if RoleID is set, of a permission otherwise
+	DiffDescription DiffKind = "description"
+	// DiffConditional are actions of a permission granted to a role when Condition holds
+	DiffConditional DiffKind = "conditional"
+	// DiffInstance are actions of a permission granted to a role for resource Instance
+	DiffInstance DiffKind = "instance"
+	// DiffScheduled are actions of a permission granted to a role while Schedule is active
+	DiffScheduled DiffKind = "scheduled"
+	// DiffCardinality is cardinality of a role, Cardinality is nil if it is removed
+	DiffCardinality DiffKind = "cardinality"
+)
+
+// PolicyChange is a change between two policies
+type PolicyChange struct {
+	Op         DiffOp   `json:"op"`
+	Kind       DiffKind `json:"kind"`
+	Domain     string   `json:"domain,omitempty"`
+	RoleID     string   `json:"role_id,omitempty"`
+	Permission string   `json:"permission,omitempty"`
+	ParentID   string   `json:"parent_id,omitempty"`
+	Actions    []Action `json:"actions,omitempty"`
+	Condition  string   `json:"condition,omitempty"`
+	Instance   string   `json:"instance,omitempty"`
+	// Schedule is schedule of added or removed scheduled grants
+	Schedule    *Schedule    `json:"schedule,omitempty"`
+	Cardinality *Cardinality `json:"cardinality,omitempty"`
+	// From and To are old and new descriptions, To is also description of added roles and permissions
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// PolicyDiff is the result of Diff. Changes are ordered so they can be applied one by one: permissions, actions and
+// roles are added before they are used, roles are removed after their grants and parents are changed, actions and
+// permissions are removed after grants using them are removed.
+type PolicyDiff struct {
+	Changes []*PolicyChange `json:"changes"`
+}
+
+// policy is a consistent view of permissions and roles of an instance
+type policy struct {
+	perms map[string]*Permission
+	roles map[roleKey]*RoleGrants
+}
+
+type roleKey struct {
+	domain string
+	id     string
+}
+
+func (r *RBAC) policy() *policy {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
+	p := &policy{perms: map[string]*Permission{}, roles: map[roleKey]*RoleGrants{}}
+	for _, perm := range r.Permissions() {
+		p.perms[perm.ID] = perm
+	}
+	for _, rg := range r.RoleGrants() {
+		p.roles[roleKey{"", rg.ID}] = rg
+	}
+	for _, d := range r.DomainRoleGrants() {
+		for _, rg := range d.Roles {
+			p.roles[roleKey{d.ID, rg.ID}] = rg
+		}
+	}
+	return p
+}
+
+// Diff returns changes which turn policy of a into policy of b. Permissions with their actions and descriptions,
+// global and domain roles with their descriptions, cardinality, grants, denies, conditional, instance and scheduled
+// grants and parents are compared. Registered conditions, composite actions, implications, SoD constraints and
+// subjects are not.
+func Diff(a, b *RBAC) *PolicyDiff {
+	pa, pb := a.policy(), b.policy()
+	var perms, removedActions, removedPerms, addedRoles, descriptions, grants, denies, parents, removedRoles []*PolicyChange
+
+	permIDs := []string{}
+	for id := range pa.perms {
+		permIDs = append(permIDs, id)
+	}
+	for id := range pb.perms {
+		if _, ok := pa.perms[id]; !ok {
+			permIDs = append(permIDs, id)
+		}
+	}
+	sort.Strings(permIDs)
+	for _, id := range permIDs {
+		old, ok := pa.perms[id]
+		perm, exists := pb.perms[id]
+		switch {
+		case !exists:
+			removedPerms = append(removedPerms, &PolicyChange{Op: DiffRemove, Kind: DiffPermission, Permission: id, Actions: old.sortedActions()})
+		case !ok:
+			perms = append(perms, &PolicyChange{Op: DiffAdd, Kind: DiffPermission, Permission: id, Actions: perm.sortedActions(), To: perm.Description})
+		default:
+			if old.Description != perm.Description {
+				perms = append(perms, &PolicyChange{Op: DiffUpdate, Kind: DiffDescription, Permission: id, From: old.Description, To: perm.Description})
+			}
+			added, removed := diffActions(old.Actions(), perm.Actions())
+			if len(added) > 0 {
+				perms = append(perms, &PolicyChange{Op: DiffAdd, Kind: DiffAction, Permission: id, Actions: added})
+			}
+			if len(removed) > 0 {
+				removedActions = append(removedActions, &PolicyChange{Op: DiffRemove, Kind: DiffAction, Permission: id, Actions: removed})
+			}
+		}
+	}
+
+	keys := []roleKey{}
+	for k := range pa.roles {
+		keys = append(keys, k)
+	}
+	for k := range pb.roles {
+		if _, ok := pa.roles[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].domain != keys[j].domain {
+			return keys[i].domain < keys[j].domain
+		}
+		return keys[i].id < keys[j].id
+	})
+	for _, k := range keys {
+		old, ok := pa.roles[k]
+		rg, exists := pb.roles[k]
+		if !exists {
+			removedRoles = append(removedRoles, &PolicyChange{Op: DiffRemove, Kind: DiffRole, Domain: k.domain, RoleID: k.id})
+			continue
+		}
+		if !ok {
+			addedRoles = append(addedRoles, &PolicyChange{Op: DiffAdd, Kind: DiffRole, Domain: k.domain, RoleID: k.id, To: rg.Description})
+			old = &RoleGrants{}
+		} else if old.Description != rg.Description {
+			descriptions = append(descriptions, &PolicyChange{Op: DiffUpdate, Kind: DiffDescription, Domain: k.domain, RoleID: k.id, From: old.Description, To: rg.Description})
+		}
+		if !sameCardinality(old.Cardinality, rg.Cardinality) {
+			descriptions = append(descriptions, &PolicyChange{Op: DiffUpdate, Kind: DiffCardinality, Domain: k.domain, RoleID: k.id, Cardinality: rg.Cardinality})
+		}
+		grants = append(grants, diffGrants(k, DiffGrant, old.Grants, rg.Grants)...)
+		grants = append(grants, diffConditional(k, old.Conditional, rg.Conditional)...)
+		grants = append(grants, diffInstances(k, old.Instances, rg.Instances)...)
+		grants = append(grants, diffScheduled(k, old.Scheduled, rg.Scheduled)...)
+		denies = append(denies, diffGrants(k, DiffDeny, old.Denies, rg.Denies)...)
+		added, removed := diffStrings(old.Parents, rg.Parents)
+		for _, parentID := range added {
+			parents = append(parents, &PolicyChange{Op: DiffAdd, Kind: DiffParent, Domain: k.domain, RoleID: k.id, ParentID: parentID})
+		}
+		for _, parentID := range removed {
+			parents = append(parents, &PolicyChange{Op: DiffRemove, Kind: DiffParent, Domain: k.domain, RoleID: k.id, ParentID: parentID})
+		}
+	}
+
+	res := &PolicyDiff{Changes: []*PolicyChange{}}
+	for _, changes := range [][]*PolicyChange{perms, addedRoles, descriptions, grants, denies, parents, removedRoles, removedActions, removedPerms} {
+		res.Changes = append(res.Changes, changes...)
+	}
+	return res
+}
+
+// diffGrants returns changes of actions per permission from old to cur grants of a role
+func diffGrants(k roleKey, kind DiffKind, old, cur grantsMap) (res []*PolicyChange) {
+	permIDs := []string{}
+	for permID := range old {
+		permIDs = append(permIDs, permID)
+	}
+	for permID := range cur {
+		if _, ok := old[permID]; !ok {
+			permIDs = append(permIDs, permID)
+		}
+	}
+	sort.Strings(permIDs)
+	for _, permID := range permIDs {
+		added, removed := diffActions(old[permID], cur[permID])
+		if len(added) > 0 {
+			res = append(res, &PolicyChange{Op: DiffAdd, Kind: kind, Domain: k.domain, RoleID: k.id, Permission: permID, Actions: added})
+		}
+		if len(removed) > 0 {
+			res = append(res, &PolicyChange{Op: DiffRemove, Kind: kind, Domain: k.domain, RoleID: k.id, Permission: permID, Actions: removed})
+		}
+	}
+	return res
+}
+
+// diffConditional returns changes of actions per permission and condition from old to cur conditional grants
+func diffConditional(k roleKey, old, cur []*ConditionalGrant) (res []*PolicyChange) {
+	byKey := func(cgs []*ConditionalGrant) map[[2]string][]Action {
+		m := map[[2]string][]Action{}
+		for _, cg := range cgs {
+			m[[2]string{cg.Permission, cg.Condition}] = cg.Actions
+		}
+		return m
+	}
+	om, cm := byKey(old), byKey(cur)
+	keys := [][2]string{}
+	for key := range om {
+		keys = append(keys, key)
+	}
+	for key := range cm {
+		if _, ok := om[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sortKeys(keys)
+	for _, key := range keys {
+		added, removed := diffActions(om[key], cm[key])
+		if len(added) > 0 {
+			res = append(res, &PolicyChange{Op: DiffAdd, Kind: DiffConditional, Domain: k.domain, RoleID: k.id, Permission: key[0], Condition: key[1], Actions: added})
+		}
+		if len(removed) > 0 {
+			res = append(res, &PolicyChange{Op: DiffRemove, Kind: DiffConditional, Domain: k.domain, RoleID: k.id, Permission: key[0], Condition: key[1], Actions: removed})
+		}
+	}
+	return res
+}
+
+// diffInstances returns changes of actions per permission and instance from old to cur instance grants
+func diffInstances(k roleKey, old, cur instancesMap) (res []*PolicyChange) {
+	keys := [][2]string{}
+	for permID, insts := range old {
+		for id := range insts {
+			keys = append(keys, [2]string{permID, id})
+		}
+	}
+	for permID, insts := range cur {
+		for id := range insts {
+			if _, ok := old[permID][id]; !ok {
+				keys = append(keys, [2]string{permID, id})
+			}
+		}
+	}
+	sortKeys(keys)
+	for _, key := range keys {
+		added, removed := diffActions(old[key[0]][key[1]], cur[key[0]][key[1]])
+		if len(added) > 0 {
+			res = append(res, &PolicyChange{Op: DiffAdd, Kind: DiffInstance, Domain: k.domain, RoleID: k.id, Permission: key[0], Instance: key[1], Actions: added})
+		}
+		if len(removed) > 0 {
+			res = append(res, &PolicyChange{Op: DiffRemove, Kind: DiffInstance, Domain: k.domain, RoleID: k.id, Permission: key[0], Instance: key[1], Actions: removed})
+		}
+	}
+	return res
+}
+
+// diffScheduled returns changes from old to cur scheduled grants, actions whose schedule changed are removed with
+// their old schedule before they are added with the new one
+func diffScheduled(k roleKey, old, cur []*ScheduledGrant) (res []*PolicyChange) {
+	schedules := func(sgs []*ScheduledGrant) map[[2]string]*Schedule {
+		m := map[[2]string]*Schedule{}
+		for _, sg := range sgs {
+			for _, a := range sg.Actions {
+				m[[2]string{sg.Permission, string(a)}] = sg.Schedule
+			}
+		}
+		return m
+	}
+	changed := func(op DiffOp, sgs []*ScheduledGrant, other map[[2]string]*Schedule) {
+		for _, sg := range sgs {
+			var actions []Action
+			for _, a := range sg.Actions {
+				if s, ok := other[[2]string{sg.Permission, string(a)}]; !ok || !sameSchedule(s, sg.Schedule) {
+					actions = append(actions, a)
+				}
+			}
+			if len(actions) > 0 {
+				res = append(res, &PolicyChange{Op: op, Kind: DiffScheduled, Domain: k.domain, RoleID: k.id, Permission: sg.Permission, Actions: actions, Schedule: sg.Schedule})
+			}
+		}
+	}
+	changed(DiffRemove, old, schedules(cur))
+	changed(DiffAdd, cur, schedules(old))
+	return res
+}
+
+// sameSchedule checks if schedules have same bounds and windows
+func sameSchedule(a, b *Schedule) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+	return string(ja) == string(jb)
+}
+
+// sameCardinality checks if cardinalities have same limits, nil is same as no limits
+func sameCardinality(a, b *Cardinality) bool {
+	if a == nil {
+		a = &Cardinality{}
+	}
+	if b == nil {
+		b = &Cardinality{}
+	}
+	return *a == *b
+}
+
+func sortKeys(keys [][2]string) {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+}
+
+// diffActions returns sorted actions which are only in cur and only in old
+func diffActions(old, cur []Action) (added, removed []Action) {
+	for _, a := range cur {
+		if !hasAction(old, a) {
+			added = append(added, a)
+		}
+	}
+	for _, a := range old {
+		if !hasAction(cur, a) {
+			removed = append(removed, a)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	return added, removed
+}
+
+// diffStrings returns sorted strings which are only in cur and only in old
+func diffStrings(old, cur []string) (added, removed []string) {
+	for _, s := range cur {
+		if !hasString(old, s) {
+			added = append(added, s)
+		}
+	}
+	for _, s := range old {
+		if !hasString(cur, s) {
+			removed = append(removed, s)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+// Empty checks if there is no change. Only items compared by Diff are considered, so instances whose diff is empty
+// may still differ in registered conditions, composite actions, implications, SoD constraints and subjects.
+func (d *PolicyDiff) Empty() bool {
+	return len(d.Changes) == 0
+}
+
+// String renders changes as human readable text, a change per line
+func (d *PolicyDiff) String() string {
+	var sb strings.Builder
+	for _, c := range d.Changes {
+		sb.WriteString(c.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+// JSON renders changes as indented JSON
+func (d *PolicyDiff) JSON() ([]byte, error) {
+	return json.MarshalIndent(d, "", "  ")
+}
+
+// String renders change as human readable text, like "+ grant admin: users [delete]"
+func (c *PolicyChange) String() string {
+	sign := map[DiffOp]string{DiffAdd: "+", DiffRemove: "-", DiffUpdate: "~"}[c.Op]
+	role := c.RoleID
+	if c.Domain != "" {
+		role = fmt.Sprintf("%s (domain %s)", c.RoleID, c.Domain)
+	}
+	switch c.Kind {
+	case DiffPermission:
+		if c.Op == DiffAdd {
+			return fmt.Sprintf("%s permission %s %v %q", sign, c.Permission, c.Actions, c.To)
+		}
+		return fmt.Sprintf("%s permission %s", sign, c.Permission)
+	case DiffAction:
+		return fmt.Sprintf("%s action %s %v", sign, c.Permission, c.Actions)
+	case DiffRole:
+		if c.Op == DiffAdd {
+			return fmt.Sprintf("%s role %s %q", sign, role, c.To)
+		}
+		return fmt.Sprintf("%s role %s", sign, role)
+	case DiffGrant, DiffDeny:
+		return fmt.Sprintf("%s %s %s: %s %v", sign, c.Kind, role, c.Permission, c.Actions)
+	case DiffConditional:
+		return fmt.Sprintf("%s conditional %s: %s %v if %s", sign, role, c.Permission, c.Actions, c.Condition)
+	case DiffInstance:
+		return fmt.Sprintf("%s instance %s: %s/%s %v", sign, role, c.Permission, c.Instance, c.Actions)
+	case DiffScheduled:
+		schedule, _ := json.Marshal(c.Schedule)
+		return fmt.Sprintf("%s scheduled %s: %s %v %s", sign, role, c.Permission, c.Actions, schedule)
+	case DiffCardinality:
+		if c.Cardinality == nil {
+			return fmt.Sprintf("%s cardinality of role %s: none", sign, role)
+		}
+		return fmt.Sprintf("%s cardinality of role %s: min %d max %d", sign, role, c.Cardinality.Min, c.Cardinality.Max)
+	case DiffParent:
+		return fmt.Sprintf("%s parent %s -> %s", sign, role, c.ParentID)
+	case DiffDescription:
+		if c.RoleID == "" {
+			return fmt.Sprintf("%s description of permission %s: %q -> %q", sign, c.Permission, c.From, c.To)
+		}
+		return fmt.Sprintf("%s description of role %s: %q -> %q", sign, role, c.From, c.To)
+	}
+	return fmt.Sprintf("%s %s", sign, c.Kind)
+}
@@ -0,0 +1,69 @@
+package rbac
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	A := New(nil) //NewConsoleLogger()
+	usersPerm, _ := A.RegisterPermission("users", "User resource", CRUD)
+	A.RegisterPermission("logs", "Log resource", Read)
+	userRole, _ := A.RegisterRole("user", "User role")
+	adminRole, _ := A.RegisterRole("admin", "Admin role")
+	A.RegisterRole("guest", "Guest role")
+	A.Permit(userRole.ID, usersPerm, Read)
+	A.Permit(adminRole.ID, usersPerm, Create, Update)
+	A.Deny(adminRole.ID, usersPerm, Delete)
+
+	if d := Diff(A, A); !d.Empty() {
+		t.Fatalf("diff of same instance should be empty, got:\n%s", d)
+	}
+
+	B := New(nil)
+	usersPerm2, _ := B.RegisterPermission("users", "Users", CRUD)
+	reportsPerm, _ := B.RegisterPermission("reports", "Report resource", Read)
+	userRole2, _ := B.RegisterRole("user", "User role")
+	adminRole2, _ := B.RegisterRole("admin", "Administrator")
+	auditorRole, _ := B.RegisterRole("auditor", "Auditor role")
+	B.Permit(userRole2.ID, usersPerm2, Read)
+	B.Permit(adminRole2.ID, usersPerm2, Create, Delete)
+	B.Permit(auditorRole.ID, reportsPerm, Read)
+	adminRole2.AddParent(userRole2)
+	auditorRole.AddParent(userRole2)
+	B.RegisterRoleIn("acme", "user", "Acme user")
+
+	d := Diff(A, B)
+	expected := []string{
+		`+ permission reports [read] "Report resource"`,
+		`~ description of permission users: "User resource" -> "Users"`,
+		`+ role auditor "Auditor role"`,
+		`+ role user (domain acme) "Acme user"`,
+		`~ description of role admin: "Admin role" -> "Administrator"`,
+		`+ grant admin: users [delete]`,
+		`- grant admin: users [update]`,
+		`+ grant auditor: reports [read]`,
+		`- deny admin: users [delete]`,
+		`+ parent admin -> user`,
+		`+ parent auditor -> user`,
+		`- role guest`,
+		`- permission logs`,
+	}
+	if lines := strings.Split(strings.TrimSpace(d.String()), "\n"); strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("diff is not valid, expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), d)
+	}
+
+	b, err := d.JSON()
+	if err != nil {
+		t.Fatalf("can not render diff as JSON, err: %v", err)
+	}
+	res := &PolicyDiff{}
+	if err = json.Unmarshal(b, res); err != nil {
+		t.Fatalf("can not parse JSON diff, err: %v", err)
+	}
+	if len(res.Changes) != len(expected) || res.Changes[5].Op != DiffAdd || res.Changes[5].Kind != DiffGrant ||
+		res.Changes[5].RoleID != "admin" || res.Changes[5].Actions[0] != Delete {
+		t.Fatalf("JSON diff is not valid: %s", b)
+	}
+}
@@ -57,7 +57,12 @@ At run time we define our roles and permit permissions to them.
 Now we can check if a role is granted some permission:
 
 
-	if !R.IsGranted(adminRole.ID, usersPerm, rbac.Write) {
+	// Composite actions expand to their actions, rbac.CRUD is builtin
+	if err = R.RegisterCompositeAction("write", rbac.Create, rbac.Update); err != nil {
+		panic(err)
+	}
+
+	if !R.IsGranted(adminRole.ID, usersPerm, "write") {
 		fmt.Printf("admin role does not have write grant on users\n")
 	}else{
 		fmt.Printf("admin role does have write grant on users\n")
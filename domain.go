@@ -0,0 +1,236 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// DomainRoles is used during JSON Marshalling
+type DomainRoles struct {
+	ID    string        `json:"id"`
+	Roles []*RoleGrants `json:"roles"`
+}
+
+// domain returns roles map of a domain, key: role.ID, value: role. Empty domain is for global roles.
+func (r *RBAC) domain(domain string, create bool) *sync.Map {
+	if domain == "" {
+		return &r.Map
+	}
+	if create {
+		roles, _ := r.domains.LoadOrStore(domain, &sync.Map{})
+		return roles.(*sync.Map)
+	}
+	if roles, ok := r.domains.Load(domain); ok {
+		return roles.(*sync.Map)
+	}
+	return nil
+}
+
+// roleIn returns role registered in domain, global roles are not looked up for a domain
+func (r *RBAC) roleIn(domain, roleID string) *Role {
+	if roles := r.domain(domain, false); roles != nil {
+		if role, ok := roles.Load(roleID); ok {
+			return role.(*Role)
+		}
+	}
+	return nil
+}
+
+// resolveRole returns role registered in domain, or global role with roleID if it is not found in domain
+func (r *RBAC) resolveRole(domain, roleID string) *Role {
+	if role := r.roleIn(domain, roleID); role != nil {
+		return role
+	}
+	if domain != "" {
+		return r.roleIn("", roleID)
+	}
+	return nil
+}
+
+// domainRoles returns roles of all domains
+func (r *RBAC) domainRoles() (res []*Role) {
+	for _, domain := range r.Domains() {
+		res = append(res, r.RolesIn(domain)...)
+	}
+	return res
+}
+
+// Domains returns sorted list of domains which have roles registered
+func (r *RBAC) Domains() []string {
+	res := []string{}
+	r.domains.Range(func(k, _ interface{}) bool {
+		res = append(res, k.(string))
+		return true
+	})
+	sort.Strings(res)
+	return res
+}
+
+// RegisterRoleIn defines and registers a role in a domain(tenant). Domain roles can inherit from roles of the same
+// domain and from global roles only, so their grants never leak into another domain.
+func (r *RBAC) RegisterRoleIn(domain, roleID string, description string) (*Role, error) {
+	return r.registerRoleIn(context.Background(), domain, roleID, description)
+}
+
+func (r *RBAC) registerRoleIn(ctx context.Context, domain, roleID string, description string) (role *Role, err error) {
+	if domain == "" {
+		return r.RegisterRoleContext(ctx, roleID, description)
+	}
+	err = r.record(ctx, &Change{Type: EventRoleRegistered, Domain: domain, RoleID: roleID}, func() error {
+		if r.IsRoleExistIn(domain, roleID) {
+			log.Errorf("role %s is already registered in domain %s", roleID, domain)
+			return fmt.Errorf("role %s is already registered in domain %s", roleID, domain)
+		}
+		role = &Role{ID: roleID, Description: description, Domain: domain, rbac: r}
+		r.domain(domain, true).Store(roleID, role)
+		r.emit(ctx, Event{Type: EventRoleRegistered, Domain: domain, RoleID: roleID})
+		return nil
+	})
+	return role, err
+}
+
+// GetRoleIn returns role registered in domain, global roles are not returned. Role is nil if not found.
+func (r *RBAC) GetRoleIn(domain, roleID string) *Role {
+	role := r.roleIn(domain, roleID)
+	if role == nil {
+		log.Errorf("role %s is not registered in domain %s", roleID, domain)
+	}
+	return role
+}
+
+// IsRoleExistIn checks if a role with target ID is defined in domain
+func (r *RBAC) IsRoleExistIn(domain, roleID string) bool {
+	return r.roleIn(domain, roleID) != nil
+}
+
+// RolesIn returns all roles registered in domain
+func (r *RBAC) RolesIn(domain string) (res []*Role) {
+	if roles := r.domain(domain, false); roles != nil {
+		roles.Range(func(_, v interface{}) bool {
+			res = append(res, v.(*Role))
+			return true
+		})
+	}
+	return res
+}
+
+// RemoveRoleIn deletes role from domain
+func (r *RBAC) RemoveRoleIn(domain, roleID string) error {
+	return r.removeRoleIn(context.Background(), domain, roleID)
+}
+
+func (r *RBAC) removeRoleIn(ctx context.Context, domain, roleID string) error {
+	if domain == "" {
+		return r.RemoveRoleContext(ctx, roleID)
+	}
+	return r.record(ctx, &Change{Type: EventRoleRemoved, Domain: domain, RoleID: roleID}, func() error {
+		delRole := r.roleIn(domain, roleID)
+		if delRole == nil {
+			log.Errorf("role %s is not registered in domain %s", roleID, domain)
+			return fmt.Errorf("role %s is not registered in domain %s", roleID, domain)
+		}
+		for _, role := range r.RolesIn(domain) {
+			if role.parentOf(roleID) == delRole {
+				role.removeParent(ctx, delRole)
+			}
+		}
+		r.unindexRole(delRole)
+		roles := r.domain(domain, false)
+		roles.Delete(roleID)
+		if isEmpty(roles) {
+			r.domains.Delete(domain)
+		}
+		r.emit(ctx, Event{Type: EventRoleRemoved, Domain: domain, RoleID: roleID})
+		return nil
+	})
+}
+
+// PermitIn grants a permission with defined actions to a role of domain
+func (r *RBAC) PermitIn(domain, roleID string, perm *Permission, actions ...Action) error {
+	return r.permitIn(context.Background(), domain, roleID, perm, actions...)
+}
+
+func (r *RBAC) permitIn(ctx context.Context, domain, roleID string, perm *Permission, actions ...Action) error {
+	return r.modifyRoleContext(ctx, domain, roleID, perm, "permitting to", actions, func(role *Role, actions []Action) {
+		role.grant(perm, actions...)
+	})
+}
+
+// RevokeIn removes a permission from a role of domain
+func (r *RBAC) RevokeIn(domain, roleID string, perm *Permission, actions ...Action) error {
+	return r.revokeIn(context.Background(), domain, roleID, perm, actions...)
+}
+
+func (r *RBAC) revokeIn(ctx context.Context, domain, roleID string, perm *Permission, actions ...Action) error {
+	return r.modifyRoleContext(ctx, domain, roleID, perm, "revoking from", actions, func(role *Role, actions []Action) {
+		role.revoke(perm, actions...)
+	})
+}
+
+// DenyIn explicitly denies actions of a permission for a role of domain
+func (r *RBAC) DenyIn(domain, roleID string, perm *Permission, actions ...Action) error {
+	return r.denyIn(context.Background(), domain, roleID, perm, actions...)
+}
+
+func (r *RBAC) denyIn(ctx context.Context, domain, roleID string, perm *Permission, actions ...Action) error {
+	return r.modifyRoleContext(ctx, domain, roleID, perm, "denying to", actions, func(role *Role, actions []Action) {
+		role.deny(perm, actions...)
+	})
+}
+
+// UndenyIn removes explicit denies of a permission from a role of domain
+func (r *RBAC) UndenyIn(domain, roleID string, perm *Permission, actions ...Action) error {
+	return r.undenyIn(context.Background(), domain, roleID, perm, actions...)
+}
+
+func (r *RBAC) undenyIn(ctx context.Context, domain, roleID string, perm *Permission, actions ...Action) error {
+	return r.modifyRoleContext(ctx, domain, roleID, perm, "undenying from", actions, func(role *Role, actions []Action) {
+		role.undeny(perm, actions...)
+	})
+}
+
+// IsGrantedIn checks if a role with target permission and actions has a grant in domain. Role is looked up in
+// domain first, then in global roles.
+func (r *RBAC) IsGrantedIn(domain, roleID string, perm *Permission, actions ...Action) bool {
+	if perm == nil {
+		log.Errorf("Nil perm is sent for granted check for role %s", roleID)
+		return false
+	}
+	return r.decide(func() bool { return r.isGranted(domain, roleID, perm.ID, actions...) }, func() *Decision {
+		return &Decision{Check: "IsGrantedIn", Domain: domain, Roles: []string{roleID}, Permission: perm.ID, Actions: actions}
+	})
+}
+
+// IsGrantInheritedIn checks if a role with target permission and actions has a grant in domain(including
+// inherited permissions from parents)
+func (r *RBAC) IsGrantInheritedIn(domain, roleID string, perm *Permission, actions ...Action) bool {
+	if perm == nil {
+		log.Errorf("Nil perm is sent for granted check for role %s", roleID)
+		return false
+	}
+	return r.decide(func() bool { return r.isGrantInherited(domain, roleID, perm.ID, actions...) }, func() *Decision {
+		return &Decision{Check: "IsGrantInheritedIn", Domain: domain, Roles: []string{roleID}, Permission: perm.ID, Actions: actions}
+	})
+}
+
+// AnyGrantInheritedIn checks if any role has the permission in domain
+func (r *RBAC) AnyGrantInheritedIn(domain string, roleIDs []string, perm *Permission, actions ...Action) bool {
+	if perm == nil {
+		log.Errorf("Nil perm is sent for granted check for roles %v", roleIDs)
+		return false
+	}
+	return r.decide(func() bool { return r.anyGrantInherited(domain, roleIDs, perm.ID, actions...) }, func() *Decision {
+		return &Decision{Check: "AnyGrantInheritedIn", Domain: domain, Roles: roleIDs, Permission: perm.ID, Actions: actions}
+	})
+}
+
+// DomainRoleGrants returns roles of all domains
+func (r *RBAC) DomainRoleGrants() []*DomainRoles {
+	res := []*DomainRoles{}
+	for _, domain := range r.Domains() {
+		res = append(res, &DomainRoles{ID: domain, Roles: r.roleGrants(r.RolesIn(domain))})
+	}
+	return res
+}
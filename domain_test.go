@@ -0,0 +1,114 @@
+package rbac
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDomain(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	postPerm, _ := R.RegisterPermission("post", "Post resource", CRUD)
+
+	memberRole, _ := R.RegisterRole("member", "Global member role")
+	if err = R.Permit(memberRole.ID, postPerm, Read); err != nil {
+		t.Fatalf("can not permit post.read to member, err: %v", err)
+	}
+	acmeAdmin, err := R.RegisterRoleIn("acme", "admin", "Acme admin")
+	if err != nil {
+		t.Fatalf("can not register admin role in acme, err: %v", err)
+	}
+	if _, err = R.RegisterRoleIn("acme", "admin", "Acme admin"); err == nil {
+		t.Fatalf("should not be able to register admin role in acme twice")
+	}
+	globexAdmin, err := R.RegisterRoleIn("globex", "admin", "Globex admin")
+	if err != nil {
+		t.Fatalf("can not register admin role in globex, err: %v", err)
+	}
+	if R.IsRoleExist("admin") || !R.IsRoleExistIn("acme", "admin") || R.GetRoleIn("initech", "admin") != nil {
+		t.Fatalf("domain roles should not be visible outside their domain")
+	}
+	if !reflect.DeepEqual(R.Domains(), []string{"acme", "globex"}) {
+		t.Fatalf("domains are not valid, got %v", R.Domains())
+	}
+
+	if err = R.PermitIn("acme", "admin", usersPerm, CRUD); err != nil {
+		t.Fatalf("can not permit users.crud to acme admin, err: %v", err)
+	}
+	if err = R.PermitIn("globex", "admin", usersPerm, Read); err != nil {
+		t.Fatalf("can not permit users.read to globex admin, err: %v", err)
+	}
+	if err = R.PermitIn("initech", "admin", usersPerm, Read); err == nil {
+		t.Fatalf("PermitIn should fail with nonexisting domain role")
+	}
+	if err = R.Permit("admin", usersPerm, Read); err == nil {
+		t.Fatalf("Permit should not find domain roles")
+	}
+	if !R.IsGrantedIn("acme", "admin", usersPerm, Delete) || R.IsGrantedIn("globex", "admin", usersPerm, Delete) {
+		t.Fatalf("users.delete should be granted only to acme admin")
+	}
+	if R.IsGranted("admin", usersPerm, Read) {
+		t.Fatalf("domain grants should not leak to global checks")
+	}
+
+	// Global role fallback
+	if !R.IsGrantedIn("acme", memberRole.ID, postPerm, Read) {
+		t.Fatalf("global member role should be resolved in acme domain")
+	}
+	if err = acmeAdmin.AddParent(memberRole); err != nil {
+		t.Fatalf("adding global parent role to domain role failed with: %v", err)
+	}
+	if !R.IsGrantInheritedIn("acme", "admin", postPerm, Read) || R.IsGrantInheritedIn("globex", "admin", postPerm, Read) {
+		t.Fatalf("post.read should be inherited only by acme admin")
+	}
+	if err = globexAdmin.AddParent(acmeAdmin); err == nil {
+		t.Fatalf("should not be able to add parent role of another domain")
+	}
+	if err = memberRole.AddParent(acmeAdmin); err == nil {
+		t.Fatalf("should not be able to add domain parent role to global role")
+	}
+	if !R.AnyGrantInheritedIn("globex", []string{"member", "admin"}, usersPerm, Read) {
+		t.Fatalf("any of globex roles should have users.read")
+	}
+	if err = R.DenyIn("acme", "admin", usersPerm, Delete); err != nil {
+		t.Fatalf("can not deny users.delete to acme admin, err: %v", err)
+	}
+	if R.IsGrantedIn("acme", "admin", usersPerm, Delete) {
+		t.Fatalf("users.delete should be denied for acme admin")
+	}
+
+	// Round trip
+	var buf bytes.Buffer
+	if err = R.SaveJSON(&buf); err != nil {
+		t.Fatalf("unable to save to json, err:%v", err)
+	}
+	R2 := New(nil)
+	R2.RegisterPermission("users", "User resource", CRUD)
+	R2.RegisterPermission("post", "Post resource", CRUD)
+	if err = R2.LoadJSON(&buf); err != nil {
+		t.Fatalf("unable to load from json, err:%v", err)
+	}
+	if !reflect.DeepEqual(R2.Domains(), []string{"acme", "globex"}) || !R2.GetRoleIn("acme", "admin").HasParent(memberRole.ID) {
+		t.Fatalf("loaded domain roles differ")
+	}
+	if !R2.IsGrantInheritedIn("acme", "admin", postPerm, Read) || R2.IsGrantedIn("acme", "admin", usersPerm, Delete) {
+		t.Fatalf("loaded acme admin grants are not valid")
+	}
+
+	if err = R.RemoveRole(memberRole.ID); err != nil {
+		t.Fatalf("can not remove member role, err: %v", err)
+	}
+	if acmeAdmin.HasParent(memberRole.ID) {
+		t.Fatalf("removed global role should be removed from parents of domain roles")
+	}
+	if err = R.RemoveRoleIn("globex", "admin"); err != nil {
+		t.Fatalf("can not remove globex admin role, err: %v", err)
+	}
+	if !reflect.DeepEqual(R.Domains(), []string{"acme"}) {
+		t.Fatalf("empty domain should be removed, got %v", R.Domains())
+	}
+}
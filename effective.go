@@ -0,0 +1,128 @@
+package rbac
+
+import (
+	"sort"
+	"sync"
+)
+
+// EffectivePermission is a permission granted to roles with the actions and roles contributing them
+type EffectivePermission struct {
+	Permission string             `json:"permission"`
+	Actions    []*EffectiveAction `json:"actions"`
+}
+
+// EffectiveAction is an action granted to roles, Roles are the requested roles or their ancestors which the action
+// is granted to. Via are the grants of Roles the action is derived from when it is not granted as is, as
+// "permission:action", like "users:update" for an action implied by update, "*:read" for a wildcard grant or
+// "billing:read" for a grant on an ancestor of a hierarchical permission.
+type EffectiveAction struct {
+	Action Action   `json:"action"`
+	Roles  []string `json:"roles"`
+	Via    []string `json:"via,omitempty"`
+}
+
+// ActionList returns actions of the effective permission
+func (ep *EffectivePermission) ActionList() []Action {
+	res := []Action{}
+	for _, a := range ep.Actions {
+		res = append(res, a.Action)
+	}
+	return res
+}
+
+// EffectivePermissions returns registered permissions granted to roles including permissions inherited from all of
+// their ancestors, sorted by permission and action. Each action is annotated with the roles it is granted to and the
+// grants it is derived from. Implied actions, wildcard grants and grants on ancestors of hierarchical permissions are
+// included like checks do. Actions denied for a requested role are not contributed through it, scheduled grants are
+// included only while they are active, conditional and instance grants are not included.
+func (r *RBAC) EffectivePermissions(roleIDs []string) []*EffectivePermission {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
+	roles := []*Role{}
+	for _, roleID := range roleIDs {
+		role := r.resolveRole("", roleID)
+		if role == nil {
+			log.Errorf("Role with ID %s is not found", roleID)
+			continue
+		}
+		roles = append(roles, role)
+	}
+	res := []*EffectivePermission{}
+	if len(roles) == 0 {
+		return res
+	}
+	now := r.now()
+	for _, perm := range r.sortedPermissions() {
+		q := r.newQuery(perm.ID, perm.sortedActions()...)
+		q.now = now
+		ep := &EffectivePermission{Permission: perm.ID, Actions: []*EffectiveAction{}}
+		for i, a := range q.actions {
+			contrib, via := map[string]bool{}, map[string]bool{}
+			for _, role := range roles {
+				if role.isDeniedInherited(q.perms, a) {
+					continue
+				}
+				for _, rl := range append([]*Role{role}, role.ancestors()...) {
+					if !rl.hasGrant(q, i) {
+						continue
+					}
+					contrib[rl.ID] = true
+					for _, src := range rl.grantSources(q, i) {
+						if src != perm.ID+":"+string(a) {
+							via[src] = true
+						}
+					}
+				}
+			}
+			if len(contrib) == 0 {
+				continue
+			}
+			ea := &EffectiveAction{Action: a, Roles: sortedSet(contrib)}
+			if len(via) > 0 {
+				ea.Via = sortedSet(via)
+			}
+			ep.Actions = append(ep.Actions, ea)
+		}
+		if len(ep.Actions) > 0 {
+			res = append(res, ep)
+		}
+	}
+	return res
+}
+
+// grantSources returns grants and active scheduled grants of the role itself which i'th action of query is granted
+// by, as "permission:action"
+func (r *Role) grantSources(q *query, i int) []string {
+	res := []string{}
+	acts := append(append([]Action{q.actions[i]}, q.implied[i]...), AnyAction)
+	for _, p := range append(append([]string{}, q.perms...), AnyPermission) {
+		for _, a := range acts {
+			src := p + ":" + string(a)
+			if hasString(res, src) {
+				continue
+			}
+			if am, ok := r.Load(p); ok {
+				if v, ok := am.(*sync.Map).Load(a); ok && isTrue(v) {
+					res = append(res, src)
+					continue
+				}
+			}
+			if am, ok := r.scheduled.Load(p); ok && !q.now.IsZero() {
+				if v, ok := am.(*sync.Map).Load(a); ok && q.isActive(v) {
+					res = append(res, src)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// sortedSet returns sorted keys of set
+func sortedSet(set map[string]bool) []string {
+	res := make([]string, 0, len(set))
+	for k := range set {
+		res = append(res, k)
+	}
+	sort.Strings(res)
+	return res
+}
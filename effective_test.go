@@ -0,0 +1,100 @@
+package rbac
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestEffectivePermissions(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	postPerm, _ := R.RegisterPermission("post", "Post resource", CRUD)
+	userRole, _ := R.RegisterRole("user", "User role")
+	editorRole, _ := R.RegisterRole("editor", "Editor role")
+	adminRole, _ := R.RegisterRole("admin", "Admin role")
+	editorRole.AddParent(userRole)
+	adminRole.AddParent(editorRole)
+	R.Permit(userRole.ID, usersPerm, Read)
+	R.Permit(userRole.ID, postPerm, Read, Delete)
+	R.Permit(editorRole.ID, postPerm, Update, Create)
+	R.Permit(adminRole.ID, usersPerm, Update, Read)
+	R.Deny(editorRole.ID, postPerm, Delete)
+
+	expected := []*EffectivePermission{
+		{Permission: "post", Actions: []*EffectiveAction{
+			{Action: Create, Roles: []string{"editor"}},
+			{Action: Read, Roles: []string{"user"}},
+			{Action: Update, Roles: []string{"editor"}},
+		}},
+		{Permission: "users", Actions: []*EffectiveAction{
+			{Action: Read, Roles: []string{"admin", "user"}},
+			{Action: Update, Roles: []string{"admin"}},
+		}},
+	}
+	if eps := R.EffectivePermissions([]string{adminRole.ID}); !reflect.DeepEqual(eps, expected) {
+		t.Fatalf("effective permissions of admin are not valid")
+	}
+	// grandparent grants are included, actions are sorted
+	perms := R.GetAllPermissions([]string{adminRole.ID})
+	if !reflect.DeepEqual(perms, map[string][]Action{"post": {Create, Read, Update}, "users": {Read, Update}}) {
+		t.Fatalf("all permissions of admin are not valid, got %v", perms)
+	}
+	perms = R.GetAllPermissions([]string{userRole.ID, editorRole.ID})
+	if !reflect.DeepEqual(perms["post"], []Action{Create, Delete, Read, Update}) {
+		t.Fatalf("post.delete should be contributed by user role, got %v", perms["post"])
+	}
+	if eps := R.EffectivePermissions([]string{"fake_role"}); len(eps) != 0 {
+		t.Fatalf("nonexisting role should not have permissions")
+	}
+}
+
+func TestEffectivePermissionsDerived(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterPermission("post", "Post resource", Read)
+	R.RegisterPermission("billing", "Billing", Read)
+	R.RegisterPermission("billing.invoices", "Invoices", Read)
+	R.SetHierarchical(true)
+	R.AddImplication(Update, Read)
+	R.RegisterRole("editor", "Editor role")
+	R.RegisterRole("auditor", "Auditor role")
+	R.Permit("editor", usersPerm, Update)
+	R.Permit("auditor", R.GetPermission(AnyPermission), Read)
+	R.Deny("auditor", usersPerm, Read)
+
+	perms := R.GetAllPermissions([]string{"editor"})
+	if !reflect.DeepEqual(perms, map[string][]Action{"users": {Read, Update}}) {
+		t.Fatalf("implied actions should be effective, got %v", perms)
+	}
+	if !R.IsGrantInheritedStr("editor", "users", Read) {
+		t.Fatalf("editor should have implied users.read")
+	}
+	expected := []*EffectivePermission{
+		{Permission: "users", Actions: []*EffectiveAction{
+			{Action: Read, Roles: []string{"editor"}, Via: []string{"users:update"}},
+			{Action: Update, Roles: []string{"editor"}},
+		}},
+	}
+	if eps := R.EffectivePermissions([]string{"editor"}); !reflect.DeepEqual(eps, expected) {
+		t.Fatalf("implied action should be annotated with its grant")
+	}
+
+	expected = []*EffectivePermission{
+		{Permission: "billing", Actions: []*EffectiveAction{{Action: Read, Roles: []string{"auditor"}, Via: []string{"*:read"}}}},
+		{Permission: "billing.invoices", Actions: []*EffectiveAction{{Action: Read, Roles: []string{"auditor"}, Via: []string{"*:read"}}}},
+		{Permission: "post", Actions: []*EffectiveAction{{Action: Read, Roles: []string{"auditor"}, Via: []string{"*:read"}}}},
+	}
+	if eps := R.EffectivePermissions([]string{"auditor"}); !reflect.DeepEqual(eps, expected) {
+		t.Fatalf("wildcard grant should be effective on registered permissions except denied ones")
+	}
+
+	R.Revoke("auditor", R.GetPermission(AnyPermission), Read)
+	R.Permit("auditor", R.GetPermission("billing"), Read)
+	perms = R.GetAllPermissions([]string{"auditor"})
+	if !reflect.DeepEqual(perms, map[string][]Action{"billing": {Read}, "billing.invoices": {Read}}) {
+		t.Fatalf("grants on ancestors of hierarchical permissions should be effective, got %v", perms)
+	}
+}
@@ -0,0 +1,233 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType is type of a change event
+type EventType string
+
+const (
+	// EventRoleRegistered is emitted when a role is registered
+	EventRoleRegistered EventType = "role_registered"
+	// EventRoleRemoved is emitted when a role is removed
+	EventRoleRemoved EventType = "role_removed"
+	// EventPermissionRegistered is emitted when a permission is registered
+	EventPermissionRegistered EventType = "permission_registered"
+	// EventPermit is emitted when actions are permitted to a role, including conditional, instance and scheduled
+	// grants which have Condition, Instance or Schedule set
+	EventPermit EventType = "permit"
+	// EventRevoke is emitted when actions are revoked from a role, including conditional, instance and scheduled
+	// grants which have Condition or Instance set
+	EventRevoke EventType = "revoke"
+	// EventDeny is emitted when actions are denied for a role
+	EventDeny EventType = "deny"
+	// EventUndeny is emitted when denies are removed from a role
+	EventUndeny EventType = "undeny"
+	// EventParentAdded is emitted when a parent is added to a role
+	EventParentAdded EventType = "parent_added"
+	// EventParentRemoved is emitted when a parent is removed from a role
+	EventParentRemoved EventType = "parent_removed"
+	// EventRoleAssigned is emitted when a role is assigned to a subject
+	EventRoleAssigned EventType = "role_assigned"
+	// EventRoleUnassigned is emitted when a role assignment is removed from a subject
+	EventRoleUnassigned EventType = "role_unassigned"
+	// EventBulkLoad is emitted once when LoadJSON is finished, changes during loading are not emitted one by one
+	EventBulkLoad EventType = "bulk_load"
+	// EventPolicyChanged is emitted for other changes like implications, composite actions and constraints,
+	// Detail describes the change
+	EventPolicyChanged EventType = "policy_changed"
+	// EventDisconnected is the last event delivered to a subscriber which is disconnected as it falls behind,
+	// Revision is the first revision which is not delivered
+	EventDisconnected EventType = "disconnected"
+)
+
+// Event is a change event of an RBAC instance
+type Event struct {
+	Type EventType `json:"type"`
+	// Revision is the revision of the instance after the change, it is increased monotonically
+	Revision   uint64    `json:"revision"`
+	Time       time.Time `json:"time"`
+	Domain     string    `json:"domain,omitempty"`
+	RoleID     string    `json:"role_id,omitempty"`
+	ParentID   string    `json:"parent_id,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	Permission string    `json:"permission,omitempty"`
+	Actions    []Action  `json:"actions,omitempty"`
+	// Condition, Instance and Schedule are set for permitted and revoked conditional, instance and scheduled grants,
+	// Schedule is not set for revoked scheduled grants
+	Condition string    `json:"condition,omitempty"`
+	Instance  string    `json:"instance,omitempty"`
+	Schedule  *Schedule `json:"schedule,omitempty"`
+	Detail    string    `json:"detail,omitempty"`
+}
+
+// watchers are subscribers of change events
+type watchers struct {
+	mu   sync.Mutex
+	next int
+	subs map[int]*subscriber
+}
+
+// maxQueuedEvents is the maximum number of events queued for a subscriber, a subscriber falling further behind is
+// disconnected
+const maxQueuedEvents = 10000
+
+// subscriber queues events and calls fn in its own goroutine, so a slow subscriber does not block mutators
+type subscriber struct {
+	fn      func(Event)
+	onStop  func() // called from the goroutine of fn when it is stopped, if set
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   []Event
+	closed  bool
+	closing bool          // set when disconnected, run returns once queue is delivered
+	stopped chan struct{} // closed when run returns
+}
+
+// loadingKey marks contexts of mutations made by LoadJSON
+type loadingKey struct{}
+
+// withLoading returns a copy of ctx marking mutations made with it as made by LoadJSON
+func withLoading(ctx context.Context) context.Context {
+	return context.WithValue(ctx, loadingKey{}, true)
+}
+
+// isLoading checks if ctx is marked by withLoading
+func isLoading(ctx context.Context) bool {
+	return ctx.Value(loadingKey{}) != nil
+}
+
+// emit increases revision of the instance and dispatches ev to subscribers, it is called after each mutation made
+// with ctx. Events of mutations made by LoadJSON are not dispatched, it emits a single bulk load event instead.
+func (r *RBAC) emit(ctx context.Context, ev Event) {
+	r.watchers.mu.Lock()
+	defer r.watchers.mu.Unlock()
+	ev.Revision = atomic.AddUint64(&r.revision, 1)
+	if isLoading(ctx) {
+		return
+	}
+	ev.Time = r.now()
+	for id, s := range r.watchers.subs {
+		if !s.push(ev) {
+			log.Errorf("subscriber is disconnected as it has %d events queued", maxQueuedEvents)
+			delete(r.watchers.subs, id)
+			s.disconnect(ev.Time)
+		}
+	}
+}
+
+// Subscribe calls fn for each change event in order of revisions, fn is called from a separate goroutine per
+// subscriber. Events are queued while fn is running, a subscriber with 10000 events queued is disconnected and
+// its queued events are dropped, so a blocked subscriber does not grow memory forever. fn is then called a last
+// time with an EventDisconnected event. Returned function cancels the subscription, events queued are dropped.
+func (r *RBAC) Subscribe(fn func(Event)) (cancel func()) {
+	_, cancel = r.subscribe(fn, nil)
+	return cancel
+}
+
+func (r *RBAC) subscribe(fn func(Event), onStop func()) (*subscriber, func()) {
+	s := &subscriber{fn: fn, onStop: onStop, stopped: make(chan struct{})}
+	s.cond = sync.NewCond(&s.mu)
+	r.watchers.mu.Lock()
+	if r.watchers.subs == nil {
+		r.watchers.subs = map[int]*subscriber{}
+	}
+	id := r.watchers.next
+	r.watchers.next++
+	r.watchers.subs[id] = s
+	r.watchers.mu.Unlock()
+	go s.run()
+	var once sync.Once
+	return s, func() {
+		once.Do(func() {
+			r.watchers.mu.Lock()
+			delete(r.watchers.subs, id)
+			r.watchers.mu.Unlock()
+			s.close()
+		})
+	}
+}
+
+// Watch returns a channel of change events, channel is closed when the returned function is called. Like
+// Subscribe, a watcher which does not receive while 10000 events are queued is disconnected, it receives an
+// EventDisconnected event and then its channel is closed.
+func (r *RBAC) Watch() (<-chan Event, func()) {
+	ch := make(chan Event)
+	done := make(chan struct{})
+	var once sync.Once
+	s, cancel := r.subscribe(func(ev Event) {
+		select {
+		case ch <- ev:
+		case <-done:
+		}
+	}, func() { close(ch) })
+	return ch, func() {
+		once.Do(func() {
+			cancel()
+			close(done)
+			<-s.stopped
+		})
+	}
+}
+
+// push queues ev, it returns false if the queue is full
+func (s *subscriber) push(ev Event) bool {
+	s.mu.Lock()
+	if len(s.queue) >= maxQueuedEvents {
+		s.mu.Unlock()
+		return false
+	}
+	s.queue = append(s.queue, ev)
+	s.mu.Unlock()
+	s.cond.Signal()
+	return true
+}
+
+// disconnect drops queued events and queues a single EventDisconnected event, run returns after delivering it
+func (s *subscriber) disconnect(t time.Time) {
+	s.mu.Lock()
+	if s.closed || s.closing {
+		s.mu.Unlock()
+		return
+	}
+	ev := Event{Type: EventDisconnected, Revision: s.queue[0].Revision, Time: t,
+		Detail: fmt.Sprintf("subscriber is disconnected as it has %d events queued", maxQueuedEvents)}
+	s.queue = []Event{ev}
+	s.closing = true
+	s.mu.Unlock()
+	s.cond.Signal()
+}
+
+func (s *subscriber) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.queue = nil
+	s.mu.Unlock()
+	s.cond.Signal()
+}
+
+func (s *subscriber) run() {
+	defer close(s.stopped)
+	if s.onStop != nil {
+		defer s.onStop()
+	}
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.closed && !s.closing {
+			s.cond.Wait()
+		}
+		if s.closed || len(s.queue) == 0 {
+			s.mu.Unlock()
+			return
+		}
+		ev := s.queue[0]
+		s.queue = s.queue[1:]
+		s.mu.Unlock()
+		s.fn(ev)
+	}
+}
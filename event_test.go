@@ -0,0 +1,227 @@
+package rbac
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEvents(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	ch, cancel := R.Watch()
+	blocked := make(chan struct{})
+	cancelSlow := R.Subscribe(func(ev Event) {
+		<-blocked
+	})
+
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	userRole, _ := R.RegisterRole("user", "User role")
+	adminRole, _ := R.RegisterRole("admin", "Admin role")
+	R.Permit(userRole.ID, usersPerm, Read)
+	R.Revoke(userRole.ID, usersPerm, Read)
+	adminRole.AddParent(userRole)
+	adminRole.RemoveParent(userRole)
+	R.RemoveRole(adminRole.ID)
+
+	var buf bytes.Buffer
+	R.SaveJSON(&buf)
+	R2 := New(nil)
+	R2.RegisterPermission("users", "User resource", CRUD)
+	ch2, cancel2 := R2.Watch()
+	R2.LoadJSON(&buf)
+
+	expected := []Event{
+		{Type: EventPermissionRegistered, Permission: "users"},
+		{Type: EventRoleRegistered, RoleID: "user"},
+		{Type: EventRoleRegistered, RoleID: "admin"},
+		{Type: EventPermit, RoleID: "user", Permission: "users", Actions: []Action{Read}},
+		{Type: EventRevoke, RoleID: "user", Permission: "users", Actions: []Action{Read}},
+		{Type: EventParentAdded, RoleID: "admin", ParentID: "user"},
+		{Type: EventParentRemoved, RoleID: "admin", ParentID: "user"},
+		{Type: EventRoleRemoved, RoleID: "admin"},
+	}
+	var revision uint64
+	for i, e := range expected {
+		select {
+		case ev := <-ch:
+			if ev.Type != e.Type || ev.RoleID != e.RoleID || ev.ParentID != e.ParentID || ev.Permission != e.Permission {
+				t.Fatalf("event %d is not valid, expected %+v, got %+v", i, e, ev)
+			}
+			if e.Actions != nil && (len(ev.Actions) != 1 || ev.Actions[0] != e.Actions[0]) {
+				t.Fatalf("event %d actions are not valid, got %v", i, ev.Actions)
+			}
+			if ev.Revision <= revision {
+				t.Fatalf("event revisions should increase monotonically")
+			}
+			revision = ev.Revision
+		case <-time.After(time.Second):
+			t.Fatalf("event %d is not received, slow subscriber should not block others", i)
+		}
+	}
+	if revision != R.Revision() {
+		t.Fatalf("last event revision should be the instance revision")
+	}
+
+	select {
+	case ev := <-ch2:
+		if ev.Type != EventBulkLoad || ev.Revision != R2.Revision() {
+			t.Fatalf("LoadJSON should emit a single bulk load event, got %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("bulk load event is not received")
+	}
+
+	cancel()
+	if _, ok := <-ch; ok {
+		t.Fatalf("watch channel should be closed after cancel")
+	}
+	cancelSlow()
+	close(blocked)
+	cancel2()
+}
+
+func TestEventsSlowWatcher(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	ch, cancel := R.Watch()
+	defer cancel()
+	blocked := make(chan struct{})
+	disconnected := make(chan Event, 1)
+	cancelSlow := R.Subscribe(func(ev Event) {
+		<-blocked
+		if ev.Type == EventDisconnected {
+			disconnected <- ev
+		}
+	})
+	defer cancelSlow()
+
+	for i := 0; i <= maxQueuedEvents+1; i++ {
+		R.emit(context.Background(), Event{Type: EventRoleRegistered, RoleID: "user"})
+	}
+	R.watchers.mu.Lock()
+	subs := len(R.watchers.subs)
+	R.watchers.mu.Unlock()
+	if subs != 0 {
+		t.Fatalf("subscribers falling behind should be disconnected, %d left", subs)
+	}
+	close(blocked)
+	select {
+	case ev := <-disconnected:
+		if ev.Revision != 2 {
+			t.Fatalf("disconnected event should have the first revision not delivered, got %d", ev.Revision)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("disconnected subscriber should receive a disconnected event")
+	}
+	received := 0
+	var last Event
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				if received > maxQueuedEvents+1 {
+					t.Fatalf("disconnected watcher should not receive all events")
+				}
+				if last.Type != EventDisconnected {
+					t.Fatalf("last event of a disconnected watcher should be disconnected, got %s", last.Type)
+				}
+				return
+			}
+			received++
+			last = ev
+		case <-timeout:
+			t.Fatalf("channel of a disconnected watcher should be closed")
+		}
+	}
+}
+
+func TestEventsDuringLoad(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterRole("user", "User role")
+	ch, cancel := R.Watch()
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			doc := fmt.Sprintf(`{"roles": [{"id": "role%d", "grants": {"users": ["read"]}, "parents": []}]}`, i)
+			R.LoadJSON(strings.NewReader(doc))
+		}(i)
+	}
+	for i := 0; i < 200; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			R.Permit("user", usersPerm, Read)
+		}()
+	}
+	wg.Wait()
+
+	permits, loads := 0, 0
+	for permits < 200 || loads < 10 {
+		select {
+		case ev := <-ch:
+			switch ev.Type {
+			case EventPermit:
+				permits++
+			case EventBulkLoad:
+				loads++
+			default:
+				t.Fatalf("only permit and bulk load events are expected, got %+v", ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("events of mutations concurrent with loading should be delivered, got %d permits and %d loads", permits, loads)
+		}
+	}
+}
+
+func TestEventsScopedGrants(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	R.RegisterCondition("owner", func(ctx context.Context, req *Request) bool { return true })
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterRole("user", "User role")
+	R.EnableAdminLog(nil)
+	schedule := &Schedule{Windows: []*Window{{Start: "09:00", End: "17:00"}}}
+	ch, cancel := R.Watch()
+	defer cancel()
+
+	R.PermitIf("user", usersPerm, "owner", Update)
+	R.RevokeIf("user", usersPerm, "owner", Update)
+	R.PermitInstance("user", usersPerm, "42", Read)
+	R.RevokeInstance("user", usersPerm, "42", Read)
+	R.PermitScheduled("user", usersPerm, schedule, Read)
+	R.RevokeScheduled("user", usersPerm, Read)
+
+	expected := []Event{
+		{Type: EventPermit, Condition: "owner"},
+		{Type: EventRevoke, Condition: "owner"},
+		{Type: EventPermit, Instance: "42"},
+		{Type: EventRevoke, Instance: "42"},
+		{Type: EventPermit, Schedule: schedule},
+		{Type: EventRevoke},
+	}
+	changes := R.AdminLog(ChangeFilter{RoleID: "user"})
+	if len(changes) != len(expected) {
+		t.Fatalf("expected %d changes, got %d", len(expected), len(changes))
+	}
+	for i, e := range expected {
+		ev := <-ch
+		if ev.Type != e.Type || ev.Condition != e.Condition || ev.Instance != e.Instance || ev.Schedule != e.Schedule {
+			t.Fatalf("event %d should be %+v, got %+v", i, e, ev)
+		}
+		c := changes[i]
+		if c.Type != e.Type || c.Condition != e.Condition || c.Instance != e.Instance || c.Schedule != e.Schedule {
+			t.Fatalf("change %d should be %+v, got %+v", i, e, c)
+		}
+	}
+}
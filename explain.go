@@ -0,0 +1,139 @@
+package rbac
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Explanation is the result of Explain, describes why a permission is granted to roles or not
+type Explanation struct {
+	Permission string   `json:"permission"`
+	Roles      []string `json:"roles"`
+	// Allowed is true if any of roles is granted all actions, same as AnyGrantInheritedStr. Actions may be allowed
+	// one by one by different roles while Allowed is false.
+	Allowed bool                 `json:"allowed"`
+	Actions []*ActionExplanation `json:"actions"`
+	// Missing are actions which are not granted by any of roles
+	Missing []Action `json:"missing"`
+	// Reason is set when roles are not evaluated at all, like activating roles violating a dynamic separation of
+	// duty constraint
+	Reason string `json:"reason,omitempty"`
+}
+
+// ActionExplanation describes decision for an action
+type ActionExplanation struct {
+	Action  Action `json:"action"`
+	Allowed bool   `json:"allowed"`
+	// Role is the requested role which is granted the action
+	Role string `json:"role,omitempty"`
+	// Chain is the inheritance chain from Role to the role which the action is granted to (child -> parent ->
+	// grandparent), it is only Role if action is granted to it directly
+	Chain []string `json:"chain,omitempty"`
+	// Reason describes why action is allowed or not
+	Reason string `json:"reason"`
+}
+
+// String returns a human readable explanation
+func (e *Explanation) String() string {
+	var sb strings.Builder
+	decision := "denied"
+	if e.Allowed {
+		decision = "allowed"
+	}
+	fmt.Fprintf(&sb, "%s for roles %v on %s", decision, e.Roles, e.Permission)
+	if e.Reason != "" {
+		fmt.Fprintf(&sb, ": %s", e.Reason)
+	}
+	for _, a := range e.Actions {
+		fmt.Fprintf(&sb, "\n  %s: %s", a.Action, a.Reason)
+	}
+	return sb.String()
+}
+
+// Explain explains decision of AnyGrantInheritedStr for roles, for each action it reports the role and inheritance
+// chain the action is granted by, or why it is not granted
+func (r *RBAC) Explain(roleIDs []string, permID string, actions ...Action) *Explanation {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
+	res := &Explanation{Permission: permID, Roles: roleIDs, Actions: []*ActionExplanation{}, Missing: []Action{}}
+	if len(roleIDs) > 1 {
+		if err := r.checkSoD(r.effectiveRoleIDs("", roleIDs), true); err != nil {
+			res.Reason = err.Error()
+		}
+	}
+	q := r.newQuery(permID, actions...)
+	roles := []*Role{}
+	for _, roleID := range roleIDs {
+		if role := r.resolveRole("", roleID); role != nil {
+			roles = append(roles, role)
+		}
+	}
+	for _, role := range roles {
+		if res.Reason == "" && role.isGrantInheritedQ(q) {
+			res.Allowed = true
+			break
+		}
+	}
+	for i, a := range q.actions {
+		ae := &ActionExplanation{Action: a, Reason: "not granted"}
+		switch {
+		case res.Reason != "":
+			ae.Reason = res.Reason
+		case !r.IsPermissionExist(permID, a):
+			ae.Reason = fmt.Sprintf("action %s is not registered for permission %s", a, permID)
+		default:
+			for _, role := range roles {
+				if chain := role.denyChain(q.perms, a); chain != nil {
+					ae.Reason = fmt.Sprintf("denied by %s", strings.Join(chain, " -> "))
+					continue
+				}
+				if chain := role.grantChain(q, i); chain != nil {
+					ae.Allowed, ae.Role, ae.Chain = true, role.ID, chain
+					ae.Reason = fmt.Sprintf("granted by %s", strings.Join(chain, " -> "))
+					break
+				}
+			}
+		}
+		if !ae.Allowed {
+			res.Missing = append(res.Missing, a)
+		}
+		res.Actions = append(res.Actions, ae)
+	}
+	return res
+}
+
+// grantChain returns the inheritance chain from the role to its nearest ancestor which i'th action of query is
+// granted to, nil if not granted
+func (r *Role) grantChain(q *query, i int) []string {
+	return r.findChain(func(rl *Role) bool { return rl.hasGrant(q, i) })
+}
+
+// denyChain returns the inheritance chain from the role to its nearest ancestor which action is denied on, nil if
+// not denied
+func (r *Role) denyChain(pIDs []string, a Action) []string {
+	return r.findChain(func(rl *Role) bool { return rl.isDenied(pIDs, a) })
+}
+
+// findChain returns IDs of roles from the role to its nearest ancestor matching fn
+func (r *Role) findChain(fn func(rl *Role) bool) []string {
+	prev := map[*Role]*Role{r: nil}
+	queue := []*Role{r}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if fn(cur) {
+			chain := []string{}
+			for rl := cur; rl != nil; rl = prev[rl] {
+				chain = append([]string{rl.ID}, chain...)
+			}
+			return chain
+		}
+		for _, p := range cur.Parents() {
+			if _, ok := prev[p]; !ok {
+				prev[p] = cur
+				queue = append(queue, p)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package rbac
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	userRole, _ := R.RegisterRole("user", "User role")
+	editorRole, _ := R.RegisterRole("editor", "Editor role")
+	adminRole, _ := R.RegisterRole("admin", "Admin role")
+	guestRole, _ := R.RegisterRole("guest", "Guest role")
+	editorRole.AddParent(userRole)
+	adminRole.AddParent(editorRole)
+	R.Permit(userRole.ID, usersPerm, Read)
+	R.Permit(editorRole.ID, usersPerm, Update)
+	R.Permit(adminRole.ID, usersPerm, Create, Delete)
+	R.Deny(editorRole.ID, usersPerm, Delete)
+	R.Permit(guestRole.ID, usersPerm, Delete)
+
+	e := R.Explain([]string{adminRole.ID}, usersPerm.ID, Read, Update)
+	if !e.Allowed || len(e.Missing) != 0 || len(e.Actions) != 2 {
+		t.Fatalf("admin should be allowed users.read and users.update, got %s", e)
+	}
+	if e.Actions[0].Role != adminRole.ID || !reflect.DeepEqual(e.Actions[0].Chain, []string{"admin", "editor", "user"}) {
+		t.Fatalf("users.read should be inherited from user, got %v", e.Actions[0].Chain)
+	}
+	if !reflect.DeepEqual(e.Actions[1].Chain, []string{"admin", "editor"}) {
+		t.Fatalf("users.update should be inherited from editor, got %v", e.Actions[1].Chain)
+	}
+
+	e = R.Explain([]string{adminRole.ID}, usersPerm.ID, Create, Delete)
+	if e.Allowed || !reflect.DeepEqual(e.Missing, []Action{Delete}) {
+		t.Fatalf("admin should be missing users.delete, got %s", e)
+	}
+	if e.Actions[1].Reason != "denied by admin -> editor" {
+		t.Fatalf("users.delete should be denied by editor, got %s", e.Actions[1].Reason)
+	}
+
+	e = R.Explain([]string{adminRole.ID, guestRole.ID}, usersPerm.ID, Create, Delete)
+	if e.Allowed || len(e.Missing) != 0 || e.Actions[1].Role != guestRole.ID {
+		t.Fatalf("actions should be allowed by different roles but not allowed together, got %s", e)
+	}
+	if e.Allowed != R.AnyGrantInheritedStr([]string{adminRole.ID, guestRole.ID}, usersPerm.ID, Create, Delete) {
+		t.Fatalf("explanation should match AnyGrantInheritedStr")
+	}
+
+	e = R.Explain([]string{userRole.ID}, usersPerm.ID, CRUD)
+	if len(e.Actions) != 4 || !reflect.DeepEqual(e.Missing, []Action{Create, Update, Delete}) {
+		t.Fatalf("composite action should be explained per action, got %s", e)
+	}
+	e = R.Explain([]string{userRole.ID}, usersPerm.ID, "approve")
+	if e.Allowed || e.Actions[0].Reason != "action approve is not registered for permission users" {
+		t.Fatalf("unregistered action should be explained, got %s", e)
+	}
+
+	R.AddDynamicSoD("admin-guest", adminRole.ID, guestRole.ID)
+	e = R.Explain([]string{adminRole.ID, guestRole.ID}, usersPerm.ID, Create)
+	if e.Allowed || e.Reason == "" || e.Actions[0].Allowed {
+		t.Fatalf("conflicting roles should not be evaluated, got %s", e)
+	}
+}
@@ -0,0 +1,112 @@
+package rbac
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// PermissionSeparator separates levels of hierarchical permission IDs like `billing.invoices.lines`
+const PermissionSeparator = "."
+
+// PermissionNode is a node of permission tree
+type PermissionNode struct {
+	ID          string            `json:"id"`
+	Description string            `json:"description"`
+	Actions     []Action          `json:"actions"`
+	Children    []*PermissionNode `json:"children"`
+}
+
+// SetHierarchical enables or disables hierarchical permission semantics. When enabled, a grant or deny on a
+// permission is also valid for all its descendants, e.g. a grant on `billing` implies the same actions on
+// `billing.invoices` and `billing.invoices.lines`. It should be set before checks are started.
+func (r *RBAC) SetHierarchical(enabled bool) {
+	r.hierarchical = enabled
+	r.emit(context.Background(), Event{Type: EventPolicyChanged, Detail: "hierarchical permissions set"})
+}
+
+// IsHierarchical checks if hierarchical permission semantics are enabled
+func (r *RBAC) IsHierarchical() bool {
+	return r.hierarchical
+}
+
+// permPath returns permission ID followed by IDs of its ancestors if hierarchical semantics are enabled and the
+// permission is registered, unregistered permissions do not inherit grants of their ancestors
+func (r *RBAC) permPath(permID string) []string {
+	if !r.hierarchical || permID == AnyPermission {
+		return []string{permID}
+	}
+	if _, ok := r.permissions.Load(permID); !ok {
+		return []string{permID}
+	}
+	return append([]string{permID}, permAncestors(permID)...)
+}
+
+// permAncestors returns ancestor paths of a permission ID, nearest first
+func permAncestors(permID string) []string {
+	res := []string{}
+	for i := strings.LastIndex(permID, PermissionSeparator); i > 0; i = strings.LastIndex(permID, PermissionSeparator) {
+		permID = permID[:i]
+		res = append(res, permID)
+	}
+	return res
+}
+
+// ParentPermission returns nearest registered ancestor of a permission, nil if it is a root permission
+func (r *RBAC) ParentPermission(permID string) *Permission {
+	for _, pID := range permAncestors(permID) {
+		if perm, ok := r.permissions.Load(pID); ok {
+			return perm.(*Permission)
+		}
+	}
+	return nil
+}
+
+// ChildPermissions returns sorted list of registered permissions whose parent is permID. Root permissions are
+// returned for empty permID.
+func (r *RBAC) ChildPermissions(permID string) []*Permission {
+	res := []*Permission{}
+	for _, perm := range r.sortedPermissions() {
+		parent := r.ParentPermission(perm.ID)
+		if (parent == nil && permID == "") || (parent != nil && parent.ID == permID) {
+			res = append(res, perm)
+		}
+	}
+	return res
+}
+
+// DescendantPermissions returns sorted list of all registered permissions under permID
+func (r *RBAC) DescendantPermissions(permID string) []*Permission {
+	res := []*Permission{}
+	for _, perm := range r.sortedPermissions() {
+		if strings.HasPrefix(perm.ID, permID+PermissionSeparator) {
+			res = append(res, perm)
+		}
+	}
+	return res
+}
+
+// PermissionTree returns registered permissions as a tree sorted by ID
+func (r *RBAC) PermissionTree() []*PermissionNode {
+	return r.permissionNodes("")
+}
+
+func (r *RBAC) permissionNodes(permID string) []*PermissionNode {
+	res := []*PermissionNode{}
+	for _, perm := range r.ChildPermissions(permID) {
+		res = append(res, &PermissionNode{
+			ID:          perm.ID,
+			Description: perm.Description,
+			Actions:     perm.sortedActions(),
+			Children:    r.permissionNodes(perm.ID),
+		})
+	}
+	return res
+}
+
+// sortedPermissions returns all permissions sorted by ID
+func (r *RBAC) sortedPermissions() []*Permission {
+	res := r.Permissions()
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
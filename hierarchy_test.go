@@ -0,0 +1,111 @@
+package rbac
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func permIDs(perms []*Permission) []string {
+	res := []string{}
+	for _, p := range perms {
+		res = append(res, p.ID)
+	}
+	return res
+}
+
+func TestHierarchy(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	billingPerm, err := R.RegisterPermission("billing", "Billing", CRUD)
+	if err != nil {
+		t.Fatalf("can not register billing permission, err: %v", err)
+	}
+	invoicesPerm, _ := R.RegisterPermission("billing.invoices", "Invoices", CRUD)
+	linesPerm, _ := R.RegisterPermission("billing.invoices.lines", "Invoice lines", Read, Update)
+	paymentsPerm, _ := R.RegisterPermission("billing.payments", "Payments", Read)
+	usersPerm, _ := R.RegisterPermission("users", "Users", CRUD)
+	R.RegisterPermission("users.groups.members", "Group members", Read)
+
+	accountantRole, _ := R.RegisterRole("accountant", "Accountant role")
+	if err = R.Permit(accountantRole.ID, billingPerm, Read, Update); err != nil {
+		t.Fatalf("can not permit billing actions, err: %v", err)
+	}
+
+	// Without hierarchy grants are flat
+	if R.IsHierarchical() {
+		t.Fatalf("hierarchy should be disabled by default")
+	}
+	if R.IsGranted(accountantRole.ID, invoicesPerm, Read) {
+		t.Fatalf("accountant should not have billing.invoices.read without hierarchy")
+	}
+
+	R.SetHierarchical(true)
+	if !R.IsGranted(accountantRole.ID, invoicesPerm, Read, Update) {
+		t.Fatalf("accountant should have billing.invoices.read and update from billing")
+	}
+	if !R.IsGrantInherited(accountantRole.ID, linesPerm, Update) {
+		t.Fatalf("accountant should have billing.invoices.lines.update from billing")
+	}
+	if R.IsGranted(accountantRole.ID, invoicesPerm, Delete) {
+		t.Fatalf("accountant should not have billing.invoices.delete")
+	}
+	if R.IsGranted(accountantRole.ID, usersPerm, Read) {
+		t.Fatalf("accountant should not have users.read")
+	}
+	if R.IsGranted(accountantRole.ID, paymentsPerm, Update) {
+		t.Fatalf("accountant should not have billing.payments.update as it is not registered")
+	}
+	if R.IsGrantInheritedStr(accountantRole.ID, "billing.nope", Read) {
+		t.Fatalf("accountant should not have billing.nope.read as billing.nope is not registered")
+	}
+
+	// Denies follow the hierarchy too
+	if err = R.Deny(accountantRole.ID, invoicesPerm, Update); err != nil {
+		t.Fatalf("can not deny billing.invoices.update, err: %v", err)
+	}
+	if R.IsGranted(accountantRole.ID, linesPerm, Update) {
+		t.Fatalf("accountant should not have billing.invoices.lines.update as billing.invoices.update is denied")
+	}
+	if !R.IsGranted(accountantRole.ID, billingPerm, Update) {
+		t.Fatalf("accountant should still have billing.update")
+	}
+
+	// Clone keeps hierarchy
+	if !R.Clone(true).IsGranted(accountantRole.ID, paymentsPerm, Read) {
+		t.Fatalf("cloned instance should have hierarchical semantics")
+	}
+
+	// Listing
+	if p := R.ParentPermission(linesPerm.ID); p == nil || p.ID != invoicesPerm.ID {
+		t.Fatalf("parent of billing.invoices.lines should be billing.invoices, got %v", p)
+	}
+	if p := R.ParentPermission("users.groups.members"); p == nil || p.ID != usersPerm.ID {
+		t.Fatalf("parent of users.groups.members should be nearest registered ancestor users, got %v", p)
+	}
+	if p := R.ParentPermission(billingPerm.ID); p != nil {
+		t.Fatalf("billing should not have a parent, got %v", p)
+	}
+	if ids := permIDs(R.ChildPermissions("")); !reflect.DeepEqual(ids, []string{"billing", "users"}) {
+		t.Fatalf("root permissions are not valid, got %v", ids)
+	}
+	if ids := permIDs(R.ChildPermissions(billingPerm.ID)); !reflect.DeepEqual(ids, []string{"billing.invoices", "billing.payments"}) {
+		t.Fatalf("children of billing are not valid, got %v", ids)
+	}
+	if ids := permIDs(R.DescendantPermissions(billingPerm.ID)); !reflect.DeepEqual(ids, []string{"billing.invoices", "billing.invoices.lines", "billing.payments"}) {
+		t.Fatalf("descendants of billing are not valid, got %v", ids)
+	}
+
+	tree := R.PermissionTree()
+	if len(tree) != 2 || tree[0].ID != billingPerm.ID || len(tree[0].Children) != 2 {
+		t.Fatalf("permission tree is not valid")
+	}
+	if lines := tree[0].Children[0].Children; len(lines) != 1 || lines[0].ID != linesPerm.ID {
+		t.Fatalf("billing.invoices should have billing.invoices.lines child")
+	}
+	if !reflect.DeepEqual(tree[0].Children[0].Children[0].Actions, []Action{Read, Update}) {
+		t.Fatalf("actions of billing.invoices.lines are not valid, got %v", tree[0].Children[0].Children[0].Actions)
+	}
+	if _, err = json.Marshal(tree); err != nil {
+		t.Fatalf("permission tree marshall failed with %v", err)
+	}
+}
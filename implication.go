@@ -0,0 +1,240 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Implication defines actions implied by an action, used during JSON Marshalling
+type Implication struct {
+	// Permission is empty for global implications
+	Permission string   `json:"permission,omitempty"`
+	Action     Action   `json:"action"`
+	Implies    []Action `json:"implies"`
+}
+
+// implicationGraph keeps implication edges, key: permissionID("" for global), value: action -> implied actions
+type implicationGraph struct {
+	sync.RWMutex
+	edges map[string]map[Action]map[Action]struct{}
+}
+
+func (g *implicationGraph) add(permID string, action, implied Action) {
+	g.Lock()
+	defer g.Unlock()
+	if g.edges == nil {
+		g.edges = map[string]map[Action]map[Action]struct{}{}
+	}
+	if g.edges[permID] == nil {
+		g.edges[permID] = map[Action]map[Action]struct{}{}
+	}
+	if g.edges[permID][action] == nil {
+		g.edges[permID][action] = map[Action]struct{}{}
+	}
+	g.edges[permID][action][implied] = struct{}{}
+}
+
+func (g *implicationGraph) remove(permID string, action, implied Action) bool {
+	g.Lock()
+	defer g.Unlock()
+	if _, ok := g.edges[permID][action][implied]; !ok {
+		return false
+	}
+	delete(g.edges[permID][action], implied)
+	if len(g.edges[permID][action]) == 0 {
+		delete(g.edges[permID], action)
+	}
+	if len(g.edges[permID]) == 0 {
+		delete(g.edges, permID)
+	}
+	return true
+}
+
+// walk visits actions reachable from action in global and permission implications. When reverse is set
+// edges are followed backwards, so actions implying action are visited.
+func (g *implicationGraph) walk(permID string, action Action, reverse bool, visit func(a Action)) {
+	g.RLock()
+	defer g.RUnlock()
+	if len(g.edges) == 0 {
+		return
+	}
+	seen := map[Action]bool{action: true}
+	stack := []Action{action}
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		for _, scope := range [2]string{"", permID} {
+			for from, implied := range g.edges[scope] {
+				for to := range implied {
+					next := to
+					if reverse {
+						if to != cur {
+							continue
+						}
+						next = from
+					} else if from != cur {
+						continue
+					}
+					if !seen[next] {
+						seen[next] = true
+						visit(next)
+						stack = append(stack, next)
+					}
+				}
+			}
+			if permID == "" {
+				break
+			}
+		}
+	}
+}
+
+// reaches checks if to is implied by from in global and permission implications
+func (g *implicationGraph) reaches(permID string, from, to Action) (res bool) {
+	g.walk(permID, from, false, func(a Action) {
+		if a == to {
+			res = true
+		}
+	})
+	return res
+}
+
+func (g *implicationGraph) scopes() []string {
+	g.RLock()
+	defer g.RUnlock()
+	res := []string{}
+	for permID := range g.edges {
+		if permID != "" {
+			res = append(res, permID)
+		}
+	}
+	return res
+}
+
+func (g *implicationGraph) list() []*Implication {
+	g.RLock()
+	defer g.RUnlock()
+	res := []*Implication{}
+	for permID, actions := range g.edges {
+		for a, implied := range actions {
+			impl := &Implication{Permission: permID, Action: a, Implies: []Action{}}
+			for i := range implied {
+				impl.Implies = append(impl.Implies, i)
+			}
+			sort.Slice(impl.Implies, func(i, j int) bool { return impl.Implies[i] < impl.Implies[j] })
+			res = append(res, impl)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Permission != res[j].Permission {
+			return res[i].Permission < res[j].Permission
+		}
+		return res[i].Action < res[j].Action
+	})
+	return res
+}
+
+func (g *implicationGraph) copyTo(trg *implicationGraph) {
+	for _, impl := range g.list() {
+		for _, implied := range impl.Implies {
+			trg.add(impl.Permission, impl.Action, implied)
+		}
+	}
+}
+
+func (r *RBAC) addImplication(ctx context.Context, permID string, action, implied Action) error {
+	c := &Change{Type: EventPolicyChanged, Permission: permID, Actions: []Action{action, implied}, Detail: "implication added"}
+	return r.recordPolicy(ctx, c, r.implicationState, func() error {
+		return r.storeImplication(ctx, permID, action, implied)
+	})
+}
+
+// validate checks if implication is valid and does not make a cycle in the graph
+func (g *implicationGraph) validate(permID string, action, implied Action) error {
+	if action == None || implied == None || action == AnyAction || implied == AnyAction || action == implied {
+		return fmt.Errorf("invalid implication %s -> %s", action, implied)
+	}
+	scopes := []string{permID}
+	if permID == "" {
+		scopes = append(scopes, g.scopes()...)
+	}
+	for _, scope := range scopes {
+		if g.reaches(scope, implied, action) {
+			return fmt.Errorf("circular implication is found for action %s while adding %s -> %s", implied, action, implied)
+		}
+	}
+	return nil
+}
+
+// storeImplication validates and stores an implication
+func (r *RBAC) storeImplication(ctx context.Context, permID string, action, implied Action) error {
+	if err := r.implications.validate(permID, action, implied); err != nil {
+		log.Errorf("can not add implication, err: %v", err)
+		return err
+	}
+	r.implications.add(permID, action, implied)
+	r.emit(ctx, Event{Type: EventPolicyChanged, Permission: permID, Actions: []Action{action, implied}, Detail: "implication added"})
+	return nil
+}
+
+// AddImplication defines that granting action implies implied action for all permissions, e.g. `update` implies
+// `read`. Implications are transitive and evaluated at check time, so they are valid for grants given before.
+func (r *RBAC) AddImplication(action, implied Action) error {
+	return r.AddImplicationContext(context.Background(), action, implied)
+}
+
+// AddPermissionImplication defines that granting action implies implied action for a permission
+func (r *RBAC) AddPermissionImplication(perm *Permission, action, implied Action) error {
+	return r.AddPermissionImplicationContext(context.Background(), perm, action, implied)
+}
+
+// RemoveImplication removes a global implication
+func (r *RBAC) RemoveImplication(action, implied Action) error {
+	return r.RemoveImplicationContext(context.Background(), action, implied)
+}
+
+// RemovePermissionImplication removes an implication of a permission
+func (r *RBAC) RemovePermissionImplication(perm *Permission, action, implied Action) error {
+	return r.RemovePermissionImplicationContext(context.Background(), perm, action, implied)
+}
+
+func (r *RBAC) removeImplication(ctx context.Context, permID string, action, implied Action) error {
+	c := &Change{Type: EventPolicyChanged, Permission: permID, Actions: []Action{action, implied}, Detail: "implication removed"}
+	return r.recordPolicy(ctx, c, r.implicationState, func() error {
+		if !r.implications.remove(permID, action, implied) {
+			if permID == "" {
+				log.Errorf("implication %s -> %s is not defined", action, implied)
+				return fmt.Errorf("implication %s -> %s is not defined", action, implied)
+			}
+			log.Errorf("implication %s -> %s is not defined for permission %s", action, implied, permID)
+			return fmt.Errorf("implication %s -> %s is not defined for permission %s", action, implied, permID)
+		}
+		r.emit(ctx, Event{Type: EventPolicyChanged, Permission: permID, Actions: []Action{action, implied}, Detail: "implication removed"})
+		return nil
+	})
+}
+
+// Implications returns all defined implications
+func (r *RBAC) Implications() []*Implication {
+	return r.implications.list()
+}
+
+// ImpliedActions returns sorted list of actions implied by action for permission(transitively)
+func (r *RBAC) ImpliedActions(permID string, action Action) []Action {
+	res := []Action{}
+	r.implications.walk(permID, action, false, func(a Action) {
+		res = append(res, a)
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// implyingActions returns actions implying action for permission(transitively)
+func (r *RBAC) implyingActions(permID string, action Action) (res []Action) {
+	r.implications.walk(permID, action, true, func(a Action) {
+		res = append(res, a)
+	})
+	return res
+}
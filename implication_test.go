@@ -0,0 +1,127 @@
+package rbac
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImplication(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	ApproveAction := Action("approve")
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	postPerm, err := R.RegisterPermission("post", "Post resource", CRUD, ApproveAction)
+	if err != nil {
+		t.Fatalf("can not register post permission, err: %v", err)
+	}
+
+	editorRole, _ := R.RegisterRole("editor", "Editor role")
+	if err = R.Permit(editorRole.ID, usersPerm, Update); err != nil {
+		t.Fatalf("can not permit users.update, err: %v", err)
+	}
+	if err = R.Permit(editorRole.ID, postPerm, ApproveAction); err != nil {
+		t.Fatalf("can not permit post.approve, err: %v", err)
+	}
+	if R.IsGranted(editorRole.ID, usersPerm, Read) {
+		t.Fatalf("editor should not have users.read without implication")
+	}
+
+	// Global implications
+	if err = R.AddImplication(Update, Read); err != nil {
+		t.Fatalf("can not add implication, err: %v", err)
+	}
+	if err = R.AddImplication(Delete, Update); err != nil {
+		t.Fatalf("can not add implication, err: %v", err)
+	}
+	if err = R.AddImplication(Read, Delete); err == nil || !strings.Contains(err.Error(), "circular") {
+		t.Fatalf("circular implication check failed with err: %v", err)
+	}
+	if err = R.AddImplication(Read, Read); err == nil {
+		t.Fatalf("should not be able to add self implication")
+	}
+	if err = R.AddImplication(AnyAction, Read); err == nil {
+		t.Fatalf("should not be able to add wildcard implication")
+	}
+	if !R.IsGranted(editorRole.ID, usersPerm, Read, Update) {
+		t.Fatalf("editor should have users.read implied by users.update")
+	}
+	if R.IsGranted(editorRole.ID, usersPerm, Delete) {
+		t.Fatalf("editor should not have users.delete")
+	}
+	if !reflect.DeepEqual(R.ImpliedActions(usersPerm.ID, Delete), []Action{Read, Update}) {
+		t.Fatalf("delete should imply read and update transitively, got %v", R.ImpliedActions(usersPerm.ID, Delete))
+	}
+
+	// Permission implications
+	if err = R.AddPermissionImplication(usersPerm, ApproveAction, Read); err == nil {
+		t.Fatalf("should not be able to add implication with unregistered action")
+	}
+	if err = R.AddPermissionImplication(nil, ApproveAction, Read); err == nil {
+		t.Fatalf("should not be able to add implication for nil permission")
+	}
+	if err = R.AddPermissionImplication(postPerm, ApproveAction, Read); err != nil {
+		t.Fatalf("can not add permission implication, err: %v", err)
+	}
+	if err = R.AddPermissionImplication(postPerm, Read, ApproveAction); err == nil {
+		t.Fatalf("circular permission implication should fail")
+	}
+	if err = R.AddImplication(Read, ApproveAction); err == nil {
+		t.Fatalf("circular global implication with permission implication should fail")
+	}
+	if !R.IsGranted(editorRole.ID, postPerm, Read) {
+		t.Fatalf("editor should have post.read implied by post.approve")
+	}
+
+	// Inherited and denied
+	childRole, _ := R.RegisterRole("child", "Child role")
+	childRole.AddParent(editorRole)
+	if !R.IsGrantInherited(childRole.ID, usersPerm, Read) {
+		t.Fatalf("child should inherit users.read implied by users.update")
+	}
+	R.Deny(childRole.ID, usersPerm, Read)
+	if R.IsGrantInherited(childRole.ID, usersPerm, Read) {
+		t.Fatalf("child should not have denied users.read")
+	}
+	if !R.IsGrantInherited(childRole.ID, usersPerm, Update) {
+		t.Fatalf("child should still have users.update")
+	}
+
+	// Round trip
+	b, err := json.Marshal(R)
+	if err != nil {
+		t.Fatalf("rbac marshall failed with %v", err)
+	}
+	R2 := New(nil)
+	R2.RegisterPermission("users", "User resource", CRUD)
+	R2.RegisterPermission("post", "Post resource", CRUD, ApproveAction)
+	if err = json.Unmarshal(b, R2); err != nil {
+		t.Fatalf("rbac unmarshall failed with %v", err)
+	}
+	if !reflect.DeepEqual(R.Implications(), R2.Implications()) {
+		t.Fatalf("loaded implications differ, expected %v, got %v", R.Implications(), R2.Implications())
+	}
+	if !R2.IsGrantedStr(editorRole.ID, postPerm.ID, Read) {
+		t.Fatalf("loaded editor should have post.read implied by post.approve")
+	}
+
+	// Remove
+	if err = R.RemoveImplication(Update, Read); err != nil {
+		t.Fatalf("can not remove implication, err: %v", err)
+	}
+	if err = R.RemoveImplication(Update, Read); err == nil {
+		t.Fatalf("should not be able to remove undefined implication")
+	}
+	if err = R.RemovePermissionImplication(postPerm, ApproveAction, Read); err != nil {
+		t.Fatalf("can not remove permission implication, err: %v", err)
+	}
+	if R.IsGranted(editorRole.ID, usersPerm, Read) || R.IsGranted(editorRole.ID, postPerm, Read) {
+		t.Fatalf("editor should not have read after implications are removed")
+	}
+	if len(R.Implications()) != 1 {
+		t.Fatalf("should have 1 implication left, got %d", len(R.Implications()))
+	}
+}
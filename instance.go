@@ -0,0 +1,180 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// instancesMap is used during JSON Marshalling, key: permissionID, value: instanceID -> actions
+type instancesMap map[string]map[string][]Action
+
+// PermitInstance grants actions of a permission to a role only for a resource instance, like user with ID `42`
+func (r *RBAC) PermitInstance(roleID string, perm *Permission, instanceID string, actions ...Action) error {
+	return r.permitInstance(context.Background(), "", roleID, perm, instanceID, actions...)
+}
+
+func (r *RBAC) permitInstance(ctx context.Context, domain, roleID string, perm *Permission, instanceID string, actions ...Action) error {
+	if instanceID == "" {
+		log.Errorf("empty instance ID is sent for permitting to role %s", roleID)
+		return fmt.Errorf("instance ID can not be empty")
+	}
+	return r.modifyScopedRole(ctx, domain, roleID, perm, "permitting to", actions, grantScope{instance: instanceID}, func(role *Role, actions []Action) {
+		role.grantInstance(perm, instanceID, actions...)
+	})
+}
+
+func (r *RBAC) revokeInstanceIn(ctx context.Context, domain, roleID string, perm *Permission, instanceID string, actions ...Action) error {
+	return r.modifyScopedRole(ctx, domain, roleID, perm, "revoking from", actions, grantScope{instance: instanceID}, func(role *Role, actions []Action) {
+		role.revokeInstance(perm, instanceID, actions...)
+	})
+}
+
+// RevokeInstance removes instance grants from a role
+func (r *RBAC) RevokeInstance(roleID string, perm *Permission, instanceID string, actions ...Action) error {
+	return r.RevokeInstanceContext(context.Background(), roleID, perm, instanceID, actions...)
+}
+
+// IsInstanceGranted checks if a role(including inherited permissions from parents) has actions granted for a
+// resource instance. It falls back to grants of the permission when there is no instance grant.
+func (r *RBAC) IsInstanceGranted(roleID string, perm *Permission, instanceID string, actions ...Action) bool {
+	if perm == nil {
+		log.Errorf("Nil perm is sent for granted check for role %s", roleID)
+		return false
+	}
+	return r.decide(func() bool { return r.isInstanceGranted(roleID, perm, instanceID, actions...) }, func() *Decision {
+		return &Decision{Check: "IsInstanceGranted", Roles: []string{roleID}, Permission: perm.ID, Actions: actions, Instance: instanceID}
+	})
+}
+
+func (r *RBAC) isInstanceGranted(roleID string, perm *Permission, instanceID string, actions ...Action) bool {
+	role := r.resolveRole("", roleID)
+	if role == nil {
+		return false
+	}
+	actions = r.expandComposites(actions)
+	for _, a := range actions {
+		if !r.IsPermissionExist(perm.ID, a) {
+			log.Errorf("Action %s for permission %s is not defined, while checking grants for role %s", a, perm.ID, roleID)
+			return false
+		}
+	}
+	q := r.newQuery(perm.ID, actions...)
+	q.instance = instanceID
+	return role.isGrantInheritedQ(q)
+}
+
+// AnyInstanceGranted checks if any role has actions granted for a resource instance
+func (r *RBAC) AnyInstanceGranted(roleIDs []string, perm *Permission, instanceID string, actions ...Action) bool {
+	if perm == nil {
+		log.Errorf("Nil perm is sent for granted check for roles %v", roleIDs)
+		return false
+	}
+	return r.decide(func() bool { return r.anyInstanceGranted(roleIDs, perm, instanceID, actions...) }, func() *Decision {
+		return &Decision{Check: "AnyInstanceGranted", Roles: roleIDs, Permission: perm.ID, Actions: actions, Instance: instanceID}
+	})
+}
+
+func (r *RBAC) anyInstanceGranted(roleIDs []string, perm *Permission, instanceID string, actions ...Action) bool {
+	if !r.canActivate("", roleIDs) {
+		return false
+	}
+	for _, roleID := range roleIDs {
+		if r.isInstanceGranted(roleID, perm, instanceID, actions...) {
+			return true
+		}
+	}
+	return false
+}
+
+// InstanceIDs returns sorted list of instance IDs which action of permission is granted for a role(including
+// inherited permissions from parents). Grants of the permission itself are not listed, they are valid for all
+// instances.
+func (r *RBAC) InstanceIDs(roleID string, perm *Permission, action Action) []string {
+	res := []string{}
+	if perm == nil {
+		log.Errorf("Nil perm is sent for listing instances for role %s", roleID)
+		return res
+	}
+	role := r.resolveRole("", roleID)
+	if role == nil {
+		return res
+	}
+	q := r.newQuery(perm.ID, action)
+	seen := map[string]bool{}
+	for _, rl := range append([]*Role{role}, role.ancestors()...) {
+		insts, ok := rl.instances.Load(perm.ID)
+		if !ok {
+			continue
+		}
+		insts.(*sync.Map).Range(func(id, _ interface{}) bool {
+			if seen[id.(string)] {
+				return true
+			}
+			q.instance = id.(string)
+			if role.isGrantInheritedQ(q) {
+				seen[id.(string)] = true
+				res = append(res, id.(string))
+			}
+			return true
+		})
+	}
+	sort.Strings(res)
+	return res
+}
+
+func (r *Role) grantInstance(p *Permission, instanceID string, actions ...Action) {
+	insts, _ := r.instances.LoadOrStore(p.ID, &sync.Map{})
+	acts, _ := insts.(*sync.Map).LoadOrStore(instanceID, &sync.Map{})
+	for _, a := range actions {
+		acts.(*sync.Map).Store(a, nil)
+	}
+}
+
+func (r *Role) revokeInstance(p *Permission, instanceID string, actions ...Action) {
+	insts, ok := r.instances.Load(p.ID)
+	if !ok {
+		return
+	}
+	if acts, ok := insts.(*sync.Map).Load(instanceID); ok {
+		for _, a := range actions {
+			acts.(*sync.Map).Delete(a)
+		}
+		if isEmpty(acts.(*sync.Map)) {
+			insts.(*sync.Map).Delete(instanceID)
+		}
+	}
+	if isEmpty(insts.(*sync.Map)) {
+		r.instances.Delete(p.ID)
+	}
+}
+
+// hasInstanceGrant checks if any of acts is granted to the role itself for instance of permission
+func (r *Role) hasInstanceGrant(pID, instanceID string, acts []Action) bool {
+	insts, ok := r.instances.Load(pID)
+	if !ok {
+		return false
+	}
+	am, ok := insts.(*sync.Map).Load(instanceID)
+	return ok && matchActs(am.(*sync.Map), acts, exists)
+}
+
+func (r *Role) getInstanceGrants() instancesMap {
+	res := instancesMap{}
+	r.instances.Range(func(permID, insts interface{}) bool {
+		res[permID.(string)] = map[string][]Action{}
+		insts.(*sync.Map).Range(func(id, acts interface{}) bool {
+			actions := []Action{}
+			acts.(*sync.Map).Range(func(a, _ interface{}) bool {
+				actions = append(actions, a.(Action))
+				return true
+			})
+			sort.Slice(actions, func(i, j int) bool { return actions[i] < actions[j] })
+			res[permID.(string)][id.(string)] = actions
+			return true
+		})
+		return true
+	})
+	return res
+}
@@ -0,0 +1,109 @@
+package rbac
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestInstance(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	postPerm, _ := R.RegisterPermission("post", "Post resource", CRUD)
+
+	userRole, _ := R.RegisterRole("user", "User role")
+	managerRole, _ := R.RegisterRole("manager", "Manager role")
+	if err = managerRole.AddParent(userRole); err != nil {
+		t.Fatalf("adding parent role failed with: %v", err)
+	}
+	if err = R.PermitInstance(userRole.ID, usersPerm, "42", Update, Read); err != nil {
+		t.Fatalf("can not permit users.update on 42, err: %v", err)
+	}
+	if err = R.PermitInstance(userRole.ID, usersPerm, "", Update); err == nil {
+		t.Fatalf("PermitInstance should fail with empty instance ID")
+	}
+	if err = R.PermitInstance(userRole.ID, nil, "42", Update); err == nil {
+		t.Fatalf("PermitInstance should fail with nil permission")
+	}
+	if err = R.PermitInstance(userRole.ID, usersPerm, "42", "approve"); err == nil {
+		t.Fatalf("PermitInstance should fail with invalid action")
+	}
+	if err = R.PermitInstance("fake_role", usersPerm, "42", Update); err == nil {
+		t.Fatalf("PermitInstance should fail with nonexisting role")
+	}
+	if err = R.PermitInstance(managerRole.ID, usersPerm, "7", CRUD); err != nil {
+		t.Fatalf("can not permit users.crud on 7, err: %v", err)
+	}
+	if err = R.Permit(managerRole.ID, postPerm, Update); err != nil {
+		t.Fatalf("can not permit post.update, err: %v", err)
+	}
+
+	if !R.IsInstanceGranted(userRole.ID, usersPerm, "42", Update) {
+		t.Fatalf("user role should have users.update on 42")
+	}
+	if R.IsInstanceGranted(userRole.ID, usersPerm, "43", Update) {
+		t.Fatalf("user role should not have users.update on 43")
+	}
+	if R.IsGranted(userRole.ID, usersPerm, Update) {
+		t.Fatalf("instance grant should not be valid for the whole permission")
+	}
+	if !R.IsInstanceGranted(managerRole.ID, usersPerm, "42", Read) {
+		t.Fatalf("manager role should inherit users.read on 42")
+	}
+	if !R.IsInstanceGranted(managerRole.ID, postPerm, "99", Update) {
+		t.Fatalf("manager role should fall back to post.update grant")
+	}
+	if R.IsInstanceGranted(managerRole.ID, nil, "99", Update) || R.IsInstanceGranted("fake_role", postPerm, "99", Update) {
+		t.Fatalf("IsInstanceGranted should fail with nil permission or nonexisting role")
+	}
+	if !R.AnyInstanceGranted([]string{userRole.ID, managerRole.ID}, usersPerm, "7", Delete) {
+		t.Fatalf("manager role should have users.delete on 7")
+	}
+
+	// Listing
+	if ids := R.InstanceIDs(managerRole.ID, usersPerm, Update); !reflect.DeepEqual(ids, []string{"42", "7"}) {
+		t.Fatalf("manager role should update users 42 and 7, got %v", ids)
+	}
+	if ids := R.InstanceIDs(userRole.ID, usersPerm, Delete); len(ids) != 0 {
+		t.Fatalf("user role should not delete any users, got %v", ids)
+	}
+
+	// Deny of permission wins over instance grant
+	R.Deny(managerRole.ID, usersPerm, Delete)
+	if R.IsInstanceGranted(managerRole.ID, usersPerm, "7", Delete) {
+		t.Fatalf("manager role should not have denied users.delete on 7")
+	}
+	if ids := R.InstanceIDs(managerRole.ID, usersPerm, Delete); len(ids) != 0 {
+		t.Fatalf("manager role should not delete any users, got %v", ids)
+	}
+
+	// Round trip
+	b, err := json.Marshal(R)
+	if err != nil {
+		t.Fatalf("rbac marshall failed with %v", err)
+	}
+	R2 := R.Clone(false)
+	if err = json.Unmarshal(b, R2); err != nil {
+		t.Fatalf("rbac unmarshall failed with %v", err)
+	}
+	if !reflect.DeepEqual(R2.GetRole(userRole.ID).getInstanceGrants(), userRole.getInstanceGrants()) {
+		t.Fatalf("loaded instance grants differ")
+	}
+	if !R2.IsInstanceGranted(managerRole.ID, usersPerm, "42", Update) {
+		t.Fatalf("loaded manager role should have users.update on 42")
+	}
+
+	// Revoke
+	if err = R.RevokeInstance(userRole.ID, usersPerm, "42", Update, Read); err != nil {
+		t.Fatalf("can not revoke instance grant, err: %v", err)
+	}
+	if R.IsInstanceGranted(userRole.ID, usersPerm, "42", Update) {
+		t.Fatalf("user role should not have users.update on 42 after revoke")
+	}
+	if len(userRole.getInstanceGrants()) != 0 {
+		t.Fatalf("user role should not have instance grants left")
+	}
+}
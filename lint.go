@@ -0,0 +1,224 @@
+package rbac
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Severity is severity of a lint finding
+type Severity string
+
+const (
+	// SeverityError is for definitions which are broken, like grants of unregistered actions
+	SeverityError Severity = "error"
+	// SeverityWarning is for definitions which are probably wrong
+	SeverityWarning Severity = "warning"
+	// SeverityInfo is for definitions which can be cleaned up
+	SeverityInfo Severity = "info"
+)
+
+// Lint finding codes, they are stable and never reused
+const (
+	// LintUnusedPermission is for permissions which no role is granted
+	LintUnusedPermission = "RBAC001"
+	// LintEmptyRole is for roles with no grants and no parents
+	LintEmptyRole = "RBAC002"
+	// LintRedundantGrant is for grants which are already inherited from a parent
+	LintRedundantGrant = "RBAC003"
+	// LintUnregisteredAction is for grants and denies of actions which are not registered for their permission
+	LintUnregisteredAction = "RBAC004"
+	// LintEmptyDescription is for roles and permissions with empty description
+	LintEmptyDescription = "RBAC005"
+	// LintDeepInheritance is for roles with an inheritance chain longer than LintOptions.MaxDepth
+	LintDeepInheritance = "RBAC006"
+)
+
+// Finding is a lint finding
+type Finding struct {
+	Code       string   `json:"code"`
+	Severity   Severity `json:"severity"`
+	Domain     string   `json:"domain,omitempty"`
+	RoleID     string   `json:"role_id,omitempty"`
+	Permission string   `json:"permission,omitempty"`
+	Actions    []Action `json:"actions,omitempty"`
+	Message    string   `json:"message"`
+}
+
+// String returns finding as a line, like "RBAC003 info: grant of users [read] to role admin is inherited from user"
+func (f *Finding) String() string {
+	return fmt.Sprintf("%s %s: %s", f.Code, f.Severity, f.Message)
+}
+
+// LintOptions are options of LintWithOptions
+type LintOptions struct {
+	// MaxDepth is the maximum length of inheritance chains from a role to its farthest ancestor, zero is 5
+	MaxDepth int
+}
+
+// Lint reports unused, redundant and suspicious definitions with default options, see LintWithOptions
+func (r *RBAC) Lint() []*Finding {
+	return r.LintWithOptions(LintOptions{})
+}
+
+// LintWithOptions reports permissions which no role is granted, roles with no grants and no parents, grants which
+// are already inherited from a parent, grants and denies of unregistered actions, empty descriptions and deep
+// inheritance chains. Findings are sorted by code, domain, role and permission.
+func (r *RBAC) LintWithOptions(opts LintOptions) []*Finding {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = 5
+	}
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
+	res := []*Finding{}
+	add := func(f *Finding) {
+		res = append(res, f)
+	}
+
+	used := map[string]bool{}
+	for _, role := range append(r.Roles(), r.domainRoles()...) {
+		rg := r.roleGrants([]*Role{role})[0]
+		granted := rg.permissionIDs()
+		for _, permID := range granted {
+			used[permID] = true
+		}
+		if len(granted) == 0 && len(rg.Parents) == 0 {
+			add(&Finding{Code: LintEmptyRole, Severity: SeverityWarning, Domain: role.Domain, RoleID: role.ID,
+				Message: fmt.Sprintf("role %s has no grants and no parents", role.ID)})
+		}
+		if role.Description == "" {
+			add(&Finding{Code: LintEmptyDescription, Severity: SeverityInfo, Domain: role.Domain, RoleID: role.ID,
+				Message: fmt.Sprintf("role %s has empty description", role.ID)})
+		}
+		r.lintActions(role, rg, add)
+		r.lintRedundant(role, rg.Grants, add)
+		if depth := role.depth(); depth > opts.MaxDepth {
+			add(&Finding{Code: LintDeepInheritance, Severity: SeverityWarning, Domain: role.Domain, RoleID: role.ID,
+				Message: fmt.Sprintf("role %s has an inheritance chain of %d roles, more than %d", role.ID, depth, opts.MaxDepth)})
+		}
+	}
+
+	for _, perm := range r.sortedPermissions() {
+		if perm.Description == "" {
+			add(&Finding{Code: LintEmptyDescription, Severity: SeverityInfo, Permission: perm.ID,
+				Message: fmt.Sprintf("permission %s has empty description", perm.ID)})
+		}
+		isUsed := used[AnyPermission]
+		for _, pID := range r.permPath(perm.ID) {
+			isUsed = isUsed || used[pID]
+		}
+		if !isUsed {
+			add(&Finding{Code: LintUnusedPermission, Severity: SeverityWarning, Permission: perm.ID,
+				Message: fmt.Sprintf("permission %s is not granted to any role", perm.ID)})
+		}
+	}
+
+	sort.SliceStable(res, func(i, j int) bool {
+		a, b := res[i], res[j]
+		if a.Code != b.Code {
+			return a.Code < b.Code
+		}
+		if a.Domain != b.Domain {
+			return a.Domain < b.Domain
+		}
+		if a.RoleID != b.RoleID {
+			return a.RoleID < b.RoleID
+		}
+		return a.Permission < b.Permission
+	})
+	return res
+}
+
+// permissionIDs returns IDs of permissions which any action is granted for, including conditional, instance and
+// scheduled grants
+func (rg *RoleGrants) permissionIDs() []string {
+	res := []string{}
+	for permID, actions := range rg.Grants {
+		if len(actions) > 0 {
+			res = append(res, permID)
+		}
+	}
+	for permID := range rg.Instances {
+		res = append(res, permID)
+	}
+	for _, cg := range rg.Conditional {
+		res = append(res, cg.Permission)
+	}
+	for _, sg := range rg.Scheduled {
+		res = append(res, sg.Permission)
+	}
+	return res
+}
+
+// lintActions reports grants and denies of actions which are not registered for their permission
+func (r *RBAC) lintActions(role *Role, rg *RoleGrants, add func(f *Finding)) {
+	check := func(kind, permID string, actions []Action) {
+		invalid := []Action{}
+		for _, a := range actions {
+			if !r.IsPermissionExist(permID, a) {
+				invalid = append(invalid, a)
+			}
+		}
+		if len(invalid) > 0 {
+			sort.Slice(invalid, func(i, j int) bool { return invalid[i] < invalid[j] })
+			add(&Finding{Code: LintUnregisteredAction, Severity: SeverityError, Domain: role.Domain, RoleID: role.ID,
+				Permission: permID, Actions: invalid,
+				Message: fmt.Sprintf("%s of role %s has actions %v which are not registered for permission %s", kind, role.ID, invalid, permID)})
+		}
+	}
+	for permID, actions := range rg.Grants {
+		check("grant", permID, actions)
+	}
+	for permID, actions := range rg.Denies {
+		check("deny", permID, actions)
+	}
+	for _, cg := range rg.Conditional {
+		check("conditional grant", cg.Permission, cg.Actions)
+	}
+	for permID, instances := range rg.Instances {
+		for _, actions := range instances {
+			check("instance grant", permID, actions)
+		}
+	}
+	for _, sg := range rg.Scheduled {
+		check("scheduled grant", sg.Permission, sg.Actions)
+	}
+}
+
+// lintRedundant reports grants of role which are already inherited from any of its parents
+func (r *RBAC) lintRedundant(role *Role, grants grantsMap, add func(f *Finding)) {
+	parents := role.Parents()
+	for permID, actions := range grants {
+		redundant := []Action{}
+		parentIDs := []string{}
+		for _, a := range actions {
+			q := r.newQuery(permID, a)
+			for _, p := range parents {
+				if len(q.actions) > 0 && p.isGrantInheritedQ(q) {
+					redundant = append(redundant, a)
+					if !hasString(parentIDs, p.ID) {
+						parentIDs = append(parentIDs, p.ID)
+					}
+					break
+				}
+			}
+		}
+		if len(redundant) > 0 {
+			sort.Slice(redundant, func(i, j int) bool { return redundant[i] < redundant[j] })
+			sort.Strings(parentIDs)
+			add(&Finding{Code: LintRedundantGrant, Severity: SeverityInfo, Domain: role.Domain, RoleID: role.ID,
+				Permission: permID, Actions: redundant,
+				Message: fmt.Sprintf("grant of %s %v to role %s is inherited from %v", permID, redundant, role.ID, parentIDs)})
+		}
+	}
+}
+
+// depth returns number of roles in the longest inheritance chain from the role to its farthest ancestor
+func (r *Role) depth() int {
+	res := 0
+	for _, p := range r.Parents() {
+		if d := p.depth(); d > res {
+			res = d
+		}
+	}
+	return res + 1
+}
@@ -0,0 +1,95 @@
+package rbac
+
+import (
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterPermission("logs", "", Read)
+	userRole, _ := R.RegisterRole("user", "User role")
+	adminRole, _ := R.RegisterRole("admin", "Admin role")
+	R.RegisterRole("guest", "")
+	R.Permit(userRole.ID, usersPerm, Read)
+	R.Permit(adminRole.ID, usersPerm, Read, Delete)
+	adminRole.AddParent(userRole)
+
+	expected := []string{
+		"RBAC001 warning: permission logs is not granted to any role",
+		"RBAC002 warning: role guest has no grants and no parents",
+		"RBAC003 info: grant of users [read] to role admin is inherited from [user]",
+		"RBAC005 info: permission logs has empty description",
+		"RBAC005 info: role guest has empty description",
+	}
+	findings := R.Lint()
+	if len(findings) != len(expected) {
+		t.Fatalf("expected %d findings, got %v", len(expected), findings)
+	}
+	for i, f := range findings {
+		if f.String() != expected[i] {
+			t.Fatalf("expected finding %d to be %q, got %q", i, expected[i], f.String())
+		}
+	}
+	if findings[2].Permission != "users" || findings[2].RoleID != "admin" || len(findings[2].Actions) != 1 {
+		t.Fatalf("redundant grant finding fields are not set: %+v", findings[2])
+	}
+
+	// actions removed from a permission while still granted
+	usersPerm.Delete(Delete)
+	findings = R.Lint()
+	found := false
+	for _, f := range findings {
+		if f.Code == LintUnregisteredAction {
+			found = true
+			if f.Severity != SeverityError || f.RoleID != "admin" || len(f.Actions) != 1 || f.Actions[0] != Delete {
+				t.Fatalf("unexpected unregistered action finding: %+v", f)
+			}
+		}
+	}
+	if !found {
+		t.Fatalf("grant of unregistered action should be reported")
+	}
+
+	// wildcard grant uses all permissions
+	R.RegisterRole("root", "Root role")
+	R.Permit("root", R.GetPermission(AnyPermission), Read)
+	for _, f := range R.Lint() {
+		if f.Code == LintUnusedPermission {
+			t.Fatalf("wildcard grant should use all permissions, got %s", f)
+		}
+	}
+}
+
+func TestLintDepth(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	perm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	ids := []string{"r1", "r2", "r3", "r4"}
+	for i, id := range ids {
+		role, _ := R.RegisterRole(id, "Role "+id)
+		R.Permit(id, perm, Read)
+		if i > 0 {
+			role.AddParent(R.GetRole(ids[i-1]))
+		}
+	}
+	if findings := R.LintWithOptions(LintOptions{MaxDepth: 4}); countCode(findings, LintDeepInheritance) != 0 {
+		t.Fatalf("chain of 4 roles should not be reported with max depth 4, got %v", findings)
+	}
+	findings := R.LintWithOptions(LintOptions{MaxDepth: 3})
+	if countCode(findings, LintDeepInheritance) != 1 {
+		t.Fatalf("chain of 4 roles should be reported with max depth 3, got %v", findings)
+	}
+	if countCode(findings, LintRedundantGrant) != 3 {
+		t.Fatalf("grants of r2, r3 and r4 should be redundant, got %v", findings)
+	}
+}
+
+func countCode(findings []*Finding, code string) int {
+	n := 0
+	for _, f := range findings {
+		if f.Code == code {
+			n++
+		}
+	}
+	return n
+}
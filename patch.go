@@ -0,0 +1,316 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ReadPatch reads a patch in JSON format of PolicyDiff, like the output of PolicyDiff.JSON
+func ReadPatch(reader io.Reader) (*PolicyDiff, error) {
+	patch := &PolicyDiff{}
+	if err := json.NewDecoder(reader).Decode(patch); err != nil {
+		log.Errorf("can not parse patch, err: %v", err)
+		return nil, err
+	}
+	return patch, nil
+}
+
+// ApplyPatch applies changes of a patch in order within a transaction, so either all changes are applied or none.
+// Each change is validated against current state and conflicts fail the patch: adding an existing item, removing a
+// missing one, updating a description which is not From, and removing a permission or action still in use. Like
+// Tx, the instance is copied to stage changes.
+func (r *RBAC) ApplyPatch(patch *PolicyDiff) error {
+	return r.ApplyPatchContext(context.Background(), patch)
+}
+
+// ApplyPatchContext is same as ApplyPatch, changes are recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) ApplyPatchContext(ctx context.Context, patch *PolicyDiff) error {
+	return r.TxContext(ctx, func(tx *Tx) error {
+		for i, c := range patch.Changes {
+			c := c
+			if err := tx.apply(func(r *RBAC, ctx context.Context) error { return r.applyChange(ctx, c) }); err != nil {
+				log.Errorf("can not apply change %d \"%s\" of patch, err: %v", i, c, err)
+				return fmt.Errorf("can not apply change %d \"%s\" of patch, err: %v", i, c, err)
+			}
+		}
+		return nil
+	})
+}
+
+func (r *RBAC) applyChange(ctx context.Context, c *PolicyChange) error {
+	switch c.Kind {
+	case DiffPermission:
+		return r.applyPermission(ctx, c)
+	case DiffAction:
+		return r.applyActions(ctx, c)
+	case DiffRole:
+		if c.Op == DiffAdd {
+			_, err := r.registerRoleIn(ctx, c.Domain, c.RoleID, c.To)
+			return err
+		}
+		if c.Op == DiffRemove {
+			return r.removeRoleIn(ctx, c.Domain, c.RoleID)
+		}
+	case DiffGrant, DiffDeny, DiffConditional, DiffInstance, DiffScheduled:
+		return r.applyGrants(ctx, c)
+	case DiffCardinality:
+		return r.applyCardinality(ctx, c)
+	case DiffParent:
+		return r.applyParent(ctx, c)
+	case DiffDescription:
+		return r.applyDescription(ctx, c)
+	}
+	return fmt.Errorf("invalid change %s of %s", c.Op, c.Kind)
+}
+
+func (r *RBAC) applyPermission(ctx context.Context, c *PolicyChange) error {
+	switch c.Op {
+	case DiffAdd:
+		_, err := r.RegisterPermissionContext(ctx, c.Permission, c.To, c.Actions...)
+		return err
+	case DiffRemove:
+		return r.record(ctx, &Change{Type: EventPolicyChanged, Permission: c.Permission, Detail: "permission is removed"}, func() error {
+			if r.GetPermission(c.Permission) == nil {
+				return fmt.Errorf("permission %s is not registered", c.Permission)
+			}
+			if roleID := r.permissionUser(c.Permission, nil); roleID != "" {
+				return fmt.Errorf("permission %s is used by role %s", c.Permission, roleID)
+			}
+			r.permissions.Delete(c.Permission)
+			r.emit(ctx, Event{Type: EventPolicyChanged, Permission: c.Permission, Detail: "permission is removed"})
+			return nil
+		})
+	}
+	return fmt.Errorf("invalid change %s of %s", c.Op, c.Kind)
+}
+
+func (r *RBAC) applyActions(ctx context.Context, c *PolicyChange) error {
+	detail := "actions are added"
+	if c.Op == DiffRemove {
+		detail = "actions are removed"
+	}
+	return r.record(ctx, &Change{Type: EventPolicyChanged, Permission: c.Permission, Actions: c.Actions, Detail: detail}, func() error {
+		perm := r.GetPermission(c.Permission)
+		if perm == nil || perm.ID == AnyPermission {
+			return fmt.Errorf("permission %s is not registered", c.Permission)
+		}
+		for _, a := range c.Actions {
+			_, ok := perm.Load(a)
+			if c.Op == DiffAdd && ok {
+				return fmt.Errorf("action %s is already registered for permission %s", a, c.Permission)
+			}
+			if c.Op == DiffRemove && !ok {
+				return fmt.Errorf("action %s is not registered for permission %s", a, c.Permission)
+			}
+		}
+		switch c.Op {
+		case DiffAdd:
+			for _, a := range c.Actions {
+				perm.Store(a, nil)
+			}
+		case DiffRemove:
+			if roleID := r.permissionUser(c.Permission, c.Actions); roleID != "" {
+				return fmt.Errorf("actions %v of permission %s are used by role %s", c.Actions, c.Permission, roleID)
+			}
+			for _, a := range c.Actions {
+				perm.Delete(a)
+			}
+		default:
+			return fmt.Errorf("invalid change %s of %s", c.Op, c.Kind)
+		}
+		r.emit(ctx, Event{Type: EventPolicyChanged, Permission: c.Permission, Actions: c.Actions, Detail: detail})
+		return nil
+	})
+}
+
+// permissionUser returns ID of a role using any of actions of permission, any action if actions is empty
+func (r *RBAC) permissionUser(permID string, actions []Action) string {
+	uses := func(acts []Action) bool {
+		if len(actions) == 0 {
+			return len(acts) > 0
+		}
+		for _, a := range acts {
+			if hasAction(actions, a) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, rg := range r.roleGrants(append(r.Roles(), r.domainRoles()...)) {
+		if uses(rg.Grants[permID]) || uses(rg.Denies[permID]) {
+			return rg.ID
+		}
+		for _, acts := range rg.Instances[permID] {
+			if uses(acts) {
+				return rg.ID
+			}
+		}
+		for _, cg := range rg.Conditional {
+			if cg.Permission == permID && uses(cg.Actions) {
+				return rg.ID
+			}
+		}
+		for _, sg := range rg.Scheduled {
+			if sg.Permission == permID && uses(sg.Actions) {
+				return rg.ID
+			}
+		}
+	}
+	return ""
+}
+
+// grantKinds are names of grant kinds used in conflict errors
+var grantKinds = map[DiffKind]string{
+	DiffGrant:       "grants",
+	DiffDeny:        "denies",
+	DiffConditional: "conditional grants",
+	DiffInstance:    "instance grants",
+	DiffScheduled:   "scheduled grants",
+}
+
+func (r *RBAC) applyGrants(ctx context.Context, c *PolicyChange) error {
+	role := r.roleIn(c.Domain, c.RoleID)
+	if role == nil {
+		return fmt.Errorf("role %s is not registered", c.RoleID)
+	}
+	perm := r.GetPermission(c.Permission)
+	if perm == nil {
+		return fmt.Errorf("permission %s is not registered", c.Permission)
+	}
+	current := role.changedGrants(c)
+	for _, a := range c.Actions {
+		if c.Op == DiffAdd && hasAction(current, a) {
+			return fmt.Errorf("action %s of permission %s is already in %s of role %s", a, c.Permission, grantKinds[c.Kind], c.RoleID)
+		}
+		if c.Op == DiffRemove && !hasAction(current, a) {
+			return fmt.Errorf("action %s of permission %s is not in %s of role %s", a, c.Permission, grantKinds[c.Kind], c.RoleID)
+		}
+	}
+	switch {
+	case c.Kind == DiffGrant && c.Op == DiffAdd:
+		return r.permitIn(ctx, c.Domain, c.RoleID, perm, c.Actions...)
+	case c.Kind == DiffGrant && c.Op == DiffRemove:
+		return r.revokeIn(ctx, c.Domain, c.RoleID, perm, c.Actions...)
+	case c.Kind == DiffDeny && c.Op == DiffAdd:
+		return r.denyIn(ctx, c.Domain, c.RoleID, perm, c.Actions...)
+	case c.Kind == DiffDeny && c.Op == DiffRemove:
+		return r.undenyIn(ctx, c.Domain, c.RoleID, perm, c.Actions...)
+	case c.Kind == DiffConditional && c.Op == DiffAdd:
+		return r.permitIf(ctx, c.Domain, c.RoleID, perm, c.Condition, c.Actions...)
+	case c.Kind == DiffConditional && c.Op == DiffRemove:
+		return r.revokeIfIn(ctx, c.Domain, c.RoleID, perm, c.Condition, c.Actions...)
+	case c.Kind == DiffInstance && c.Op == DiffAdd:
+		return r.permitInstance(ctx, c.Domain, c.RoleID, perm, c.Instance, c.Actions...)
+	case c.Kind == DiffInstance && c.Op == DiffRemove:
+		return r.revokeInstanceIn(ctx, c.Domain, c.RoleID, perm, c.Instance, c.Actions...)
+	case c.Kind == DiffScheduled && c.Op == DiffAdd:
+		return r.permitScheduled(ctx, c.Domain, c.RoleID, perm, c.Schedule, c.Actions...)
+	case c.Kind == DiffScheduled && c.Op == DiffRemove:
+		return r.revokeScheduledIn(ctx, c.Domain, c.RoleID, perm, c.Actions...)
+	}
+	return fmt.Errorf("invalid change %s of %s", c.Op, c.Kind)
+}
+
+// changedGrants returns actions of the role in grants of kind and permission of the change
+func (r *Role) changedGrants(c *PolicyChange) []Action {
+	switch c.Kind {
+	case DiffDeny:
+		return r.getDenies()[c.Permission]
+	case DiffConditional:
+		for _, cg := range r.getConditionalGrants() {
+			if cg.Permission == c.Permission && cg.Condition == c.Condition {
+				return cg.Actions
+			}
+		}
+		return nil
+	case DiffInstance:
+		return r.getInstanceGrants()[c.Permission][c.Instance]
+	case DiffScheduled:
+		var res []Action
+		if acts, ok := r.scheduled.Load(c.Permission); ok {
+			acts.(*sync.Map).Range(func(a, _ interface{}) bool {
+				res = append(res, a.(Action))
+				return true
+			})
+		}
+		return res
+	}
+	return r.getGrants()[c.Permission]
+}
+
+func (r *RBAC) applyCardinality(ctx context.Context, c *PolicyChange) error {
+	if c.Op != DiffUpdate {
+		return fmt.Errorf("invalid change %s of %s", c.Op, c.Kind)
+	}
+	cardinality := c.Cardinality
+	if cardinality == nil {
+		cardinality = &Cardinality{}
+	}
+	if c.Domain == "" {
+		return r.SetRoleCardinalityContext(ctx, c.RoleID, cardinality.Min, cardinality.Max)
+	}
+	return r.record(ctx, &Change{Type: EventPolicyChanged, Domain: c.Domain, RoleID: c.RoleID}, func() error {
+		role := r.roleIn(c.Domain, c.RoleID)
+		if role == nil {
+			return fmt.Errorf("role %s is not registered", c.RoleID)
+		}
+		if err := role.setCardinality(cardinality); err != nil {
+			return err
+		}
+		r.emit(ctx, Event{Type: EventPolicyChanged, Domain: c.Domain, RoleID: c.RoleID, Detail: "cardinality set"})
+		return nil
+	})
+}
+
+func (r *RBAC) applyParent(ctx context.Context, c *PolicyChange) error {
+	role := r.roleIn(c.Domain, c.RoleID)
+	if role == nil {
+		return fmt.Errorf("role %s is not registered", c.RoleID)
+	}
+	parent := r.resolveRole(c.Domain, c.ParentID)
+	if parent == nil {
+		return fmt.Errorf("role %s is not registered", c.ParentID)
+	}
+	switch c.Op {
+	case DiffAdd:
+		return role.addParentContext(ctx, parent)
+	case DiffRemove:
+		return role.removeParentContext(ctx, parent)
+	}
+	return fmt.Errorf("invalid change %s of %s", c.Op, c.Kind)
+}
+
+func (r *RBAC) applyDescription(ctx context.Context, c *PolicyChange) error {
+	if c.Op != DiffUpdate {
+		return fmt.Errorf("invalid change %s of %s", c.Op, c.Kind)
+	}
+	if c.RoleID == "" {
+		return r.record(ctx, &Change{Type: EventPolicyChanged, Permission: c.Permission, Detail: "permission description is updated"}, func() error {
+			perm := r.GetPermission(c.Permission)
+			if perm == nil || perm.ID == AnyPermission {
+				return fmt.Errorf("permission %s is not registered", c.Permission)
+			}
+			if perm.Description != c.From {
+				return fmt.Errorf("description of permission %s is %q, not %q", c.Permission, perm.Description, c.From)
+			}
+			perm.Description = c.To
+			r.emit(ctx, Event{Type: EventPolicyChanged, Permission: c.Permission, Detail: "permission description is updated"})
+			return nil
+		})
+	}
+	return r.record(ctx, &Change{Type: EventPolicyChanged, Domain: c.Domain, RoleID: c.RoleID}, func() error {
+		role := r.roleIn(c.Domain, c.RoleID)
+		if role == nil {
+			return fmt.Errorf("role %s is not registered", c.RoleID)
+		}
+		if role.Description != c.From {
+			return fmt.Errorf("description of role %s is %q, not %q", c.RoleID, role.Description, c.From)
+		}
+		role.Description = c.To
+		r.emit(ctx, Event{Type: EventPolicyChanged, Domain: c.Domain, RoleID: c.RoleID, Detail: "role description is updated"})
+		return nil
+	})
+}
@@ -0,0 +1,147 @@
+package rbac
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestApplyPatch(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterPermission("logs", "Log resource", Read)
+	userRole, _ := R.RegisterRole("user", "User role")
+	adminRole, _ := R.RegisterRole("admin", "Admin role")
+	R.RegisterRole("guest", "Guest role")
+	R.Permit(userRole.ID, usersPerm, Read)
+	R.Permit(adminRole.ID, usersPerm, Create, Update)
+	R.Deny(adminRole.ID, usersPerm, Delete)
+
+	B := New(nil)
+	usersPerm2, _ := B.RegisterPermission("users", "Users", CRUD, "export")
+	reportsPerm, _ := B.RegisterPermission("reports", "Report resource", Read)
+	userRole2, _ := B.RegisterRole("user", "User role")
+	adminRole2, _ := B.RegisterRole("admin", "Administrator")
+	auditorRole, _ := B.RegisterRole("auditor", "Auditor role")
+	B.Permit(userRole2.ID, usersPerm2, Read)
+	B.Permit(adminRole2.ID, usersPerm2, Create, Delete, "export")
+	B.Permit(auditorRole.ID, reportsPerm, Read)
+	adminRole2.AddParent(userRole2)
+	auditorRole.AddParent(userRole2)
+	acmeUser, _ := B.RegisterRoleIn("acme", "user", "Acme user")
+	acmeUser.AddParent(userRole2)
+
+	b, err := Diff(R, B).JSON()
+	if err != nil {
+		t.Fatalf("can not render diff as JSON, err: %v", err)
+	}
+	patch, err := ReadPatch(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("can not read patch, err: %v", err)
+	}
+	if err = R.ApplyPatch(patch); err != nil {
+		t.Fatalf("can not apply patch, err: %v", err)
+	}
+	if d := Diff(R, B); !d.Empty() {
+		t.Fatalf("patched instance should be same as target, diff:\n%s", d)
+	}
+	if !R.IsGrantInheritedStr("admin", "users", Read, "export") || R.IsRoleExist("guest") {
+		t.Fatalf("patch is not applied")
+	}
+
+	revision := R.Revision()
+	err = R.ApplyPatch(patch)
+	if err == nil || !strings.Contains(err.Error(), "change 0") {
+		t.Fatalf("applying same patch again should fail on first change, err: %v", err)
+	}
+	if R.Revision() != revision {
+		t.Fatalf("failed patch should not change instance")
+	}
+
+	conflicts := []*PolicyChange{
+		{Op: DiffUpdate, Kind: DiffDescription, RoleID: "admin", From: "Admin role", To: "Admins"},
+		{Op: DiffRemove, Kind: DiffGrant, RoleID: "user", Permission: "users", Actions: []Action{Update}},
+		{Op: DiffRemove, Kind: DiffAction, Permission: "users", Actions: []Action{"export"}},
+		{Op: DiffRemove, Kind: DiffPermission, Permission: "reports"},
+	}
+	for _, c := range conflicts {
+		patch := &PolicyDiff{Changes: []*PolicyChange{
+			{Op: DiffAdd, Kind: DiffRole, RoleID: "support", To: "Support role"},
+			c,
+		}}
+		if err = R.ApplyPatch(patch); err == nil {
+			t.Fatalf("conflicting change %s should fail", c)
+		}
+		if R.IsRoleExist("support") {
+			t.Fatalf("failed patch should be rolled back")
+		}
+	}
+}
+
+func TestApplyPatchRemovedActions(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterRole("editor", "Editor role")
+	R.RegisterRole("guest", "Guest role")
+	R.Permit("editor", usersPerm, Read, Update)
+	R.Deny("guest", usersPerm, Update)
+
+	B := New(nil)
+	usersPerm2, _ := B.RegisterPermission("users", "User resource", Create, Read, Delete)
+	B.RegisterRole("editor", "Editor role")
+	B.Permit("editor", usersPerm2, Read)
+
+	if err := R.ApplyPatch(Diff(R, B)); err != nil {
+		t.Fatalf("diff removing actions and their grants should be applied, err: %v", err)
+	}
+	if d := Diff(R, B); !d.Empty() {
+		t.Fatalf("patched instance should be same as target, diff:\n%s", d)
+	}
+}
+
+func TestApplyPatchGrantKinds(t *testing.T) {
+	owner := func(ctx context.Context, req *Request) bool { return true }
+	R := New(nil) //NewConsoleLogger()
+	R.RegisterCondition("owner", owner)
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterRole("editor", "Editor role")
+	R.RegisterRole("oncall", "On-call role")
+	R.PermitIf("editor", usersPerm, "owner", Update, Delete)
+	R.PermitInstance("editor", usersPerm, "42", Read)
+	R.PermitScheduled("oncall", usersPerm, &Schedule{Windows: []*Window{{Start: "09:00", End: "17:00"}}}, Read, Update)
+	R.SetRoleCardinality("oncall", 0, 2)
+
+	B := New(nil)
+	B.RegisterCondition("owner", owner)
+	usersPerm2, _ := B.RegisterPermission("users", "User resource", CRUD)
+	B.RegisterRole("editor", "Editor role")
+	B.RegisterRole("oncall", "On-call role")
+	B.PermitIf("editor", usersPerm2, "owner", Update)
+	B.PermitInstance("editor", usersPerm2, "43", Read)
+	B.PermitScheduled("oncall", usersPerm2, &Schedule{Windows: []*Window{{Start: "09:00", End: "17:00"}}}, Read)
+	B.PermitScheduled("oncall", usersPerm2, &Schedule{Windows: []*Window{{Start: "17:00", End: "09:00"}}}, Update)
+	B.SetRoleCardinality("oncall", 0, 3)
+
+	d := Diff(R, B)
+	expected := []string{
+		`~ cardinality of role oncall: min 0 max 3`,
+		`- conditional editor: users [delete] if owner`,
+		`- instance editor: users/42 [read]`,
+		`+ instance editor: users/43 [read]`,
+		`- scheduled oncall: users [update] {"not_before":"0001-01-01T00:00:00Z","not_after":"0001-01-01T00:00:00Z","windows":[{"start":"09:00","end":"17:00"}]}`,
+		`+ scheduled oncall: users [update] {"not_before":"0001-01-01T00:00:00Z","not_after":"0001-01-01T00:00:00Z","windows":[{"start":"17:00","end":"09:00"}]}`,
+	}
+	if d.String() != strings.Join(expected, "\n")+"\n" {
+		t.Fatalf("diff should be:\n%s\nnot:\n%s", strings.Join(expected, "\n"), d)
+	}
+	if err := R.ApplyPatch(d); err != nil {
+		t.Fatalf("diff of grant kinds should be applied, err: %v", err)
+	}
+	if d := Diff(R, B); !d.Empty() {
+		t.Fatalf("patched instance should be same as target, diff:\n%s", d)
+	}
+	if err := R.ApplyPatch(d); err == nil {
+		t.Fatalf("applying diff again should fail")
+	}
+}
@@ -7,6 +7,12 @@ import (
 	"sync"
 )
 
+// AnyPermission is wildcard permission ID, grants on it are valid for all permissions
+const AnyPermission = "*"
+
+// anyPermission is used to permit, revoke or deny actions for all permissions
+var anyPermission = newPermission(AnyPermission, "All permissions", AnyAction)
+
 // Permission defines rbac permission
 type Permission struct {
 	ID          string
@@ -22,15 +28,8 @@ type jsPermission struct {
 
 func newPermission(ID, description string, actions ...Action) *Permission {
 	perm := &Permission{ID: ID, Description: description}
-	for _, a := range actions {
-		if a == CRUD {
-			perm.Store(Create, nil)
-			perm.Store(Read, nil)
-			perm.Store(Update, nil)
-			perm.Store(Delete, nil)
-		} else {
-			perm.Store(a, nil)
-		}
+	for _, a := range expandBuiltinComposites(actions) {
+		perm.Store(a, nil)
 	}
 	return perm
 }
@@ -61,6 +60,13 @@ func (p *Permission) ActionsStrSlice() []string {
 	return res
 }
 
+// sortedActions returns list of Actions sorted
+func (p *Permission) sortedActions() []Action {
+	res := p.Actions()
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
 // MarshalJSON serializes a Permission to JSON
 func (p *Permission) MarshalJSON() ([]byte, error) {
 	return json.Marshal(jsPermission{
@@ -8,23 +8,54 @@ It can be used in middleware.
 */package rbac
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"reflect"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var log Logger
 
 // RBAC is role bases access control manager
 type RBAC struct {
+	revision    uint64   // increased on each mutation, kept first for 64-bit alignment of atomic access
 	sync.Map             // key: role.ID, value: role
 	permissions sync.Map // registered permissions
+	subjects    sync.Map // key: subject.ID, value: subject
+	// subjectsMu serializes role assignments, so checks of their constraints and the assignments are atomic
+	subjectsMu sync.Mutex
+	// hierarchical enables dotted path implication of permissions
+	hierarchical bool
+	implications implicationGraph
+	composites   sync.Map     // key: composite action, value: expanded actions
+	conditions   sync.Map     // key: condition name, value: ConditionFunc
+	domains      sync.Map     // key: domain, value: *sync.Map of domain roles
+	sod          sync.Map     // key: constraint ID, value: *SoDConstraint
+	grantIndex   sync.Map     // key: grantKey, value: *sync.Map of roles granted
+	snapshot     atomic.Value // *Snapshot
+	compileMu    sync.Mutex   // serializes compilations of snapshot
+	watchers     watchers
+	cache        atomic.Value // *decisionCache
+	auditor      atomic.Value // *auditor
+	adminLog     atomic.Value // *adminLog
+	// txMu is held for writing while a transaction is committed, checks hold it for reading
+	txMu sync.RWMutex
+	// clock returns current time for scheduled grants, time.Now is used if it is nil
+	clock func() time.Time
 }
 
 type jsRBAC struct {
-	Permissions []*Permission `json:"permissions"`
-	Roles       []*RoleGrants `json:"roles"`
+	Permissions  []*Permission         `json:"permissions"`
+	Roles        []*RoleGrants         `json:"roles"`
+	Subjects     []*SubjectAssignments `json:"subjects"`
+	Implications []*Implication        `json:"implications,omitempty"`
+	Composites   []*CompositeAction    `json:"composites,omitempty"`
+	Domains      []*DomainRoles        `json:"domains,omitempty"`
+	SoD          []*SoDConstraint      `json:"sod,omitempty"`
 }
 
 // New returns a new RBAC instance
@@ -42,44 +73,98 @@ func SetLogger(logger Logger) {
 	}
 }
 
-// Clone clones RBAC instance
+// Clone clones RBAC instance, roles and subjects are copied too if roles is true. Permissions are shared.
 func (r *RBAC) Clone(roles bool) (trg *RBAC) {
-	trg = &RBAC{}
+	trg = &RBAC{hierarchical: r.hierarchical, clock: r.clock}
+	r.implications.copyTo(&trg.implications)
+	r.composites.Range(func(k, v interface{}) bool {
+		trg.composites.Store(k, v)
+		return true
+	})
+	r.conditions.Range(func(k, v interface{}) bool {
+		trg.conditions.Store(k, v)
+		return true
+	})
+	r.sod.Range(func(k, v interface{}) bool {
+		trg.sod.Store(k, v)
+		return true
+	})
 	r.permissions.Range(func(k, v interface{}) bool {
 		trg.permissions.Store(k, v)
 		return true
 	})
 	if roles {
-		r.Range(func(k, v interface{}) bool {
-			trg.Store(k, v)
+		// roles are copied with their parents rebound to copies, so the clone and its indexes are independent
+		copies := map[*Role]*Role{}
+		for _, role := range append(r.Roles(), r.domainRoles()...) {
+			copies[role] = role.clone(trg)
+		}
+		for role, c := range copies {
+			role.parents.Range(func(k, v interface{}) bool {
+				c.parents.Store(k, copies[v.(*Role)])
+				return true
+			})
+			role.children.Range(func(k, _ interface{}) bool {
+				c.children.Store(copies[k.(*Role)], nil)
+				return true
+			})
+			trg.domain(role.Domain, true).Store(c.ID, c)
+		}
+		r.subjects.Range(func(k, v interface{}) bool {
+			s := &Subject{ID: v.(*Subject).ID}
+			copyMap(&v.(*Subject).Map, &s.Map)
+			trg.subjects.Store(k, s)
 			return true
 		})
+		trg.reindex()
 	}
 	return
 }
 
-// RegisterPermission defines and registers a permission
+// RegisterPermission defines and registers a permission, composite actions are expanded to their actions
 func (r *RBAC) RegisterPermission(permissionID, description string, actions ...Action) (*Permission, error) {
+	return r.RegisterPermissionContext(context.Background(), permissionID, description, actions...)
+}
+
+func (r *RBAC) registerPermission(ctx context.Context, permissionID, description string, actions ...Action) (*Permission, error) {
 	if r.IsPermissionExist(permissionID, "") {
 		log.Errorf("permission %s is already registered", permissionID)
 		return r.GetPermission(permissionID), fmt.Errorf("permission %s is already registered", permissionID)
 	}
-	perm := newPermission(permissionID, description, actions...)
+	perm := newPermission(permissionID, description, r.expandComposites(actions)...)
 	r.permissions.Store(permissionID, perm)
+	r.emit(ctx, Event{Type: EventPermissionRegistered, Permission: permissionID, Actions: perm.Actions()})
 	return perm, nil
 }
 
-// IsPermissionExist checks if a permission with target ID and action is defined
+// IsPermissionExist checks if a permission with target ID and action is defined. AnyAction exists for all
+// permissions, AnyPermission exists with all actions registered for any permission.
 func (r *RBAC) IsPermissionExist(permissionID string, action Action) (res bool) {
+	if permissionID == AnyPermission {
+		return action == None || action == AnyAction || r.isActionRegistered(action)
+	}
 	perm, res := r.permissions.Load(permissionID)
-	if res && action != None {
+	if res && action != None && action != AnyAction {
 		_, res = perm.(*Permission).Load(action)
 	}
 	return res
 }
 
-// GetPermission returns the permission if exists.  perm is nil if not found.
+// isActionRegistered checks if action is registered for any permission
+func (r *RBAC) isActionRegistered(action Action) (res bool) {
+	r.permissions.Range(func(_, v interface{}) bool {
+		_, res = v.(*Permission).Load(action)
+		return !res
+	})
+	return res
+}
+
+// GetPermission returns the permission if exists.  perm is nil if not found. For AnyPermission ID a wildcard
+// permission is returned which can be used to permit actions for all permissions.
 func (r *RBAC) GetPermission(permissionID string) *Permission {
+	if permissionID == AnyPermission {
+		return anyPermission
+	}
 	perm, ok := r.permissions.Load(permissionID)
 	if !ok {
 		log.Errorf("permission %s is not registered", permissionID)
@@ -88,14 +173,54 @@ func (r *RBAC) GetPermission(permissionID string) *Permission {
 	return perm.(*Permission)
 }
 
-//RegisterRole defines and registers a role
+// newQuery resolves permission path, actions and implied actions for a grant lookup
+func (r *RBAC) newQuery(permID string, actions ...Action) *query {
+	q := &query{perms: r.permPath(permID), actions: r.expandActions(permID, actions), now: r.now()}
+	q.implied = make([][]Action, len(q.actions))
+	for i, a := range q.actions {
+		q.implied[i] = r.implyingActions(permID, a)
+	}
+	return q
+}
+
+// expandActions replaces composite actions with their actions and AnyAction with all actions registered for
+// permission
+func (r *RBAC) expandActions(permID string, actions []Action) []Action {
+	actions = r.expandComposites(actions)
+	if !hasAction(actions, AnyAction) || permID == AnyPermission {
+		return actions
+	}
+	perm, ok := r.permissions.Load(permID)
+	if !ok {
+		return actions
+	}
+	res := []Action{}
+	for _, a := range actions {
+		if a != AnyAction {
+			res = append(res, a)
+		}
+	}
+	for _, a := range perm.(*Permission).Actions() {
+		if !hasAction(res, a) {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// RegisterRole defines and registers a role
 func (r *RBAC) RegisterRole(roleID string, description string) (*Role, error) {
+	return r.RegisterRoleContext(context.Background(), roleID, description)
+}
+
+func (r *RBAC) registerRole(ctx context.Context, roleID string, description string) (*Role, error) {
 	if r.IsRoleExist(roleID) {
 		log.Errorf("role %s is already registered", roleID)
 		return nil, fmt.Errorf("role %s is already registered", roleID)
 	}
-	role := &Role{ID: roleID, Description: description}
+	role := &Role{ID: roleID, Description: description, rbac: r}
 	r.Store(roleID, role)
+	r.emit(ctx, Event{Type: EventRoleRegistered, RoleID: roleID})
 	return role, nil
 }
 
@@ -111,19 +236,26 @@ func (r *RBAC) GetRole(roleID string) *Role {
 
 // RemoveRole deletes role from instance
 func (r *RBAC) RemoveRole(roleID string) error {
+	return r.RemoveRoleContext(context.Background(), roleID)
+}
+
+func (r *RBAC) removeRole(ctx context.Context, roleID string) error {
 	delRole := r.GetRole(roleID)
 	if delRole == nil {
 		log.Errorf("role %s is not registered", roleID)
 		return fmt.Errorf("role %s is  not registered", roleID)
 	}
-	for _, role := range r.Roles() {
+	for _, role := range append(r.Roles(), r.domainRoles()...) {
 		if role != nil {
-			if role.HasParent(roleID) {
-				role.RemoveParent(delRole)
+			if role.parentOf(roleID) == delRole {
+				role.removeParent(ctx, delRole)
 			}
 		}
 	}
+	r.unassignRoleFromAll(roleID)
+	r.unindexRole(delRole)
 	r.Delete(roleID)
+	r.emit(ctx, Event{Type: EventRoleRemoved, RoleID: roleID})
 	return nil
 }
 
@@ -142,48 +274,104 @@ func (r *RBAC) IsRoleExist(roleID string) (res bool) {
 	return res
 }
 
-// Permit grants a permission with defined actions to a role
-func (r *RBAC) Permit(roleID string, perm *Permission, actions ...Action) error {
+// validateActions checks if all actions are registered for permission
+func (r *RBAC) validateActions(perm *Permission, actions ...Action) error {
+	for _, a := range actions {
+		if !r.IsPermissionExist(perm.ID, a) {
+			log.Errorf("action %s is not registered for permission %s", a, perm.ID)
+			return fmt.Errorf("action %s is not registered for permission %s", a, perm.ID)
+		}
+	}
+	return nil
+}
+
+// opEvents are event types of modifyRole operations
+var opEvents = map[string]EventType{
+	"permitting to":  EventPermit,
+	"revoking from":  EventRevoke,
+	"denying to":     EventDeny,
+	"undenying from": EventUndeny,
+}
+
+// modifyRole expands and validates actions of permission, then calls fn with the role registered in domain
+func (r *RBAC) modifyRole(domain, roleID string, perm *Permission, op string, actions []Action, fn func(role *Role, actions []Action)) error {
+	return r.modifyRoleContext(context.Background(), domain, roleID, perm, op, actions, fn)
+}
+
+// modifyRoleContext is same as modifyRole, change is recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) modifyRoleContext(ctx context.Context, domain, roleID string, perm *Permission, op string, actions []Action, fn func(role *Role, actions []Action)) error {
+	return r.modifyScopedRole(ctx, domain, roleID, perm, op, actions, grantScope{}, fn)
+}
+
+// grantScope is the condition, resource instance or schedule a grant is limited to, it is set to change events and
+// admin log
+type grantScope struct {
+	condition string
+	instance  string
+	schedule  *Schedule
+}
+
+// modifyScopedRole is same as modifyRoleContext for grants limited to scope
+func (r *RBAC) modifyScopedRole(ctx context.Context, domain, roleID string, perm *Permission, op string, actions []Action, scope grantScope, fn func(role *Role, actions []Action)) error {
 	if perm == nil {
-		log.Errorf("nil perm is sent for revoking from role %s", roleID)
+		log.Errorf("nil perm is sent for %s role %s", op, roleID)
 		return fmt.Errorf("permission can not be nil")
 	}
-
-	if role, ok := r.Load(roleID); ok {
-		for _, a := range actions {
-			// Check if this action is valid for this permission:
-			if !r.IsPermissionExist(perm.ID, a) {
-				log.Errorf("action %s is not registered for permission %s", a, perm.ID)
-				return fmt.Errorf("action %s is not registered for permission %s", a, perm.ID)
-			}
-		}
-		role.(*Role).grant(perm, actions...)
-	} else {
-		log.Errorf("role %s is not registered")
+	role := r.roleIn(domain, roleID)
+	if role == nil {
+		log.Errorf("role %s is not registered", roleID)
 		return fmt.Errorf("role %s is not registered", roleID)
 	}
-	return nil
+	actions = r.expandComposites(actions)
+	if err := r.validateActions(perm, actions...); err != nil {
+		return err
+	}
+	c := &Change{Type: opEvents[op], Domain: domain, RoleID: roleID, Permission: perm.ID, Actions: actions,
+		Condition: scope.condition, Instance: scope.instance, Schedule: scope.schedule}
+	return r.record(ctx, c, func() error {
+		fn(role, actions)
+		r.emit(ctx, Event{Type: opEvents[op], Domain: domain, RoleID: roleID, Permission: perm.ID, Actions: actions,
+			Condition: scope.condition, Instance: scope.instance, Schedule: scope.schedule})
+		return nil
+	})
+}
+
+// Permit grants a permission with defined actions to a role. Actions implied by them(see AddImplication) are
+// granted also.
+func (r *RBAC) Permit(roleID string, perm *Permission, actions ...Action) error {
+	return r.PermitIn("", roleID, perm, actions...)
 }
 
 // Revoke removes a permission from a role
 func (r *RBAC) Revoke(roleID string, perm *Permission, actions ...Action) error {
+	return r.RevokeIn("", roleID, perm, actions...)
+}
+
+// Deny explicitly denies actions of a permission for a role. A denied action is never granted to the role
+// or any role inheriting from it, even if it is permitted to the role itself or to any of its ancestors.
+func (r *RBAC) Deny(roleID string, perm *Permission, actions ...Action) error {
+	return r.DenyIn("", roleID, perm, actions...)
+}
+
+// Undeny removes explicit denies of a permission from a role
+func (r *RBAC) Undeny(roleID string, perm *Permission, actions ...Action) error {
+	return r.UndenyIn("", roleID, perm, actions...)
+}
+
+// IsDenied checks if any of actions is explicitly denied for a role(including denies inherited from parents)
+func (r *RBAC) IsDenied(roleID string, perm *Permission, actions ...Action) bool {
 	if perm == nil {
-		log.Errorf("nil perm is sent for revoking from roleRoles %s", roleID)
-		return fmt.Errorf("permission can not be nil")
+		log.Errorf("Nil perm is sent for denied check for role %s", roleID)
+		return false
 	}
-	if role, ok := r.Load(roleID); ok {
-		for _, a := range actions {
-			if !r.IsPermissionExist(perm.ID, a) {
-				log.Errorf("action %s is not registered for permission %s", a, perm.ID)
-				return fmt.Errorf("action %s is not registered for permission %s", a, perm.ID)
+	if role := r.resolveRole("", roleID); role != nil {
+		for _, a := range r.expandActions(perm.ID, actions) {
+			if role.isDeniedInherited(r.permPath(perm.ID), a) {
+				return true
 			}
 		}
-		role.(*Role).revoke(perm, actions...)
-	} else {
-		log.Errorf("role %s is not registered", roleID)
-		return fmt.Errorf("role %s is not registered", roleID)
 	}
-	return nil
+	return false
 }
 
 // IsGranted checks if a role with target permission and actions has a grant
@@ -197,21 +385,32 @@ func (r *RBAC) IsGranted(roleID string, perm *Permission, actions ...Action) boo
 
 // IsGrantedStr checks if permID is granted with target actions for role
 func (r *RBAC) IsGrantedStr(roleID string, permID string, actions ...Action) bool {
-	if role, ok := r.Load(roleID); ok {
-		validActions := []Action{}
+	return r.decide(func() bool { return r.isGranted("", roleID, permID, actions...) }, func() *Decision {
+		return &Decision{Check: "IsGranted", Roles: []string{roleID}, Permission: permID, Actions: actions}
+	})
+}
+
+func (r *RBAC) isGranted(domain, roleID string, permID string, actions ...Action) bool {
+	return r.cached(newCacheKey(false, domain, roleID, permID, actions), func() (bool, bool) {
+		return r.isGrantedNoCache(domain, roleID, permID, actions...)
+	})
+}
+
+// isGrantedNoCache checks grants of the role, timed is set if the decision depends on time of the check
+func (r *RBAC) isGrantedNoCache(domain, roleID string, permID string, actions ...Action) (granted, timed bool) {
+	if role := r.resolveRole(domain, roleID); role != nil {
+		actions = r.expandComposites(actions)
 		for _, a := range actions {
 			// Check if this action is valid for this permission:
 			if !r.IsPermissionExist(permID, a) {
 				log.Errorf("Action %s for permission %s is not defined, while checking grants for role %s", a, permID, roleID)
-				return false
+				return false, false
 			}
-			validActions = append(validActions, a)
-		}
-		if role.(*Role).isGrantedStr(permID, validActions...) {
-			return true
 		}
+		q := r.newQuery(permID, actions...)
+		return role.isGrantedQ(q), q.timed
 	}
-	return false
+	return false, false
 }
 
 // IsGrantInherited checks if a role with target permission and actions has a grant
@@ -225,10 +424,25 @@ func (r *RBAC) IsGrantInherited(roleID string, perm *Permission, actions ...Acti
 
 // IsGrantInheritedStr checks if permID is granted with target actions for role
 func (r *RBAC) IsGrantInheritedStr(roleID string, permID string, actions ...Action) bool {
-	if role, ok := r.Load(roleID); ok {
-		return role.(*Role).isGrantInheritedStr(permID, actions...)
+	return r.decide(func() bool { return r.isGrantInherited("", roleID, permID, actions...) }, func() *Decision {
+		return &Decision{Check: "IsGrantInherited", Roles: []string{roleID}, Permission: permID, Actions: actions}
+	})
+}
+
+func (r *RBAC) isGrantInherited(domain, roleID string, permID string, actions ...Action) bool {
+	return r.cached(newCacheKey(true, domain, roleID, permID, actions), func() (bool, bool) {
+		return r.isGrantInheritedNoCache(domain, roleID, permID, actions...)
+	})
+}
+
+// isGrantInheritedNoCache checks grants of the role and its ancestors, timed is set if the decision depends on
+// time of the check
+func (r *RBAC) isGrantInheritedNoCache(domain, roleID string, permID string, actions ...Action) (granted, timed bool) {
+	if role := r.resolveRole(domain, roleID); role != nil {
+		q := r.newQuery(permID, actions...)
+		return role.isGrantInheritedQ(q), q.timed
 	}
-	return false
+	return false, false
 }
 
 func hasAction(actions []Action, action Action) bool {
@@ -240,44 +454,30 @@ func hasAction(actions []Action, action Action) bool {
 	return false
 }
 
-// GetAllPermissions returns granted permissions for a role(including inherited permissions from parents)
+// GetAllPermissions returns granted permissions for a role(including inherited permissions from all ancestors) with
+// sorted actions, see EffectivePermissions
 func (r *RBAC) GetAllPermissions(roleIDs []string) map[string][]Action {
 	perms := map[string][]Action{}
-	for _, roleID := range roleIDs {
-		if role, ok := r.Load(roleID); ok {
-			// Merge permission actions
-			for k, v := range role.(*Role).getGrants() {
-				if actions, ok := perms[k]; ok {
-					for _, a := range v {
-						if !hasAction(actions, a) {
-							actions = append(actions, a)
-						}
-					}
-					perms[k] = actions
-				} else {
-					perms[k] = v
-				}
-			}
-			// Merge permission actions with parent's actions
-			for _, pr := range role.(*Role).Parents() {
-				for k, v := range pr.getGrants() {
-					if actions, ok := perms[k]; ok {
-						for _, a := range v {
-							if !hasAction(actions, a) {
-								actions = append(actions, a)
-							}
-						}
-						perms[k] = actions
-					} else {
-						perms[k] = v
-					}
+	for _, ep := range r.EffectivePermissions(roleIDs) {
+		perms[ep.Permission] = ep.ActionList()
+	}
+	return perms
+}
+
+// mergeGrants adds actions of src to dst which are not already in dst
+func mergeGrants(dst, src grantsMap) {
+	for k, v := range src {
+		if actions, ok := dst[k]; ok {
+			for _, a := range v {
+				if !hasAction(actions, a) {
+					actions = append(actions, a)
 				}
 			}
+			dst[k] = actions
 		} else {
-			log.Errorf("Role with ID %s is not found", roleID)
+			dst[k] = v
 		}
 	}
-	return perms
 }
 
 // AnyGranted checks if any role has the permission.
@@ -285,10 +485,20 @@ func (r *RBAC) AnyGranted(roleIDs []string, perm *Permission, action ...Action)
 	return r.AnyGrantedStr(roleIDs, perm.ID, action...)
 }
 
-// AnyGrantedStr checks if any role has the permission.
+// AnyGrantedStr checks if any role has the permission. Roles violating a dynamic separation of duty constraint
+// together are not granted.
 func (r *RBAC) AnyGrantedStr(roleIDs []string, permName string, action ...Action) (res bool) {
+	return r.decide(func() bool { return r.anyGranted(roleIDs, permName, action...) }, func() *Decision {
+		return &Decision{Check: "AnyGranted", Roles: roleIDs, Permission: permName, Actions: action}
+	})
+}
+
+func (r *RBAC) anyGranted(roleIDs []string, permName string, action ...Action) (res bool) {
+	if !r.canActivate("", roleIDs) {
+		return false
+	}
 	for _, roleID := range roleIDs {
-		if r.IsGrantedStr(roleID, permName, action...) {
+		if r.isGranted("", roleID, permName, action...) {
 			res = true
 			break
 		}
@@ -303,8 +513,14 @@ func (r *RBAC) AllGranted(roleIDs []string, perm *Permission, action ...Action)
 
 // AllGrantedStr checks if all roles have the permission.
 func (r *RBAC) AllGrantedStr(roleIDs []string, permName string, action ...Action) (res bool) {
+	return r.decide(func() bool { return r.allGranted(roleIDs, permName, action...) }, func() *Decision {
+		return &Decision{Check: "AllGranted", Roles: roleIDs, Permission: permName, Actions: action}
+	})
+}
+
+func (r *RBAC) allGranted(roleIDs []string, permName string, action ...Action) (res bool) {
 	for _, roleID := range roleIDs {
-		if !r.IsGrantedStr(roleID, permName, action...) {
+		if !r.isGranted("", roleID, permName, action...) {
 			res = true
 			break
 		}
@@ -317,10 +533,20 @@ func (r *RBAC) AnyGrantInherited(roleIDs []string, perm *Permission, action ...A
 	return r.AnyGrantInheritedStr(roleIDs, perm.ID, action...)
 }
 
-// AnyGrantInheritedStr checks if any role has the permission.
+// AnyGrantInheritedStr checks if any role has the permission. Roles violating a dynamic separation of duty
+// constraint together are not granted.
 func (r *RBAC) AnyGrantInheritedStr(roleIDs []string, permName string, action ...Action) (res bool) {
+	return r.decide(func() bool { return r.anyGrantInherited("", roleIDs, permName, action...) }, func() *Decision {
+		return &Decision{Check: "AnyGrantInherited", Roles: roleIDs, Permission: permName, Actions: action}
+	})
+}
+
+func (r *RBAC) anyGrantInherited(domain string, roleIDs []string, permName string, action ...Action) (res bool) {
+	if !r.canActivate(domain, roleIDs) {
+		return false
+	}
 	for _, roleID := range roleIDs {
-		if r.IsGrantInheritedStr(roleID, permName, action...) {
+		if r.isGrantInherited(domain, roleID, permName, action...) {
 			res = true
 			break
 		}
@@ -335,8 +561,14 @@ func (r *RBAC) AllGrantInherited(roleIDs []string, perm *Permission, action ...A
 
 // AllGrantInheritedStr checks if all roles have the permission.
 func (r *RBAC) AllGrantInheritedStr(roleIDs []string, permName string, action ...Action) bool {
+	return r.decide(func() bool { return r.allGrantInherited(roleIDs, permName, action...) }, func() *Decision {
+		return &Decision{Check: "AllGrantInherited", Roles: roleIDs, Permission: permName, Actions: action}
+	})
+}
+
+func (r *RBAC) allGrantInherited(roleIDs []string, permName string, action ...Action) bool {
 	for _, roleID := range roleIDs {
-		if !r.IsGrantInheritedStr(roleID, permName, action...) {
+		if !r.isGrantInherited("", roleID, permName, action...) {
 			return false
 		}
 	}
@@ -345,16 +577,25 @@ func (r *RBAC) AllGrantInheritedStr(roleIDs []string, permName string, action ..
 
 // RoleGrants returns all roles
 func (r *RBAC) RoleGrants() []*RoleGrants {
+	return r.roleGrants(r.Roles())
+}
+
+func (r *RBAC) roleGrants(roles []*Role) []*RoleGrants {
+	now := r.now()
 	res := []*RoleGrants{}
-	r.Range(func(_, v interface{}) bool {
+	for _, role := range roles {
 		res = append(res, &RoleGrants{
-			ID:          v.(*Role).ID,
-			Description: v.(*Role).Description,
-			Grants:      v.(*Role).getGrants(),
-			Parents:     v.(*Role).ParentIDs(),
+			ID:          role.ID,
+			Description: role.Description,
+			Grants:      role.getGrants(),
+			Denies:      role.getDenies(),
+			Conditional: role.getConditionalGrants(),
+			Instances:   role.getInstanceGrants(),
+			Scheduled:   role.getScheduledGrants(now),
+			Cardinality: role.getCardinality(),
+			Parents:     role.ParentIDs(),
 		})
-		return true
-	})
+	}
 	return res
 }
 
@@ -368,48 +609,187 @@ func (r *RBAC) Permissions() []*Permission {
 	return res
 }
 
+func (r *RBAC) toJS() jsRBAC {
+	return jsRBAC{
+		Roles:        r.RoleGrants(),
+		Permissions:  r.Permissions(),
+		Subjects:     r.SubjectAssignments(),
+		Implications: r.Implications(),
+		Composites:   r.CompositeActions(),
+		Domains:      r.DomainRoleGrants(),
+		SoD:          r.SoDConstraints(),
+	}
+}
+
 // MarshalJSON serializes a all roles to JSON
 func (r *RBAC) MarshalJSON() ([]byte, error) {
-	return json.Marshal(jsRBAC{
-		Roles:       r.RoleGrants(),
-		Permissions: r.Permissions(),
-	})
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
+	return json.Marshal(r.toJS())
 }
 
-// UnmarshalJSON parses RBAC from JSON
-func (r *RBAC) UnmarshalJSON(b []byte) (err error) {
+// UnmarshalJSON parses RBAC from JSON. It is loaded to a staged copy of the instance first, so the instance is left
+// untouched if it can not be loaded, then it is loaded to the instance at once like a transaction is committed.
+func (r *RBAC) UnmarshalJSON(b []byte) error {
 	s := jsRBAC{}
-	if err = json.Unmarshal(b, &s); err != nil {
+	if err := json.Unmarshal(b, &s); err != nil {
 		return err
 	}
-	for _, roleGrants := range s.Roles {
-		_, err := r.RegisterRole(roleGrants.ID, roleGrants.Description)
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+	if err := r.stage().loadJS(withTxHeld(context.Background()), &s); err != nil {
+		log.Errorf("can not load instance, err: %v", err)
+		return err
+	}
+	return r.loadJS(withTxHeld(context.Background()), &s)
+}
+
+// loadJS loads s to the instance, a single bulk load is emitted and recorded instead of each loaded item. ctx
+// should be marked by withTxHeld.
+func (r *RBAC) loadJS(ctx context.Context, s *jsRBAC) (err error) {
+	defer func() {
+		r.recordBulkLoad()
+		ev := Event{Type: EventBulkLoad}
 		if err != nil {
+			ev.Detail = err.Error()
+		}
+		r.emit(context.Background(), ev)
+	}()
+	ctx = withLoading(ctx)
+	for _, c := range s.Composites {
+		if r.IsCompositeAction(c.Action) {
+			if !reflect.DeepEqual(r.expandComposites([]Action{c.Action}), r.expandComposites(c.Actions)) {
+				log.Errorf("composite action %s is already registered with different actions", c.Action)
+				return fmt.Errorf("composite action %s is already registered with different actions", c.Action)
+			}
+		} else if err = r.RegisterCompositeActionContext(ctx, c.Action, c.Actions...); err != nil {
 			return err
 		}
-		for permID, actions := range roleGrants.Grants {
-			perm, ok := r.permissions.Load(permID)
-			if !ok {
-				return fmt.Errorf("permission %s for role %s is not registered", permID, roleGrants.ID)
+	}
+	for _, impl := range s.Implications {
+		for _, implied := range impl.Implies {
+			if impl.Permission == "" {
+				err = r.AddImplicationContext(ctx, impl.Action, implied)
+			} else {
+				err = r.AddPermissionImplicationContext(ctx, r.GetPermission(impl.Permission), impl.Action, implied)
 			}
-			if err = r.Permit(roleGrants.ID, perm.(*Permission), actions...); err != nil {
+			if err != nil {
 				return err
 			}
 		}
 	}
-
+	for _, c := range s.SoD {
+		if err = r.addSoD(ctx, c); err != nil {
+			return err
+		}
+	}
 	for _, roleGrants := range s.Roles {
-		role := r.GetRole(roleGrants.ID)
+		if err = r.loadRole(ctx, "", roleGrants); err != nil {
+			return err
+		}
+	}
+	for _, d := range s.Domains {
+		for _, roleGrants := range d.Roles {
+			if err = r.loadRole(ctx, d.ID, roleGrants); err != nil {
+				return err
+			}
+		}
+	}
+	if err = r.loadParents(ctx, "", s.Roles); err != nil {
+		return err
+	}
+	for _, d := range s.Domains {
+		if err = r.loadParents(ctx, d.ID, d.Roles); err != nil {
+			return err
+		}
+	}
+
+	for _, subject := range s.Subjects {
+		for _, roleID := range subject.Roles {
+			if err = r.AssignRoleContext(ctx, subject.ID, roleID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// loadRole registers role in domain with its grants
+func (r *RBAC) loadRole(ctx context.Context, domain string, roleGrants *RoleGrants) (err error) {
+	role, err := r.registerRoleIn(ctx, domain, roleGrants.ID, roleGrants.Description)
+	if err != nil {
+		return err
+	}
+	if roleGrants.Cardinality != nil {
+		if err = role.setCardinality(roleGrants.Cardinality); err != nil {
+			return err
+		}
+	}
+	for permID, actions := range roleGrants.Grants {
+		perm := r.GetPermission(permID)
+		if perm == nil {
+			return fmt.Errorf("permission %s for role %s is not registered", permID, roleGrants.ID)
+		}
+		if err = r.permitIn(ctx, domain, roleGrants.ID, perm, actions...); err != nil {
+			return err
+		}
+	}
+	for permID, actions := range roleGrants.Denies {
+		perm := r.GetPermission(permID)
+		if perm == nil {
+			return fmt.Errorf("permission %s for role %s is not registered", permID, roleGrants.ID)
+		}
+		if err = r.denyIn(ctx, domain, roleGrants.ID, perm, actions...); err != nil {
+			return err
+		}
+	}
+	for _, cg := range roleGrants.Conditional {
+		perm := r.GetPermission(cg.Permission)
+		if perm == nil {
+			return fmt.Errorf("permission %s for role %s is not registered", cg.Permission, roleGrants.ID)
+		}
+		if err = r.permitIf(ctx, domain, roleGrants.ID, perm, cg.Condition, cg.Actions...); err != nil {
+			return err
+		}
+	}
+	for permID, instances := range roleGrants.Instances {
+		perm := r.GetPermission(permID)
+		if perm == nil {
+			return fmt.Errorf("permission %s for role %s is not registered", permID, roleGrants.ID)
+		}
+		for instanceID, actions := range instances {
+			if err = r.permitInstance(ctx, domain, roleGrants.ID, perm, instanceID, actions...); err != nil {
+				return err
+			}
+		}
+	}
+	for _, sg := range roleGrants.Scheduled {
+		perm := r.GetPermission(sg.Permission)
+		if perm == nil {
+			return fmt.Errorf("permission %s for role %s is not registered", sg.Permission, roleGrants.ID)
+		}
+		if err = r.permitScheduled(ctx, domain, roleGrants.ID, perm, sg.Schedule, sg.Actions...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadParents adds parents of roles in domain, parents are looked up in domain first, then in global roles. Parents
+// which are not found are skipped, see LoadJSONStrict to reject them.
+func (r *RBAC) loadParents(ctx context.Context, domain string, roles []*RoleGrants) error {
+	for _, roleGrants := range roles {
+		role := r.roleIn(domain, roleGrants.ID)
 		if role == nil {
 			log.Errorf("can not find role %s", roleGrants.ID)
-		} else {
-			for _, parentID := range roleGrants.Parents {
-				parentRole := r.GetRole(parentID)
-				if parentRole == nil {
-					log.Errorf("can not find parent role %s for role %s", parentID, parentRole.ID)
-				} else {
-					role.AddParent(parentRole)
-				}
+			continue
+		}
+		for _, parentID := range roleGrants.Parents {
+			parentRole := r.resolveRole(domain, parentID)
+			if parentRole == nil {
+				log.Errorf("can not find parent role %s for role %s", parentID, role.ID)
+			} else if err := role.addParentContext(ctx, parentRole); err != nil {
+				return err
 			}
 		}
 	}
@@ -423,12 +803,11 @@ func (r *RBAC) LoadJSON(reader io.Reader) error {
 
 // SaveJSON saves all to a writer
 func (r *RBAC) SaveJSON(writer io.Writer) (err error) {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
 	enc := json.NewEncoder(writer)
 	enc.SetIndent("", "  ")
-	if err = enc.Encode(jsRBAC{
-		Roles:       r.RoleGrants(),
-		Permissions: r.Permissions(),
-	}); err != nil {
+	if err = enc.Encode(r.toJS()); err != nil {
 		log.Errorf("can not encode to json, err:%v", err)
 		return err
 	}
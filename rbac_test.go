@@ -387,3 +387,17 @@ func TestDefaultLogger(t *testing.T) {
 		t.Fatalf("logger output is not compatible, expected: `%s`, got: `%s`", "", buf.Bytes())
 	}
 }
+
+func TestLoadJSONAtomic(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	R.RegisterPermission("users", "User resource", CRUD)
+	revision := R.Revision()
+	doc := `{"roles": [{"id": "user", "grants": {"users": ["read"]}, "parents": []},
+  {"id": "admin", "grants": {"reports": ["read"]}, "parents": []}], "subjects": [{"id": "alice", "roles": ["user"]}]}`
+	if err := R.LoadJSON(strings.NewReader(doc)); err == nil {
+		t.Fatalf("loading a role with unknown permission should fail")
+	}
+	if R.GetRole("user") != nil || len(R.SubjectRoles("alice")) != 0 || R.Revision() != revision {
+		t.Fatalf("instance should be left untouched when loading fails")
+	}
+}
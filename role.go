@@ -1,26 +1,42 @@
 package rbac
 
 import (
+	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Role defines a role
 type Role struct {
 	ID          string     `json:"id"`
 	Description string     `json:"description"`
+	Domain      string     `json:"domain,omitempty"` // empty for global roles
 	sync.Map    `json:"-"` // key: permissionID, values sync.Map[action]=true/false
 	parents     sync.Map
+	denies      sync.Map     // key: permissionID, values sync.Map[action]=nil
+	conditional sync.Map     // key: permissionID, values sync.Map[action]=sync.Map[condition]=nil
+	instances   sync.Map     // key: permissionID, values sync.Map[instanceID]=sync.Map[action]=nil
+	scheduled   sync.Map     // key: permissionID, values sync.Map[action]=*Schedule
+	children    sync.Map     // key: child role, value: nil
+	rbac        *RBAC        // instance role is registered in
+	cardinality atomic.Value // *Cardinality
 }
 
 type grantsMap map[string][]Action
 
 // RoleGrants is used during JSON Marshalling
 type RoleGrants struct {
-	ID          string    `json:"id"`
-	Description string    `json:"description"`
-	Grants      grantsMap `json:"grants"`
-	Parents     []string  `json:"parents"`
+	ID          string              `json:"id"`
+	Description string              `json:"description"`
+	Grants      grantsMap           `json:"grants"`
+	Denies      grantsMap           `json:"denies,omitempty"`
+	Conditional []*ConditionalGrant `json:"conditional,omitempty"`
+	Instances   instancesMap        `json:"instances,omitempty"`
+	Scheduled   []*ScheduledGrant   `json:"scheduled,omitempty"`
+	Cardinality *Cardinality        `json:"cardinality,omitempty"`
+	Parents     []string            `json:"parents"`
 }
 
 func (r *Role) grant(p *Permission, actions ...Action) {
@@ -33,6 +49,9 @@ func (r *Role) grant(p *Permission, actions ...Action) {
 	for _, a := range actions {
 		acts.(*sync.Map).Store(a, true)
 	}
+	if r.rbac != nil {
+		r.rbac.indexGrants(r, p.ID, actions...)
+	}
 }
 
 func (r *Role) revoke(p *Permission, actions ...Action) {
@@ -40,6 +59,9 @@ func (r *Role) revoke(p *Permission, actions ...Action) {
 		for _, a := range actions {
 			acts.(*sync.Map).Store(a, false)
 		}
+		if r.rbac != nil {
+			r.rbac.unindexGrants(r, p.ID, actions...)
+		}
 		// Remove permission from role if all actions are false
 		hasTrue := false
 		acts.(*sync.Map).Range(func(_, v interface{}) bool {
@@ -56,52 +78,216 @@ func (r *Role) revoke(p *Permission, actions ...Action) {
 	}
 }
 
-func (r *Role) isGranted(p *Permission, actions ...Action) (res bool) {
-	return r.isGrantedStr(p.ID, actions...)
+func (r *Role) deny(p *Permission, actions ...Action) {
+	acts, _ := r.denies.LoadOrStore(p.ID, &sync.Map{})
+	for _, a := range actions {
+		acts.(*sync.Map).Store(a, nil)
+	}
 }
 
-func (r *Role) isGrantedStr(pID string, actions ...Action) bool {
-	if acts, ok := r.Load(pID); ok {
+func (r *Role) undeny(p *Permission, actions ...Action) {
+	if acts, ok := r.denies.Load(p.ID); ok {
 		for _, a := range actions {
-			resI, ok := acts.(*sync.Map).Load(a)
-			if !ok || resI == nil || resI.(bool) == false {
-				log.Debugf("action %s is not granted to perm %s, found %v, %v", a, pID, ok, resI)
-				return false
-			}
+			acts.(*sync.Map).Delete(a)
+		}
+		if isEmpty(acts.(*sync.Map)) {
+			r.denies.Delete(p.ID)
+		}
+	}
+}
+
+// query is a resolved grant lookup
+type query struct {
+	// perms is the permission path, ID of the permission followed by IDs of its ancestor permissions
+	perms []string
+	// actions are requested actions
+	actions []Action
+	// implied[i] are the actions implying actions[i], a grant on them grants actions[i] also
+	implied [][]Action
+	// instance is the resource instance ID, instance grants are ignored if it is empty
+	instance string
+	// cond evaluates condition set of a conditional grant, conditional grants are ignored if it is nil
+	cond func(v interface{}) bool
+	// now is the time scheduled grants are evaluated at, scheduled grants are ignored if it is zero
+	now time.Time
+	// timed is set once a scheduled grant is evaluated, result of the query then depends on now
+	timed bool
+}
+
+func newQuery(pID string, actions ...Action) *query {
+	return &query{perms: []string{pID}, actions: actions, implied: make([][]Action, len(actions)), now: time.Now()}
+}
+
+// isActive checks if a scheduled grant is active at time of query
+func (q *query) isActive(v interface{}) bool {
+	q.timed = true
+	return v.(*Schedule).Active(q.now)
+}
+
+// matchAction calls fn for each value stored for acts in a permissionID->actions map, for each permission of pIDs.
+// Wildcard permission and wildcard action entries are also matched. It stops when fn returns true.
+func matchAction(m *sync.Map, pIDs []string, acts []Action, fn func(v interface{}) bool) bool {
+	for i := 0; i <= len(pIDs); i++ {
+		p := AnyPermission
+		if i < len(pIDs) {
+			p = pIDs[i]
+		} else if len(pIDs) > 0 && pIDs[0] == AnyPermission {
+			break
+		}
+		if am, ok := m.Load(p); ok && matchActs(am.(*sync.Map), acts, fn) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchActs calls fn for each value stored for acts in an actions map, wildcard action entry is also matched. It
+// stops when fn returns true.
+func matchActs(am *sync.Map, acts []Action, fn func(v interface{}) bool) bool {
+	for j := 0; j <= len(acts); j++ {
+		a := AnyAction
+		if j < len(acts) {
+			a = acts[j]
+		} else if len(acts) > 0 && acts[0] == AnyAction {
+			break
 		}
+		if v, ok := am.Load(a); ok && fn(v) {
+			return true
+		}
+	}
+	return false
+}
+
+func isTrue(v interface{}) bool {
+	return v != nil && v.(bool)
+}
+
+func exists(v interface{}) bool {
+	return true
+}
+
+// hasGrant checks if i'th action of query is granted to the role itself
+func (r *Role) hasGrant(q *query, i int) bool {
+	acts := append([]Action{q.actions[i]}, q.implied[i]...)
+	if matchAction(&r.Map, q.perms, acts, isTrue) {
+		return true
+	}
+	if q.instance != "" && r.hasInstanceGrant(q.perms[0], q.instance, acts) {
+		return true
+	}
+	if !q.now.IsZero() && matchAction(&r.scheduled, q.perms, acts, q.isActive) {
 		return true
 	}
-	log.Debugf("permission %s is not granted to role %s", pID, r.ID)
+	return q.cond != nil && matchAction(&r.conditional, q.perms, acts, q.cond)
+}
+
+// hasPerm checks if any action of permission is granted to the role itself
+func (r *Role) hasPerm(q *query) bool {
+	for i := 0; i <= len(q.perms); i++ {
+		pID := AnyPermission
+		if i < len(q.perms) {
+			pID = q.perms[i]
+		}
+		if _, ok := r.Load(pID); ok || (!q.now.IsZero() && r.hasScheduledPerm(q, pID)) {
+			return true
+		}
+	}
 	return false
 }
 
+// hasGrantInherited checks if i'th action of query is granted to the role or any of its ancestors
+func (r *Role) hasGrantInherited(q *query, i int) (res bool) {
+	if r.hasGrant(q, i) {
+		return true
+	}
+	r.parents.Range(func(_, value interface{}) bool {
+		res = value.(*Role).hasGrantInherited(q, i)
+		return !res
+	})
+	return res
+}
+
+// isDenied checks if action is explicitly denied on the role itself
+func (r *Role) isDenied(pIDs []string, a Action) bool {
+	return matchAction(&r.denies, pIDs, []Action{a}, exists)
+}
+
+// isDeniedInherited checks if action is explicitly denied on the role or any of its ancestors
+func (r *Role) isDeniedInherited(pIDs []string, a Action) (res bool) {
+	if r.isDenied(pIDs, a) {
+		return true
+	}
+	r.parents.Range(func(_, value interface{}) bool {
+		res = value.(*Role).isDeniedInherited(pIDs, a)
+		return !res
+	})
+	return res
+}
+
+// hasPermInherited checks if any action of permission is granted to the role or any of its ancestors
+func (r *Role) hasPermInherited(q *query) (res bool) {
+	if r.hasPerm(q) {
+		return true
+	}
+	r.parents.Range(func(_, value interface{}) bool {
+		res = value.(*Role).hasPermInherited(q)
+		return !res
+	})
+	return res
+}
+
+func (r *Role) isGranted(p *Permission, actions ...Action) (res bool) {
+	return r.isGrantedStr(p.ID, actions...)
+}
+
+func (r *Role) isGrantedStr(pID string, actions ...Action) bool {
+	return r.isGrantedQ(newQuery(pID, actions...))
+}
+
+// isGrantedQ checks grants of the role itself, denies of the role and its ancestors take precedence
+func (r *Role) isGrantedQ(q *query) bool {
+	if !r.hasPerm(q) {
+		log.Debugf("permission %s is not granted to role %s", q.perms[0], r.ID)
+		return false
+	}
+	for i, a := range q.actions {
+		if r.isDeniedInherited(q.perms, a) {
+			log.Debugf("action %s of perm %s is denied for role %s", a, q.perms[0], r.ID)
+			return false
+		}
+		if !r.hasGrant(q, i) {
+			log.Debugf("action %s is not granted to perm %s for role %s", a, q.perms[0], r.ID)
+			return false
+		}
+	}
+	return true
+}
+
 func (r *Role) isGrantInherited(p *Permission, actions ...Action) (res bool) {
 	return r.isGrantInheritedStr(p.ID, actions...)
 }
 
 func (r *Role) isGrantInheritedStr(pID string, actions ...Action) (res bool) {
-	if acts, ok := r.Load(pID); ok {
-		res = true
-		for _, a := range actions {
-			resI, ok := acts.(*sync.Map).Load(a)
-			if !ok || resI == nil || resI.(bool) == false {
-				log.Debugf("action %s is not granted to perm %s, found %v, %v", a, pID, ok, resI)
-				return false
-			}
-		}
+	return r.isGrantInheritedQ(newQuery(pID, actions...))
+}
+
+// isGrantInheritedQ checks grants of the role and its ancestors, each action may be granted by a different role
+// in the tree. Denies of the role and its ancestors take precedence.
+func (r *Role) isGrantInheritedQ(q *query) (res bool) {
+	if len(q.actions) == 0 {
+		return r.hasPermInherited(q)
 	}
-	if !res {
-		r.parents.Range(func(key, value interface{}) bool {
-			res = value.(*Role).isGrantInheritedStr(pID, actions...)
-			if res {
-				// returning false breaks out of Range call.
-				return false
-			}
-			// returning true continues Range call.
-			return true
-		})
+	for i, a := range q.actions {
+		if r.isDeniedInherited(q.perms, a) {
+			log.Debugf("action %s of perm %s is denied for role %s", a, q.perms[0], r.ID)
+			return false
+		}
+		if !r.hasGrantInherited(q, i) {
+			log.Debugf("action %s is not granted to perm %s for role %s", a, q.perms[0], r.ID)
+			return false
+		}
 	}
-	return res
+	return true
 }
 
 func (r *Role) getGrants() grantsMap {
@@ -111,6 +297,22 @@ func (r *Role) getGrants() grantsMap {
 		if _, ok := res[permID.(string)]; !ok {
 			res[permID.(string)] = []Action{}
 		}
+		v.(*sync.Map).Range(func(a, granted interface{}) bool {
+			if granted.(bool) {
+				res[permID.(string)] = append(res[permID.(string)], a.(Action))
+			}
+			return true
+		})
+		return true
+	})
+	return res
+}
+
+func (r *Role) getDenies() grantsMap {
+	var res = make(map[string][]Action)
+
+	r.denies.Range(func(permID, v interface{}) bool {
+		res[permID.(string)] = []Action{}
 		v.(*sync.Map).Range(func(a, _ interface{}) bool {
 			res[permID.(string)] = append(res[permID.(string)], a.(Action))
 			return true
@@ -120,12 +322,47 @@ func (r *Role) getGrants() grantsMap {
 	return res
 }
 
+// clone returns a copy of role registered in trg without its parents and children
+func (r *Role) clone(trg *RBAC) *Role {
+	c := &Role{ID: r.ID, Description: r.Description, Domain: r.Domain, rbac: trg}
+	copyMap(&r.Map, &c.Map)
+	copyMap(&r.denies, &c.denies)
+	copyMap(&r.conditional, &c.conditional)
+	copyMap(&r.instances, &c.instances)
+	copyMap(&r.scheduled, &c.scheduled)
+	if card := r.getCardinality(); card != nil {
+		c.cardinality.Store(card)
+	}
+	return c
+}
+
+// copyMap copies entries of src to dst, nested maps are copied deeply
+func copyMap(src, dst *sync.Map) {
+	src.Range(func(k, v interface{}) bool {
+		if m, ok := v.(*sync.Map); ok {
+			nested := &sync.Map{}
+			copyMap(m, nested)
+			v = nested
+		}
+		dst.Store(k, v)
+		return true
+	})
+}
+
 // HasParent checks if a role is in parent roles
 func (r *Role) HasParent(parentID string) bool {
 	_, ok := r.parents.Load(parentID)
 	return ok
 }
 
+// parentOf returns parent role with parentID, nil if not found
+func (r *Role) parentOf(parentID string) *Role {
+	if parent, ok := r.parents.Load(parentID); ok {
+		return parent.(*Role)
+	}
+	return nil
+}
+
 // HasAncestor checks if a role is in parent roles
 func (r *Role) HasAncestor(parentID string) bool {
 	ok := hasAncestorDeep(r, parentID)
@@ -152,25 +389,72 @@ func hasAncestorDeep(child *Role, parentID string) (found bool) {
 
 // AddParent Adds parent role
 func (r *Role) AddParent(parentRole *Role) error {
+	return r.addParentContext(context.Background(), parentRole)
+}
+
+func (r *Role) addParentContext(ctx context.Context, parentRole *Role) error {
+	if r.rbac == nil {
+		return r.addParent(ctx, parentRole)
+	}
+	return r.rbac.record(ctx, &Change{Type: EventParentAdded, Domain: r.Domain, RoleID: r.ID, ParentID: parentRole.ID}, func() error {
+		return r.addParent(ctx, parentRole)
+	})
+}
+
+func (r *Role) addParent(ctx context.Context, parentRole *Role) error {
 	if _, ok := r.parents.Load(parentRole.ID); ok {
 		log.Errorf("parent role with ID %s is already defined for role %s", parentRole.ID, r.ID)
 		return fmt.Errorf("parent role with ID %s is already defined for role %s", parentRole.ID, r.ID)
 	}
+	if parentRole.Domain != "" && parentRole.Domain != r.Domain {
+		log.Errorf("parent role %s of domain %s can not be added to role %s of domain %s", parentRole.ID, parentRole.Domain, r.ID, r.Domain)
+		return fmt.Errorf("parent role %s of domain %s can not be added to role %s of domain %s", parentRole.ID, parentRole.Domain, r.ID, r.Domain)
+	}
 	if parentRole.HasAncestor(r.ID) {
 		log.Errorf("circular reference is found for parentrole:%s while adding to role:%s", parentRole.ID, r.ID)
 		return fmt.Errorf("circular reference is found for parentrole:%s while adding to role:%s", parentRole.ID, r.ID)
 	}
+	if r.rbac != nil {
+		// assignments are checked and the parent is stored before any other role is assigned
+		r.rbac.subjectsMu.Lock()
+		defer r.rbac.subjectsMu.Unlock()
+		if err := r.rbac.checkParentSoD(r, parentRole); err != nil {
+			log.Errorf("can not add parent role %s to role %s, err: %v", parentRole.ID, r.ID, err)
+			return err
+		}
+	}
 	r.parents.Store(parentRole.ID, parentRole)
+	parentRole.children.Store(r, nil)
+	if r.rbac != nil {
+		r.rbac.emit(ctx, Event{Type: EventParentAdded, Domain: r.Domain, RoleID: r.ID, ParentID: parentRole.ID})
+	}
 	return nil
 }
 
 // RemoveParent removes parent role
 func (r *Role) RemoveParent(parentRole *Role) error {
+	return r.removeParentContext(context.Background(), parentRole)
+}
+
+func (r *Role) removeParentContext(ctx context.Context, parentRole *Role) error {
+	if r.rbac == nil {
+		return r.removeParent(ctx, parentRole)
+	}
+	return r.rbac.record(ctx, &Change{Type: EventParentRemoved, Domain: r.Domain, RoleID: r.ID, ParentID: parentRole.ID}, func() error {
+		return r.removeParent(ctx, parentRole)
+	})
+}
+
+func (r *Role) removeParent(ctx context.Context, parentRole *Role) error {
 	if _, ok := r.parents.Load(parentRole.ID); !ok {
 		log.Errorf("parent role with ID %s is not defined for role %s", parentRole.ID, r.ID)
 		return fmt.Errorf("parent role with ID %s is not defined for role %s", parentRole.ID, r.ID)
 	}
 	r.parents.Delete(parentRole.ID)
+	parentRole.children.Delete(r)
+	if r.rbac != nil {
+		r.rbac.emit(ctx, Event{Type: EventParentRemoved, Domain: r.Domain, RoleID: r.ID, ParentID: parentRole.ID})
+	}
 	return nil
 }
 
@@ -184,6 +468,24 @@ func (r *Role) Parents() []*Role {
 	return res
 }
 
+// ancestors returns all ancestor roles, nearest first
+func (r *Role) ancestors() []*Role {
+	res := []*Role{}
+	seen := map[string]bool{}
+	queue := r.Parents()
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if seen[cur.ID] {
+			continue
+		}
+		seen[cur.ID] = true
+		res = append(res, cur)
+		queue = append(queue, cur.Parents()...)
+	}
+	return res
+}
+
 // ParentIDs return a list of parent role IDs
 func (r *Role) ParentIDs() []string {
 	res := []string{}
@@ -0,0 +1,240 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Window is a recurring daily time window, like an on-call shift. Start and End are in "15:04" format and evaluated
+// in location of the clock time. A window with End before Start spans midnight, Days are matched with the day it
+// starts. Empty Days means every day.
+type Window struct {
+	Days  []time.Weekday `json:"days,omitempty"`
+	Start string         `json:"start"`
+	End   string         `json:"end"`
+}
+
+// Schedule bounds a grant in time. Zero NotBefore or NotAfter is unbounded, grant is active in any of Windows if
+// they are defined.
+type Schedule struct {
+	NotBefore time.Time `json:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+	Windows   []*Window `json:"windows,omitempty"`
+}
+
+// ScheduledGrant is a time-bounded grant, used during JSON Marshalling
+type ScheduledGrant struct {
+	Permission string    `json:"permission"`
+	Actions    []Action  `json:"actions"`
+	Schedule   *Schedule `json:"schedule"`
+	Expired    bool      `json:"expired,omitempty"`
+}
+
+// parseClock parses "15:04" into offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// validate checks bounds and windows of the schedule
+func (s *Schedule) validate() error {
+	if !s.NotBefore.IsZero() && !s.NotAfter.IsZero() && !s.NotBefore.Before(s.NotAfter) {
+		return fmt.Errorf("schedule not_before %v should be before not_after %v", s.NotBefore, s.NotAfter)
+	}
+	for _, w := range s.Windows {
+		start, err := parseClock(w.Start)
+		if err != nil {
+			return fmt.Errorf("invalid window start %s, err: %v", w.Start, err)
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			return fmt.Errorf("invalid window end %s, err: %v", w.End, err)
+		}
+		if start == end {
+			return fmt.Errorf("window start and end can not be same: %s", w.Start)
+		}
+	}
+	return nil
+}
+
+// Active checks if the schedule is active at t
+func (s *Schedule) Active(t time.Time) bool {
+	if (!s.NotBefore.IsZero() && t.Before(s.NotBefore)) || (!s.NotAfter.IsZero() && !t.Before(s.NotAfter)) {
+		return false
+	}
+	if len(s.Windows) == 0 {
+		return true
+	}
+	for _, w := range s.Windows {
+		if w.active(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired checks if the schedule can not be active at or after t
+func (s *Schedule) Expired(t time.Time) bool {
+	return !s.NotAfter.IsZero() && !t.Before(s.NotAfter)
+}
+
+func (w *Window) active(t time.Time) bool {
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return false
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return false
+	}
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	day := t.Weekday()
+	if start < end {
+		return offset >= start && offset < end && w.hasDay(day)
+	}
+	// window spans midnight
+	if offset >= start {
+		return w.hasDay(day)
+	}
+	return offset < end && w.hasDay((day+6)%7)
+}
+
+func (w *Window) hasDay(day time.Weekday) bool {
+	if len(w.Days) == 0 {
+		return true
+	}
+	for _, d := range w.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// SetClock sets the clock used for evaluating scheduled grants, nil resets it to time.Now. Clock is called while
+// checks hold a lock of the instance, so it must not call checks or mutations of the instance.
+func (r *RBAC) SetClock(clock func() time.Time) {
+	r.clock = clock
+}
+
+func (r *RBAC) now() time.Time {
+	if r.clock != nil {
+		return r.clock()
+	}
+	return time.Now()
+}
+
+// PermitScheduled grants a permission with defined actions to a role only while schedule is active. Permitting
+// same actions again replaces their schedule.
+func (r *RBAC) PermitScheduled(roleID string, perm *Permission, schedule *Schedule, actions ...Action) error {
+	return r.permitScheduled(context.Background(), "", roleID, perm, schedule, actions...)
+}
+
+func (r *RBAC) permitScheduled(ctx context.Context, domain, roleID string, perm *Permission, schedule *Schedule, actions ...Action) error {
+	if schedule == nil {
+		log.Errorf("nil schedule is sent for permitting to role %s", roleID)
+		return fmt.Errorf("schedule can not be nil")
+	}
+	if err := schedule.validate(); err != nil {
+		log.Errorf("invalid schedule for permitting to role %s, err: %v", roleID, err)
+		return err
+	}
+	return r.modifyScopedRole(ctx, domain, roleID, perm, "permitting to", actions, grantScope{schedule: schedule}, func(role *Role, actions []Action) {
+		role.grantScheduled(perm, schedule, actions...)
+	})
+}
+
+func (r *RBAC) revokeScheduledIn(ctx context.Context, domain, roleID string, perm *Permission, actions ...Action) error {
+	return r.modifyRoleContext(ctx, domain, roleID, perm, "revoking from", actions, func(role *Role, actions []Action) {
+		role.revokeScheduled(perm, actions...)
+	})
+}
+
+// RevokeScheduled removes scheduled grants from a role
+func (r *RBAC) RevokeScheduled(roleID string, perm *Permission, actions ...Action) error {
+	return r.RevokeScheduledContext(context.Background(), roleID, perm, actions...)
+}
+
+func (r *Role) grantScheduled(p *Permission, schedule *Schedule, actions ...Action) {
+	acts, _ := r.scheduled.LoadOrStore(p.ID, &sync.Map{})
+	for _, a := range actions {
+		acts.(*sync.Map).Store(a, schedule)
+	}
+}
+
+func (r *Role) revokeScheduled(p *Permission, actions ...Action) {
+	acts, ok := r.scheduled.Load(p.ID)
+	if !ok {
+		return
+	}
+	for _, a := range actions {
+		acts.(*sync.Map).Delete(a)
+	}
+	if isEmpty(acts.(*sync.Map)) {
+		r.scheduled.Delete(p.ID)
+	}
+}
+
+// hasScheduledPerm checks if any scheduled action of permission is active at time of query
+func (r *Role) hasScheduledPerm(q *query, pID string) (res bool) {
+	acts, ok := r.scheduled.Load(pID)
+	if !ok {
+		return false
+	}
+	acts.(*sync.Map).Range(func(_, s interface{}) bool {
+		res = q.isActive(s)
+		return !res
+	})
+	return res
+}
+
+// getActiveScheduledGrants returns scheduled grants active at t
+func (r *Role) getActiveScheduledGrants(t time.Time) grantsMap {
+	res := grantsMap{}
+	r.scheduled.Range(func(permID, acts interface{}) bool {
+		acts.(*sync.Map).Range(func(a, s interface{}) bool {
+			if s.(*Schedule).Active(t) {
+				res[permID.(string)] = append(res[permID.(string)], a.(Action))
+			}
+			return true
+		})
+		return true
+	})
+	return res
+}
+
+// getScheduledGrants returns scheduled grants grouped by permission and schedule, expired ones are marked
+func (r *Role) getScheduledGrants(t time.Time) []*ScheduledGrant {
+	res := []*ScheduledGrant{}
+	r.scheduled.Range(func(permID, acts interface{}) bool {
+		bySchedule := map[*Schedule]*ScheduledGrant{}
+		acts.(*sync.Map).Range(func(a, s interface{}) bool {
+			sg, ok := bySchedule[s.(*Schedule)]
+			if !ok {
+				sg = &ScheduledGrant{Permission: permID.(string), Actions: []Action{}, Schedule: s.(*Schedule), Expired: s.(*Schedule).Expired(t)}
+				bySchedule[s.(*Schedule)] = sg
+				res = append(res, sg)
+			}
+			sg.Actions = append(sg.Actions, a.(Action))
+			return true
+		})
+		return true
+	})
+	for _, sg := range res {
+		sort.Slice(sg.Actions, func(i, j int) bool { return sg.Actions[i] < sg.Actions[j] })
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Permission != res[j].Permission {
+			return res[i].Permission < res[j].Permission
+		}
+		return res[i].Actions[0] < res[j].Actions[0]
+	})
+	return res
+}
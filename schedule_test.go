@@ -0,0 +1,101 @@
+package rbac
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestScheduledGrant(t *testing.T) {
+	R := New(nil)                                       //NewConsoleLogger()
+	now := time.Date(2024, 5, 6, 10, 0, 0, 0, time.UTC) // Monday
+	R.SetClock(func() time.Time { return now })
+	postPerm, err := R.RegisterPermission("post", "Post resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register post permission, err: %v", err)
+	}
+	contractorRole, _ := R.RegisterRole("contractor", "Contractor role")
+	onCallRole, _ := R.RegisterRole("oncall", "On-call role")
+	teamRole, _ := R.RegisterRole("team", "Team role")
+	if err = teamRole.AddParent(contractorRole); err != nil {
+		t.Fatalf("adding parent role failed with: %v", err)
+	}
+
+	until := &Schedule{NotAfter: now.Add(24 * time.Hour)}
+	if err = R.PermitScheduled(contractorRole.ID, postPerm, until, Update); err != nil {
+		t.Fatalf("can not permit scheduled post.update, err: %v", err)
+	}
+	if err = R.PermitScheduled(contractorRole.ID, postPerm, nil, Update); err == nil {
+		t.Fatalf("PermitScheduled should fail with nil schedule")
+	}
+	if err = R.PermitScheduled(contractorRole.ID, postPerm, &Schedule{NotBefore: now, NotAfter: now}, Update); err == nil {
+		t.Fatalf("PermitScheduled should fail with empty time range")
+	}
+	if err = R.PermitScheduled(contractorRole.ID, postPerm, &Schedule{Windows: []*Window{{Start: "25:00", End: "08:00"}}}, Update); err == nil {
+		t.Fatalf("PermitScheduled should fail with invalid window")
+	}
+	shift := &Schedule{Windows: []*Window{{Days: []time.Weekday{time.Monday}, Start: "22:00", End: "06:00"}}}
+	if err = R.PermitScheduled(onCallRole.ID, postPerm, shift, Delete); err != nil {
+		t.Fatalf("can not permit scheduled post.delete, err: %v", err)
+	}
+
+	if !R.IsGranted(contractorRole.ID, postPerm, Update) || !R.IsGrantInherited(teamRole.ID, postPerm, Update) {
+		t.Fatalf("contractor should have post.update until tomorrow")
+	}
+	if R.IsGranted(onCallRole.ID, postPerm, Delete) {
+		t.Fatalf("oncall should not have post.delete out of shift")
+	}
+	if perms := R.GetAllPermissions([]string{teamRole.ID}); len(perms["post"]) != 1 || perms["post"][0] != Update {
+		t.Fatalf("active scheduled grants should be listed, got %v", perms)
+	}
+
+	now = time.Date(2024, 5, 6, 23, 0, 0, 0, time.UTC) // Monday night
+	if !R.IsGranted(onCallRole.ID, postPerm, Delete) || !R.AnyGranted([]string{"oncall"}, postPerm, Delete) {
+		t.Fatalf("oncall should have post.delete in shift")
+	}
+	now = time.Date(2024, 5, 7, 5, 0, 0, 0, time.UTC) // Tuesday morning
+	if !R.IsGranted(onCallRole.ID, postPerm, Delete) {
+		t.Fatalf("oncall should have post.delete in shift after midnight")
+	}
+	now = time.Date(2024, 5, 7, 23, 0, 0, 0, time.UTC) // Tuesday night
+	if R.IsGranted(onCallRole.ID, postPerm, Delete) {
+		t.Fatalf("oncall should not have post.delete on tuesday night")
+	}
+	if R.IsGranted(contractorRole.ID, postPerm, Update) || R.IsGrantInherited(teamRole.ID, postPerm) {
+		t.Fatalf("contractor should not have post.update after expiry")
+	}
+	if perms := R.GetAllPermissions([]string{teamRole.ID}); len(perms) != 0 {
+		t.Fatalf("expired scheduled grants should not be listed, got %v", perms)
+	}
+
+	// Round trip
+	var buf bytes.Buffer
+	if err = R.SaveJSON(&buf); err != nil {
+		t.Fatalf("unable to save to json, err:%v", err)
+	}
+	R2 := New(nil)
+	R2.SetClock(func() time.Time { return now })
+	R2.RegisterPermission("post", "Post resource", CRUD)
+	if err = R2.LoadJSON(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("unable to load from json, err:%v", err)
+	}
+	js := jsRBAC{}
+	json.Unmarshal(buf.Bytes(), &js)
+	for _, rg := range js.Roles {
+		if rg.ID == contractorRole.ID && (len(rg.Scheduled) != 1 || !rg.Scheduled[0].Expired) {
+			t.Fatalf("expired scheduled grant should be marked in dump")
+		}
+	}
+	now = time.Date(2024, 5, 13, 22, 30, 0, 0, time.UTC) // next Monday night
+	if !R2.IsGranted(onCallRole.ID, postPerm, Delete) {
+		t.Fatalf("loaded oncall should have post.delete in shift")
+	}
+
+	if err = R.RevokeScheduled(onCallRole.ID, postPerm, Delete); err != nil {
+		t.Fatalf("can not revoke scheduled post.delete, err: %v", err)
+	}
+	if R.IsGranted(onCallRole.ID, postPerm, Delete) {
+		t.Fatalf("oncall should not have post.delete after revoke")
+	}
+}
@@ -0,0 +1,87 @@
+package rbac
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Session is a set of roles activated by a subject, activated roles should not violate dynamic separation of duty
+// constraints together
+type Session struct {
+	SubjectID string
+	rbac      *RBAC
+	mu        sync.Mutex
+	roles     map[string]bool
+}
+
+// NewSession creates a session for subject and activates roles in it
+func (r *RBAC) NewSession(subjectID string, roleIDs ...string) (*Session, error) {
+	s := &Session{SubjectID: subjectID, rbac: r, roles: map[string]bool{}}
+	for _, roleID := range roleIDs {
+		if err := s.ActivateRole(roleID); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// ActivateRole activates a role assigned to subject of the session
+func (s *Session) ActivateRole(roleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !hasString(s.rbac.SubjectRoles(s.SubjectID), roleID) {
+		log.Errorf("role %s is not assigned to subject %s", roleID, s.SubjectID)
+		return fmt.Errorf("role %s is not assigned to subject %s", roleID, s.SubjectID)
+	}
+	if s.roles[roleID] {
+		log.Errorf("role %s is already activated for subject %s", roleID, s.SubjectID)
+		return fmt.Errorf("role %s is already activated for subject %s", roleID, s.SubjectID)
+	}
+	if err := s.rbac.checkSoD(s.rbac.effectiveRoleIDs("", append(s.roleIDs(), roleID)), true); err != nil {
+		log.Errorf("can not activate role %s for subject %s, err: %v", roleID, s.SubjectID, err)
+		return err
+	}
+	s.roles[roleID] = true
+	return nil
+}
+
+// DeactivateRole deactivates a role in the session
+func (s *Session) DeactivateRole(roleID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.roles[roleID] {
+		log.Errorf("role %s is not activated for subject %s", roleID, s.SubjectID)
+		return fmt.Errorf("role %s is not activated for subject %s", roleID, s.SubjectID)
+	}
+	delete(s.roles, roleID)
+	return nil
+}
+
+// RoleIDs returns sorted list of activated role IDs
+func (s *Session) RoleIDs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.roleIDs()
+}
+
+func (s *Session) roleIDs() []string {
+	res := []string{}
+	for roleID := range s.roles {
+		res = append(res, roleID)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// IsGranted checks if any activated role has the permission(including inherited permissions from parents)
+func (s *Session) IsGranted(perm *Permission, actions ...Action) bool {
+	if perm == nil {
+		log.Errorf("Nil perm is sent for granted check for subject %s", s.SubjectID)
+		return false
+	}
+	roleIDs := s.RoleIDs()
+	return s.rbac.decide(func() bool { return s.rbac.anyGrantInherited("", roleIDs, perm.ID, actions...) }, func() *Decision {
+		return &Decision{Check: "Session.IsGranted", Subject: s.SubjectID, Roles: roleIDs, Permission: perm.ID, Actions: actions}
+	})
+}
@@ -0,0 +1,223 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// SoDConstraint is a separation of duty constraint, a subject can not hold(static) or activate together(dynamic)
+// more than one of Roles. Roles are matched by ID, including inherited roles, in all domains.
+type SoDConstraint struct {
+	ID      string   `json:"id"`
+	Roles   []string `json:"roles"`
+	Dynamic bool     `json:"dynamic,omitempty"`
+}
+
+// conflict returns two conflicting role IDs of the constraint which are in roleIDs
+func (c *SoDConstraint) conflict(roleIDs map[string]bool) (string, string, bool) {
+	found := ""
+	for _, roleID := range c.Roles {
+		if !roleIDs[roleID] {
+			continue
+		}
+		if found != "" {
+			return found, roleID, true
+		}
+		found = roleID
+	}
+	return "", "", false
+}
+
+// AddStaticSoD registers a static separation of duty constraint, roles can not be assigned to the same subject.
+// A role can not inherit more than one of roles either.
+func (r *RBAC) AddStaticSoD(id string, roleIDs ...string) error {
+	return r.AddStaticSoDContext(context.Background(), id, roleIDs...)
+}
+
+// AddDynamicSoD registers a dynamic separation of duty constraint, roles can be assigned to the same subject but
+// can not be activated together in a check or session. A role can not inherit more than one of roles either.
+func (r *RBAC) AddDynamicSoD(id string, roleIDs ...string) error {
+	return r.AddDynamicSoDContext(context.Background(), id, roleIDs...)
+}
+
+func (r *RBAC) addSoD(ctx context.Context, c *SoDConstraint) error {
+	change := &Change{Type: EventPolicyChanged, Detail: "separation of duty constraint " + c.ID + " added"}
+	return r.recordPolicy(ctx, change, r.sodState, func() error {
+		return r.storeSoD(ctx, c)
+	})
+}
+
+// storeSoD validates and stores a separation of duty constraint
+func (r *RBAC) storeSoD(ctx context.Context, c *SoDConstraint) error {
+	if c.ID == "" {
+		log.Errorf("separation of duty constraint ID can not be empty")
+		return fmt.Errorf("separation of duty constraint ID can not be empty")
+	}
+	roles := []string{}
+	for _, roleID := range c.Roles {
+		if !hasString(roles, roleID) {
+			roles = append(roles, roleID)
+		}
+	}
+	if len(roles) < 2 {
+		log.Errorf("separation of duty constraint %s should have at least two roles", c.ID)
+		return fmt.Errorf("separation of duty constraint %s should have at least two roles", c.ID)
+	}
+	sort.Strings(roles)
+	c = &SoDConstraint{ID: c.ID, Roles: roles, Dynamic: c.Dynamic}
+	if _, ok := r.sod.Load(c.ID); ok {
+		log.Errorf("separation of duty constraint %s is already registered", c.ID)
+		return fmt.Errorf("separation of duty constraint %s is already registered", c.ID)
+	}
+	for _, role := range append(r.Roles(), r.domainRoles()...) {
+		if a, b, ok := c.conflict(roleIDSet(role)); ok {
+			log.Errorf("role %s violates separation of duty constraint %s, it inherits %s and %s", role.ID, c.ID, a, b)
+			return fmt.Errorf("role %s violates separation of duty constraint %s, it inherits %s and %s", role.ID, c.ID, a, b)
+		}
+	}
+	if !c.Dynamic {
+		// assignments are checked and the constraint is stored before any other role is assigned
+		r.subjectsMu.Lock()
+		defer r.subjectsMu.Unlock()
+		for _, s := range r.SubjectAssignments() {
+			if a, b, ok := c.conflict(r.effectiveRoleIDs("", s.Roles)); ok {
+				log.Errorf("subject %s violates separation of duty constraint %s, it holds %s and %s", s.ID, c.ID, a, b)
+				return fmt.Errorf("subject %s violates separation of duty constraint %s, it holds %s and %s", s.ID, c.ID, a, b)
+			}
+		}
+	}
+	r.sod.Store(c.ID, c)
+	r.emit(ctx, Event{Type: EventPolicyChanged, Detail: "separation of duty constraint " + c.ID + " added"})
+	return nil
+}
+
+// RemoveSoD removes a separation of duty constraint
+func (r *RBAC) RemoveSoD(id string) error {
+	return r.RemoveSoDContext(context.Background(), id)
+}
+
+func (r *RBAC) removeSoD(ctx context.Context, id string) error {
+	if _, ok := r.sod.Load(id); !ok {
+		log.Errorf("separation of duty constraint %s is not registered", id)
+		return fmt.Errorf("separation of duty constraint %s is not registered", id)
+	}
+	r.sod.Delete(id)
+	r.emit(ctx, Event{Type: EventPolicyChanged, Detail: "separation of duty constraint " + id + " removed"})
+	return nil
+}
+
+// SoDConstraints returns separation of duty constraints sorted by ID
+func (r *RBAC) SoDConstraints() []*SoDConstraint {
+	res := []*SoDConstraint{}
+	r.sod.Range(func(_, v interface{}) bool {
+		res = append(res, v.(*SoDConstraint))
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+// checkSoD returns an error if roleIDs violates any constraint, dynamic constraints are checked only if dynamic is
+// true
+func (r *RBAC) checkSoD(roleIDs map[string]bool, dynamic bool) error {
+	var err error
+	r.sod.Range(func(_, v interface{}) bool {
+		c := v.(*SoDConstraint)
+		if c.Dynamic && !dynamic {
+			return true
+		}
+		if a, b, ok := c.conflict(roleIDs); ok {
+			err = fmt.Errorf("roles %s and %s violate separation of duty constraint %s", a, b, c.ID)
+		}
+		return err == nil
+	})
+	return err
+}
+
+// canActivate checks if roles resolved in domain can be activated together, violations are logged
+func (r *RBAC) canActivate(domain string, roleIDs []string) bool {
+	if len(roleIDs) < 2 || isEmpty(&r.sod) {
+		return true
+	}
+	if err := r.checkSoD(r.effectiveRoleIDs(domain, roleIDs), true); err != nil {
+		log.Errorf("can not activate roles %v, err: %v", roleIDs, err)
+		return false
+	}
+	return true
+}
+
+// checkParentSoD checks if adding parent to role makes the role, any of its descendants or any subject holding them
+// violate a constraint, subjectsMu should be held
+func (r *RBAC) checkParentSoD(role, parent *Role) error {
+	parentIDs := roleIDSet(parent)
+	for _, rl := range append(r.Roles(), r.domainRoles()...) {
+		if rl != role && !hasRole(rl.ancestors(), role) {
+			continue
+		}
+		ids := roleIDSet(rl)
+		for id := range parentIDs {
+			ids[id] = true
+		}
+		if err := r.checkSoD(ids, true); err != nil {
+			return fmt.Errorf("role %s can not inherit %s, %v", rl.ID, parent.ID, err)
+		}
+	}
+	if role.Domain != "" {
+		return nil
+	}
+	for _, s := range r.SubjectAssignments() {
+		ids := r.effectiveRoleIDs("", s.Roles)
+		if !ids[role.ID] {
+			continue
+		}
+		for id := range parentIDs {
+			ids[id] = true
+		}
+		if err := r.checkSoD(ids, false); err != nil {
+			return fmt.Errorf("subject %s can not inherit %s through role %s, %v", s.ID, parent.ID, role.ID, err)
+		}
+	}
+	return nil
+}
+
+// effectiveRoleIDs returns set of roleIDs resolved in domain and their ancestors
+func (r *RBAC) effectiveRoleIDs(domain string, roleIDs []string) map[string]bool {
+	res := map[string]bool{}
+	for _, roleID := range roleIDs {
+		res[roleID] = true
+		if role := r.resolveRole(domain, roleID); role != nil {
+			for _, a := range role.ancestors() {
+				res[a.ID] = true
+			}
+		}
+	}
+	return res
+}
+
+// roleIDSet returns set of IDs of role and its ancestors
+func roleIDSet(role *Role) map[string]bool {
+	res := map[string]bool{role.ID: true}
+	for _, a := range role.ancestors() {
+		res[a.ID] = true
+	}
+	return res
+}
+
+func hasRole(roles []*Role, role *Role) bool {
+	for _, rl := range roles {
+		if rl == role {
+			return true
+		}
+	}
+	return false
+}
+
+func hasString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
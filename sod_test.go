@@ -0,0 +1,192 @@
+package rbac
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestSoD(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	paymentsPerm, err := R.RegisterPermission("payments", "Payment resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register payments permission, err: %v", err)
+	}
+	initiatorRole, _ := R.RegisterRole("payments-initiator", "Payment initiator role")
+	approverRole, _ := R.RegisterRole("payments-approver", "Payment approver role")
+	auditorRole, _ := R.RegisterRole("auditor", "Auditor role")
+	reviewerRole, _ := R.RegisterRole("reviewer", "Reviewer role")
+	managerRole, _ := R.RegisterRole("manager", "Manager role")
+	R.Permit(initiatorRole.ID, paymentsPerm, Create)
+	R.Permit(approverRole.ID, paymentsPerm, Update)
+	R.Permit(auditorRole.ID, paymentsPerm, Read)
+
+	if err = R.AddStaticSoD("payments", initiatorRole.ID, approverRole.ID); err != nil {
+		t.Fatalf("can not add static SoD, err: %v", err)
+	}
+	if err = R.AddStaticSoD("payments", initiatorRole.ID, auditorRole.ID); err == nil {
+		t.Fatalf("should not be able to add SoD constraint twice")
+	}
+	if err = R.AddStaticSoD("single", initiatorRole.ID, initiatorRole.ID); err == nil {
+		t.Fatalf("should not be able to add SoD constraint with a single role")
+	}
+	if err = R.AddDynamicSoD("audit", auditorRole.ID, reviewerRole.ID); err != nil {
+		t.Fatalf("can not add dynamic SoD, err: %v", err)
+	}
+
+	// Static
+	if err = R.AssignRole("alice", initiatorRole.ID); err != nil {
+		t.Fatalf("can not assign initiator to alice, err: %v", err)
+	}
+	if err = R.AssignRole("alice", approverRole.ID); err == nil {
+		t.Fatalf("should not be able to assign approver to alice")
+	}
+	if err = managerRole.AddParent(approverRole); err != nil {
+		t.Fatalf("adding parent role failed with: %v", err)
+	}
+	if err = R.AssignRole("alice", managerRole.ID); err == nil {
+		t.Fatalf("should not be able to assign manager inheriting approver to alice")
+	}
+	if err = managerRole.AddParent(initiatorRole); err == nil {
+		t.Fatalf("manager should not be able to inherit both initiator and approver")
+	}
+	if err = initiatorRole.AddParent(approverRole); err == nil {
+		t.Fatalf("initiator should not be able to inherit approver")
+	}
+
+	// Dynamic
+	if err = R.AssignRole("bob", auditorRole.ID); err != nil {
+		t.Fatalf("can not assign auditor to bob, err: %v", err)
+	}
+	if err = R.AssignRole("bob", reviewerRole.ID); err != nil {
+		t.Fatalf("can not assign reviewer to bob, err: %v", err)
+	}
+	if R.AnyGrantInherited([]string{auditorRole.ID, reviewerRole.ID}, paymentsPerm, Read) || R.IsSubjectGranted("bob", paymentsPerm, Read) {
+		t.Fatalf("auditor and reviewer should not be activated together")
+	}
+	if !R.AnyGrantInherited([]string{auditorRole.ID}, paymentsPerm, Read) {
+		t.Fatalf("auditor should have payments.read")
+	}
+	if err = reviewerRole.AddParent(auditorRole); err == nil {
+		t.Fatalf("reviewer should not be able to inherit auditor")
+	}
+	s, err := R.NewSession("bob", auditorRole.ID)
+	if err != nil {
+		t.Fatalf("can not create session for bob, err: %v", err)
+	}
+	if !s.IsGranted(paymentsPerm, Read) {
+		t.Fatalf("bob should have payments.read in session")
+	}
+	if err = s.ActivateRole(reviewerRole.ID); err == nil {
+		t.Fatalf("should not be able to activate reviewer with auditor")
+	}
+	if err = s.ActivateRole(initiatorRole.ID); err == nil {
+		t.Fatalf("should not be able to activate a role not assigned to bob")
+	}
+	if err = s.DeactivateRole(auditorRole.ID); err != nil {
+		t.Fatalf("can not deactivate auditor, err: %v", err)
+	}
+	if err = s.ActivateRole(reviewerRole.ID); err != nil {
+		t.Fatalf("can not activate reviewer, err: %v", err)
+	}
+	if !reflect.DeepEqual(s.RoleIDs(), []string{reviewerRole.ID}) || s.IsGranted(paymentsPerm, Read) {
+		t.Fatalf("session roles are not valid, got %v", s.RoleIDs())
+	}
+
+	if err = R.AddStaticSoD("audit-static", auditorRole.ID, reviewerRole.ID); err == nil {
+		t.Fatalf("should not be able to add static SoD violated by bob")
+	}
+
+	// Round trip
+	var buf bytes.Buffer
+	if err = R.SaveJSON(&buf); err != nil {
+		t.Fatalf("unable to save to json, err:%v", err)
+	}
+	R2 := New(nil)
+	R2.RegisterPermission("payments", "Payment resource", CRUD)
+	if err = R2.LoadJSON(&buf); err != nil {
+		t.Fatalf("unable to load from json, err:%v", err)
+	}
+	if !reflect.DeepEqual(R.SoDConstraints(), R2.SoDConstraints()) {
+		t.Fatalf("loaded SoD constraints differ")
+	}
+	if err = R2.AssignRole("alice", approverRole.ID); err == nil {
+		t.Fatalf("loaded static SoD should be enforced")
+	}
+
+	if err = R.RemoveSoD("payments"); err != nil {
+		t.Fatalf("can not remove SoD, err: %v", err)
+	}
+	if err = R.AssignRole("alice", approverRole.ID); err != nil {
+		t.Fatalf("can not assign approver to alice after removing SoD, err: %v", err)
+	}
+}
+
+func TestSoDConcurrentAssign(t *testing.T) {
+	for i := 0; i < 200; i++ {
+		R := New(nil) //NewConsoleLogger()
+		R.RegisterRole("payments-initiator", "Payment initiator role")
+		R.RegisterRole("payments-approver", "Payment approver role")
+		R.AddStaticSoD("payments", "payments-initiator", "payments-approver")
+		var wg sync.WaitGroup
+		for _, roleID := range []string{"payments-initiator", "payments-approver"} {
+			wg.Add(1)
+			go func(roleID string) {
+				defer wg.Done()
+				R.AssignRole("alice", roleID)
+			}(roleID)
+		}
+		wg.Wait()
+		if roles := R.SubjectRoles("alice"); len(roles) != 1 {
+			t.Fatalf("concurrent assignments should not violate static SoD, got %v", roles)
+		}
+	}
+}
+
+func TestSoDParentSubjects(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	R.RegisterRole("payments-initiator", "Payment initiator role")
+	approverRole, _ := R.RegisterRole("payments-approver", "Payment approver role")
+	clerkRole, _ := R.RegisterRole("clerk", "Clerk role")
+	juniorRole, _ := R.RegisterRole("junior", "Junior clerk role")
+	juniorRole.AddParent(clerkRole)
+	R.AddStaticSoD("payments", "payments-initiator", "payments-approver")
+	R.AssignRole("alice", "payments-initiator")
+	R.AssignRole("alice", "junior")
+	R.AssignRole("bob", "clerk")
+
+	if err := clerkRole.AddParent(approverRole); err == nil {
+		t.Fatalf("alice should not inherit payments-approver through clerk")
+	}
+	if clerkRole.HasAncestor("payments-approver") {
+		t.Fatalf("rejected parent should not be added")
+	}
+	R.UnassignRole("alice", "junior")
+	if err := clerkRole.AddParent(approverRole); err != nil {
+		t.Fatalf("clerk should inherit payments-approver when no subject violates SoD, err: %v", err)
+	}
+
+	for i := 0; i < 200; i++ {
+		R := New(nil) //NewConsoleLogger()
+		R.RegisterRole("payments-initiator", "Payment initiator role")
+		R.RegisterRole("payments-approver", "Payment approver role")
+		R.RegisterRole("clerk", "Clerk role")
+		R.AddStaticSoD("payments", "payments-initiator", "payments-approver")
+		R.AssignRole("alice", "clerk")
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			R.GetRole("clerk").AddParent(R.GetRole("payments-approver"))
+		}()
+		go func() {
+			defer wg.Done()
+			R.AssignRole("alice", "payments-initiator")
+		}()
+		wg.Wait()
+		if R.GetRole("clerk").HasAncestor("payments-approver") && len(R.SubjectRoles("alice")) == 2 {
+			t.Fatalf("concurrent parent addition and assignment should not violate static SoD")
+		}
+	}
+}
@@ -0,0 +1,523 @@
+package rbac
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationIssue is a problem found in a policy document
+type ValidationIssue struct {
+	// Path is JSON path of the invalid item, like $.roles[0].grants.users[1]
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// String returns issue as "path: message"
+func (i *ValidationIssue) String() string {
+	return fmt.Sprintf("%s: %s", i.Path, i.Message)
+}
+
+// ValidationError is returned by LoadJSONStrict with all problems found in a policy document
+type ValidationError struct {
+	Issues []*ValidationIssue
+}
+
+// Error returns all issues in a line
+func (e *ValidationError) Error() string {
+	issues := make([]string, len(e.Issues))
+	for i, issue := range e.Issues {
+		issues[i] = issue.String()
+	}
+	return fmt.Sprintf("policy is invalid: %s", strings.Join(issues, "; "))
+}
+
+// jsStrictRBAC is a policy document with actions of declared permissions
+type jsStrictRBAC struct {
+	jsRBAC
+	Permissions []*jsPermission `json:"permissions"`
+}
+
+// LoadJSONStrict loads all data from a reader like LoadJSON, but the whole document is validated first and the
+// instance is left untouched if it is invalid. Declared permissions should be registered with the same actions,
+// roles should not be registered already, referenced permissions, actions, conditions and roles should exist,
+// parents and implications should not make a cycle, schedules and cardinality limits should be valid, and roles
+// and subjects should not violate separation of duty constraints. All problems are returned in a
+// *ValidationError with their JSON paths.
+func (r *RBAC) LoadJSONStrict(reader io.Reader) error {
+	b, err := ioutil.ReadAll(reader)
+	if err != nil {
+		log.Errorf("can not read policy, err: %v", err)
+		return err
+	}
+	s := jsStrictRBAC{}
+	if err = json.Unmarshal(b, &s); err != nil {
+		log.Errorf("can not parse policy, err: %v", err)
+		return &ValidationError{Issues: []*ValidationIssue{{Path: "$", Message: err.Error()}}}
+	}
+
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+	v := &validator{rbac: r}
+	v.validate(&s)
+	if len(v.issues) == 0 {
+		// problems the validator misses are caught by loading to a staged copy
+		if err = r.stage().loadJS(withTxHeld(context.Background()), &s.jsRBAC); err != nil {
+			v.add("$", "%v", err)
+		}
+	}
+	if len(v.issues) > 0 {
+		err = &ValidationError{Issues: v.issues}
+		log.Errorf("can not load policy, err: %v", err)
+		return err
+	}
+	return r.loadJS(withTxHeld(context.Background()), &s.jsRBAC)
+}
+
+// validator collects problems of a policy document
+type validator struct {
+	rbac       *RBAC
+	issues     []*ValidationIssue
+	composites map[Action][]Action       // composite actions declared in document
+	roles      map[roleKey]string        // path of roles declared in document
+	grants     map[roleKey]*RoleGrants   // roles declared in document
+	sod        []*SoDConstraint          // registered and valid constraints declared in document
+	sodPaths   map[*SoDConstraint]string // path of constraints declared in document
+}
+
+func (v *validator) add(path, format string, args ...interface{}) {
+	v.issues = append(v.issues, &ValidationIssue{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validate(s *jsStrictRBAC) {
+	r := v.rbac
+	declared := map[string]bool{}
+	for i, p := range s.Permissions {
+		path := fmt.Sprintf("$.permissions[%d]", i)
+		perm := r.GetPermission(p.ID)
+		switch {
+		case p.ID == "":
+			v.add(path, "permission ID is empty")
+		case declared[p.ID]:
+			v.add(path, "permission %s is declared more than once", p.ID)
+		case perm == nil || p.ID == AnyPermission:
+			v.add(path, "permission %s is not registered", p.ID)
+		default:
+			added, removed := diffActions(perm.Actions(), expandBuiltinComposites(p.Actions))
+			if len(added) > 0 {
+				v.add(path+".actions", "actions %v are not registered for permission %s", added, p.ID)
+			}
+			if len(removed) > 0 {
+				v.add(path+".actions", "registered actions %v of permission %s are not declared", removed, p.ID)
+			}
+		}
+		declared[p.ID] = true
+	}
+
+	v.composites = map[Action][]Action{}
+	for i, c := range s.Composites {
+		if r.IsCompositeAction(c.Action) && !reflect.DeepEqual(r.expandComposites([]Action{c.Action}), r.expandComposites(c.Actions)) {
+			v.add(fmt.Sprintf("$.composites[%d]", i), "composite action %s is already registered with different actions", c.Action)
+		}
+		v.composites[c.Action] = c.Actions
+	}
+	implications := &implicationGraph{}
+	r.implications.copyTo(implications)
+	for i, impl := range s.Implications {
+		if impl.Permission != "" && r.GetPermission(impl.Permission) == nil {
+			v.add(fmt.Sprintf("$.implications[%d].permission", i), "permission %s is not registered", impl.Permission)
+		}
+		// implications are added in document order like loading does, so the first one closing a cycle is reported
+		for j, implied := range impl.Implies {
+			if err := implications.validate(impl.Permission, impl.Action, implied); err != nil {
+				v.add(fmt.Sprintf("$.implications[%d].implies[%d]", i, j), "%v", err)
+				continue
+			}
+			implications.add(impl.Permission, impl.Action, implied)
+		}
+	}
+	v.declareSoD(s.SoD)
+
+	v.roles = map[roleKey]string{}
+	v.grants = map[roleKey]*RoleGrants{}
+	v.declareRoles("", "$.roles", s.Roles)
+	for i, d := range s.Domains {
+		v.declareRoles(d.ID, fmt.Sprintf("$.domains[%d].roles", i), d.Roles)
+	}
+	v.validateRoles("", "$.roles", s.Roles)
+	for i, d := range s.Domains {
+		v.validateRoles(d.ID, fmt.Sprintf("$.domains[%d].roles", i), d.Roles)
+	}
+	v.validateCycles(s)
+	v.validateRoleSoD(s)
+
+	assigned := map[string][]string{}
+	for i, subject := range s.Subjects {
+		path := fmt.Sprintf("$.subjects[%d]", i)
+		if subject.ID == "" {
+			v.add(path+".id", "subject ID is empty")
+		}
+		if _, ok := assigned[subject.ID]; !ok {
+			assigned[subject.ID] = r.SubjectRoles(subject.ID)
+		}
+		for j, roleID := range subject.Roles {
+			if !v.roleExists(roleKey{"", roleID}) {
+				v.add(fmt.Sprintf("%s.roles[%d]", path, j), "role %s is not found", roleID)
+				continue
+			}
+			if !hasString(assigned[subject.ID], roleID) {
+				assigned[subject.ID] = append(assigned[subject.ID], roleID)
+			}
+		}
+		for _, c := range v.sod {
+			if c.Dynamic {
+				continue
+			}
+			if a, b, ok := c.conflict(v.effectiveRoleIDs(assigned[subject.ID])); ok {
+				v.add(path, "subject %s violates separation of duty constraint %s, it holds %s and %s", subject.ID, c.ID, a, b)
+				break
+			}
+		}
+	}
+	v.validateHolders(s.Roles, assigned)
+}
+
+// declareSoD records valid constraints declared in document with registered ones, invalid constraints and
+// constraints violated by registered roles and subjects are reported
+func (v *validator) declareSoD(constraints []*SoDConstraint) {
+	r := v.rbac
+	v.sodPaths = map[*SoDConstraint]string{}
+	declared := map[string]bool{}
+	r.sod.Range(func(_, c interface{}) bool {
+		v.sod = append(v.sod, c.(*SoDConstraint))
+		return true
+	})
+	sort.Slice(v.sod, func(i, j int) bool { return v.sod[i].ID < v.sod[j].ID })
+	for i, c := range constraints {
+		path := fmt.Sprintf("$.sod[%d]", i)
+		roles := []string{}
+		for _, roleID := range c.Roles {
+			if !hasString(roles, roleID) {
+				roles = append(roles, roleID)
+			}
+		}
+		_, registered := r.sod.Load(c.ID)
+		switch {
+		case c.ID == "":
+			v.add(path+".id", "separation of duty constraint ID is empty")
+			continue
+		case declared[c.ID]:
+			v.add(path+".id", "separation of duty constraint %s is declared more than once", c.ID)
+			continue
+		case registered:
+			v.add(path+".id", "separation of duty constraint %s is already registered", c.ID)
+			continue
+		case len(roles) < 2:
+			v.add(path+".roles", "separation of duty constraint %s should have at least two roles", c.ID)
+			continue
+		}
+		declared[c.ID] = true
+		sort.Strings(roles)
+		c = &SoDConstraint{ID: c.ID, Roles: roles, Dynamic: c.Dynamic}
+		v.sod = append(v.sod, c)
+		v.sodPaths[c] = path
+		for _, role := range append(r.Roles(), r.domainRoles()...) {
+			if a, b, ok := c.conflict(roleIDSet(role)); ok {
+				v.add(path, "role %s violates separation of duty constraint %s, it inherits %s and %s", role.ID, c.ID, a, b)
+			}
+		}
+		if c.Dynamic {
+			continue
+		}
+		for _, s := range r.SubjectAssignments() {
+			if a, b, ok := c.conflict(r.effectiveRoleIDs("", s.Roles)); ok {
+				v.add(path, "subject %s violates separation of duty constraint %s, it holds %s and %s", s.ID, c.ID, a, b)
+			}
+		}
+	}
+}
+
+// validateRoleSoD reports roles declared in document which inherit more than one role of a constraint
+func (v *validator) validateRoleSoD(s *jsStrictRBAC) {
+	check := func(domain, path string, roles []*RoleGrants) {
+		for i, rg := range roles {
+			k := roleKey{domain, rg.ID}
+			if v.roles[k] != fmt.Sprintf("%s[%d]", path, i) {
+				continue
+			}
+			ids := v.ancestorIDs(k)
+			for _, c := range v.sod {
+				if a, b, ok := c.conflict(ids); ok {
+					v.add(v.roles[k]+".parents", "role %s violates separation of duty constraint %s, it inherits %s and %s", rg.ID, c.ID, a, b)
+					break
+				}
+			}
+		}
+	}
+	check("", "$.roles", s.Roles)
+	for i, d := range s.Domains {
+		check(d.ID, fmt.Sprintf("$.domains[%d].roles", i), d.Roles)
+	}
+}
+
+// ancestorIDs returns set of IDs of role and its ancestors, parents of roles declared in document are resolved
+// like loading does
+func (v *validator) ancestorIDs(k roleKey) map[string]bool {
+	res := map[string]bool{}
+	seen := map[roleKey]bool{}
+	queue := []roleKey{k}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		if seen[cur] {
+			continue
+		}
+		seen[cur] = true
+		res[cur.id] = true
+		if rg := v.grants[cur]; rg != nil {
+			for _, parentID := range rg.Parents {
+				if pk, ok := v.resolveRole(cur.domain, parentID); ok {
+					queue = append(queue, pk)
+				}
+			}
+		} else if role := v.rbac.roleIn(cur.domain, cur.id); role != nil {
+			for _, a := range role.ancestors() {
+				res[a.ID] = true
+			}
+		}
+	}
+	return res
+}
+
+// effectiveRoleIDs returns set of global roleIDs and their ancestors, like RBAC.effectiveRoleIDs
+func (v *validator) effectiveRoleIDs(roleIDs []string) map[string]bool {
+	res := map[string]bool{}
+	for _, roleID := range roleIDs {
+		for id := range v.ancestorIDs(roleKey{"", roleID}) {
+			res[id] = true
+		}
+	}
+	return res
+}
+
+// validateHolders reports global roles declared in document which are assigned to more subjects than their
+// maximum cardinality
+func (v *validator) validateHolders(roles []*RoleGrants, assigned map[string][]string) {
+	for i, rg := range roles {
+		c := rg.Cardinality
+		if c == nil || !c.valid() || c.Max == 0 || v.roles[roleKey{"", rg.ID}] != fmt.Sprintf("$.roles[%d]", i) {
+			continue
+		}
+		holders := 0
+		for _, roleIDs := range assigned {
+			if hasString(roleIDs, rg.ID) {
+				holders++
+			}
+		}
+		if holders > c.Max {
+			v.add(fmt.Sprintf("$.roles[%d].cardinality", i), "role %s has %d holders more than maximum %d", rg.ID, holders, c.Max)
+		}
+	}
+}
+
+// declareRoles records roles of a domain declared in document, duplicate and registered roles are reported
+func (v *validator) declareRoles(domain, path string, roles []*RoleGrants) {
+	for i, rg := range roles {
+		rolePath := fmt.Sprintf("%s[%d]", path, i)
+		k := roleKey{domain, rg.ID}
+		switch {
+		case rg.ID == "":
+			v.add(rolePath+".id", "role ID is empty")
+		case v.roles[k] != "":
+			v.add(rolePath+".id", "role %s is already declared at %s", rg.ID, v.roles[k])
+		case v.rbac.roleIn(domain, rg.ID) != nil:
+			v.add(rolePath+".id", "role %s is already registered", rg.ID)
+		default:
+			v.roles[k] = rolePath
+			v.grants[k] = rg
+		}
+	}
+}
+
+// roleExists checks if role is declared in document or registered
+func (v *validator) roleExists(k roleKey) bool {
+	return v.roles[k] != "" || v.rbac.roleIn(k.domain, k.id) != nil
+}
+
+// resolveRole returns key of role in domain if it exists, key of global role otherwise, like RBAC.resolveRole
+func (v *validator) resolveRole(domain, roleID string) (roleKey, bool) {
+	if k := (roleKey{domain, roleID}); v.roleExists(k) {
+		return k, true
+	}
+	if k := (roleKey{"", roleID}); domain != "" && v.roleExists(k) {
+		return k, true
+	}
+	return roleKey{}, false
+}
+
+func (v *validator) validateRoles(domain, path string, roles []*RoleGrants) {
+	for i, rg := range roles {
+		rolePath := fmt.Sprintf("%s[%d]", path, i)
+		if c := rg.Cardinality; c != nil && !c.valid() {
+			v.add(rolePath+".cardinality", "invalid cardinality min:%d max:%d for role %s", c.Min, c.Max, rg.ID)
+		}
+		v.validateGrants(rolePath+".grants", rg.Grants)
+		v.validateGrants(rolePath+".denies", rg.Denies)
+		for j, cg := range rg.Conditional {
+			cgPath := fmt.Sprintf("%s.conditional[%d]", rolePath, j)
+			if !v.rbac.IsConditionExist(cg.Condition) {
+				v.add(cgPath+".condition", "condition %s is not registered", cg.Condition)
+			}
+			v.validateActions(cgPath+".permission", cgPath+".actions", cg.Permission, cg.Actions)
+		}
+		permIDs := make([]string, 0, len(rg.Instances))
+		for permID := range rg.Instances {
+			permIDs = append(permIDs, permID)
+		}
+		sort.Strings(permIDs)
+		for _, permID := range permIDs {
+			permPath := jsonPath(rolePath+".instances", permID)
+			if v.rbac.GetPermission(permID) == nil {
+				v.add(permPath, "permission %s is not registered", permID)
+				continue
+			}
+			instanceIDs := make([]string, 0, len(rg.Instances[permID]))
+			for instanceID := range rg.Instances[permID] {
+				instanceIDs = append(instanceIDs, instanceID)
+			}
+			sort.Strings(instanceIDs)
+			for _, instanceID := range instanceIDs {
+				v.validateActions(permPath, jsonPath(permPath, instanceID), permID, rg.Instances[permID][instanceID])
+			}
+		}
+		for j, sg := range rg.Scheduled {
+			sgPath := fmt.Sprintf("%s.scheduled[%d]", rolePath, j)
+			if sg.Schedule == nil {
+				v.add(sgPath+".schedule", "schedule is empty")
+			} else if err := sg.Schedule.validate(); err != nil {
+				v.add(sgPath+".schedule", "%v", err)
+			}
+			v.validateActions(sgPath+".permission", sgPath+".actions", sg.Permission, sg.Actions)
+		}
+		for j, parentID := range rg.Parents {
+			if _, ok := v.resolveRole(domain, parentID); !ok {
+				v.add(fmt.Sprintf("%s.parents[%d]", rolePath, j), "parent role %s of role %s is not found", parentID, rg.ID)
+			}
+		}
+	}
+}
+
+func (v *validator) validateGrants(path string, grants grantsMap) {
+	keys := make([]string, 0, len(grants))
+	for permID := range grants {
+		keys = append(keys, permID)
+	}
+	sort.Strings(keys)
+	for _, permID := range keys {
+		permPath := jsonPath(path, permID)
+		v.validateActions(permPath, permPath, permID, grants[permID])
+	}
+}
+
+// validateActions checks that permission is registered at permPath, and actions at actionsPath are registered
+// for it, composite actions declared in document are expanded
+func (v *validator) validateActions(permPath, actionsPath, permID string, actions []Action) {
+	if v.rbac.GetPermission(permID) == nil {
+		v.add(permPath, "permission %s is not registered", permID)
+		return
+	}
+	for i, a := range actions {
+		acts := []Action{a}
+		if declared, ok := v.composites[a]; ok {
+			acts = declared
+		}
+		for _, act := range v.rbac.expandComposites(acts) {
+			if !v.rbac.IsPermissionExist(permID, act) {
+				v.add(fmt.Sprintf("%s[%d]", actionsPath, i), "action %s is not registered for permission %s", act, permID)
+				break
+			}
+		}
+	}
+}
+
+// validateCycles reports parents of roles declared in document which make a cycle. Registered roles can not have
+// a declared role as ancestor, so only declared roles are visited.
+func (v *validator) validateCycles(s *jsStrictRBAC) {
+	type parentRef struct {
+		key  roleKey
+		path string
+	}
+	parents := map[roleKey][]parentRef{}
+	keys := []roleKey{}
+	collect := func(domain, path string, roles []*RoleGrants) {
+		for i, rg := range roles {
+			k := roleKey{domain, rg.ID}
+			if v.roles[k] != fmt.Sprintf("%s[%d]", path, i) {
+				continue
+			}
+			keys = append(keys, k)
+			for j, parentID := range rg.Parents {
+				if pk, ok := v.resolveRole(domain, parentID); ok && v.roles[pk] != "" {
+					parents[k] = append(parents[k], parentRef{pk, fmt.Sprintf("%s[%d].parents[%d]", path, i, j)})
+				}
+			}
+		}
+	}
+	collect("", "$.roles", s.Roles)
+	for i, d := range s.Domains {
+		collect(d.ID, fmt.Sprintf("$.domains[%d].roles", i), d.Roles)
+	}
+
+	// depth first search, a parent which is on the current chain closes a cycle
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[roleKey]int{}
+	chain := []roleKey{}
+	var visit func(k roleKey)
+	visit = func(k roleKey) {
+		state[k] = visiting
+		chain = append(chain, k)
+		for _, p := range parents[k] {
+			switch state[p.key] {
+			case visiting:
+				ids := []string{}
+				for i := len(chain) - 1; i >= 0; i-- {
+					ids = append([]string{chain[i].id}, ids...)
+					if chain[i] == p.key {
+						break
+					}
+				}
+				ids = append(ids, p.key.id)
+				v.add(p.path, "parent role %s of role %s makes a cycle %s", p.key.id, k.id, strings.Join(ids, " -> "))
+			case 0:
+				visit(p.key)
+			}
+		}
+		chain = chain[:len(chain)-1]
+		state[k] = visited
+	}
+	for _, k := range keys {
+		if state[k] == 0 {
+			visit(k)
+		}
+	}
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// jsonPath returns path of key in object at path, keys which are not identifiers are quoted in brackets
+func jsonPath(path, key string) string {
+	if identifier.MatchString(key) {
+		return path + "." + key
+	}
+	return fmt.Sprintf("%s[%s]", path, strconv.Quote(key))
+}
@@ -0,0 +1,162 @@
+package rbac
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestLoadJSONStrict(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	userRole, _ := R.RegisterRole("user", "User role")
+	adminRole, _ := R.RegisterRole("admin", "Admin role")
+	R.Permit(userRole.ID, usersPerm, Read)
+	R.Permit(adminRole.ID, usersPerm, Delete)
+	adminRole.AddParent(userRole)
+	managerRole, _ := R.RegisterRoleIn("acme", "manager", "Acme manager")
+	managerRole.AddParent(adminRole)
+	R.AssignRole("alice", "admin")
+
+	var buf bytes.Buffer
+	if err := R.SaveJSON(&buf); err != nil {
+		t.Fatalf("can not save, err: %v", err)
+	}
+	R2 := New(nil)
+	R2.RegisterPermission("users", "User resource", CRUD)
+	if err := R2.LoadJSONStrict(&buf); err != nil {
+		t.Fatalf("valid policy should be loaded, err: %v", err)
+	}
+	if !R2.IsGrantInheritedStr("admin", "users", Read) || !R2.IsSubjectGrantedStr("alice", "users", Delete) {
+		t.Fatalf("admin should be granted users read and delete after strict load")
+	}
+	if !R2.IsGrantInheritedIn("acme", "manager", usersPerm, Delete) {
+		t.Fatalf("acme manager should inherit users delete from admin")
+	}
+}
+
+func TestLoadJSONStrictInvalid(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterPermission("logs", "Log resource", Read)
+	R.RegisterRole("guest", "Guest role")
+	revision := R.Revision()
+
+	doc := `{
+  "permissions": [
+    {"id": "users", "description": "User resource", "actions": ["create", "read", "update", "delete", "approve"]},
+    {"id": "reports", "description": "Report resource", "actions": ["read"]}
+  ],
+  "roles": [
+    {"id": "user", "grants": {"users": ["read", "approve"], "reports": ["read"]}, "parents": ["admin"]},
+    {"id": "admin", "grants": {"users": ["delete"]}, "parents": ["user", "root"]},
+    {"id": "guest", "grants": {"logs": ["read"]}, "parents": []}
+  ],
+  "domains": [
+    {"id": "acme", "roles": [{"id": "manager", "grants": {}, "parents": ["admin", "owner"]}]}
+  ],
+  "subjects": [{"id": "alice", "roles": ["admin", "manager"]}]
+}`
+	err := R.LoadJSONStrict(strings.NewReader(doc))
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("invalid policy should return a ValidationError, got %v", err)
+	}
+	expected := []string{
+		"$.permissions[0].actions: actions [approve] are not registered for permission users",
+		"$.permissions[1]: permission reports is not registered",
+		"$.roles[2].id: role guest is already registered",
+		"$.roles[0].grants.reports: permission reports is not registered",
+		"$.roles[0].grants.users[1]: action approve is not registered for permission users",
+		"$.roles[1].parents[1]: parent role root of role admin is not found",
+		"$.domains[0].roles[0].parents[1]: parent role owner of role manager is not found",
+		"$.roles[1].parents[0]: parent role user of role admin makes a cycle user -> admin -> user",
+		"$.subjects[0].roles[1]: role manager is not found",
+	}
+	if len(verr.Issues) != len(expected) {
+		t.Fatalf("expected %d issues, got %v", len(expected), verr)
+	}
+	for i, issue := range verr.Issues {
+		if issue.String() != expected[i] {
+			t.Fatalf("expected issue %d to be %q, got %q", i, expected[i], issue.String())
+		}
+	}
+	if R.Revision() != revision || R.GetRole("user") != nil || len(R.Domains()) != 0 {
+		t.Fatalf("instance should be left untouched on failure")
+	}
+
+	R.AddStaticSoD("exclusive", "user", "admin")
+	revision = R.Revision()
+	doc = `{"roles": [{"id": "user", "grants": {}, "parents": []}, {"id": "admin", "grants": {}, "parents": []}],
+  "subjects": [{"id": "alice", "roles": ["user", "admin"]}]}`
+	if verr, ok = R.LoadJSONStrict(strings.NewReader(doc)).(*ValidationError); !ok || verr.Issues[0].Path != "$.subjects[0]" {
+		t.Fatalf("policy violating separation of duty should not be loaded, got %v", verr)
+	}
+	if R.Revision() != revision || R.GetRole("user") != nil {
+		t.Fatalf("instance should be left untouched on failure")
+	}
+
+	if _, ok := R.LoadJSONStrict(strings.NewReader("{")).(*ValidationError); !ok {
+		t.Fatalf("malformed policy should return a ValidationError")
+	}
+}
+
+func TestLoadJSONCycle(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	doc := `{"roles": [{"id": "user", "grants": {}, "parents": ["admin"]}, {"id": "admin", "grants": {}, "parents": ["user"]}]}`
+	if err := R.LoadJSON(strings.NewReader(doc)); err == nil {
+		t.Fatalf("cycle of parents should fail loading")
+	}
+}
+
+func TestLoadJSONStrictConstraints(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterRole("auditor", "Auditor role")
+	R.AssignRole("bob", "auditor")
+	R.AddImplication(Update, Read)
+	revision := R.Revision()
+
+	doc := `{
+  "implications": [{"action": "read", "implies": ["list"]}, {"action": "list", "implies": ["update"]}],
+  "sod": [
+    {"id": "payments", "roles": ["initiator", "approver"]},
+    {"id": "single", "roles": ["initiator", "initiator"]},
+    {"id": "audit", "roles": ["auditor", "approver"], "dynamic": true}
+  ],
+  "roles": [
+    {"id": "initiator", "grants": {}, "parents": [], "cardinality": {"max": 1}},
+    {"id": "approver", "grants": {}, "parents": [], "cardinality": {"min": 2, "max": 1}},
+    {"id": "clerk", "grants": {}, "parents": ["initiator", "approver"]},
+    {"id": "oncall", "grants": {}, "parents": [], "scheduled": [
+      {"permission": "users", "actions": ["read"], "schedule": {"windows": [{"start": "25:00", "end": "09:00"}]}}
+    ]}
+  ],
+  "subjects": [{"id": "alice", "roles": ["initiator", "approver"]}, {"id": "carol", "roles": ["initiator"]}]
+}`
+	err := R.LoadJSONStrict(strings.NewReader(doc))
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("invalid policy should return a ValidationError, got %v", err)
+	}
+	expected := []string{
+		"$.implications[1].implies[0]: circular implication is found for action update while adding list -> update",
+		"$.sod[1].roles: separation of duty constraint single should have at least two roles",
+		"$.roles[1].cardinality: invalid cardinality min:2 max:1 for role approver",
+		`$.roles[3].scheduled[0].schedule: invalid window start 25:00, err: parsing time "25:00": hour out of range`,
+		"$.roles[2].parents: role clerk violates separation of duty constraint payments, it inherits approver and initiator",
+		"$.subjects[0]: subject alice violates separation of duty constraint payments, it holds approver and initiator",
+		"$.roles[0].cardinality: role initiator has 2 holders more than maximum 1",
+	}
+	if len(verr.Issues) != len(expected) {
+		t.Fatalf("expected %d issues, got %v", len(expected), verr)
+	}
+	for i, issue := range verr.Issues {
+		if issue.String() != expected[i] {
+			t.Fatalf("expected issue %d to be %q, got %q", i, expected[i], issue.String())
+		}
+	}
+	if R.Revision() != revision || R.GetRole("initiator") != nil {
+		t.Fatalf("instance should be left untouched on failure")
+	}
+}
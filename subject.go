@@ -0,0 +1,168 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Subject is an entity(user, service account...) which roles are assigned to
+type Subject struct {
+	ID       string
+	sync.Map // key: roleID, value: nil
+}
+
+// SubjectAssignments is used during JSON Marshalling
+type SubjectAssignments struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
+}
+
+// RoleIDs returns sorted list of role IDs assigned to subject
+func (s *Subject) RoleIDs() []string {
+	res := []string{}
+	s.Range(func(k, _ interface{}) bool {
+		res = append(res, k.(string))
+		return true
+	})
+	sort.Strings(res)
+	return res
+}
+
+// GetSubject returns the subject if exists, subject is nil if not found
+func (r *RBAC) GetSubject(subjectID string) *Subject {
+	s, ok := r.subjects.Load(subjectID)
+	if !ok {
+		return nil
+	}
+	return s.(*Subject)
+}
+
+// AssignRole assigns a registered role to a subject. Assignments violating a static separation of duty constraint
+// or maximum cardinality of the role are rejected.
+func (r *RBAC) AssignRole(subjectID, roleID string) error {
+	return r.AssignRoleContext(context.Background(), subjectID, roleID)
+}
+
+func (r *RBAC) assignRole(ctx context.Context, subjectID, roleID string) error {
+	if !r.IsRoleExist(roleID) {
+		log.Errorf("role %s is not registered", roleID)
+		return fmt.Errorf("role %s is not registered", roleID)
+	}
+	r.subjectsMu.Lock()
+	defer r.subjectsMu.Unlock()
+	if err := r.checkSoD(r.effectiveRoleIDs("", append(r.SubjectRoles(subjectID), roleID)), false); err != nil {
+		log.Errorf("can not assign role %s to subject %s, err: %v", roleID, subjectID, err)
+		return err
+	}
+	if err := r.checkAssign(roleID); err != nil {
+		log.Errorf("can not assign role %s to subject %s, err: %v", roleID, subjectID, err)
+		return err
+	}
+	s, _ := r.subjects.LoadOrStore(subjectID, &Subject{ID: subjectID})
+	if _, loaded := s.(*Subject).LoadOrStore(roleID, nil); loaded {
+		log.Errorf("role %s is already assigned to subject %s", roleID, subjectID)
+		return fmt.Errorf("role %s is already assigned to subject %s", roleID, subjectID)
+	}
+	r.emit(ctx, Event{Type: EventRoleAssigned, RoleID: roleID, Subject: subjectID})
+	return nil
+}
+
+// UnassignRole removes a role assignment from a subject, it is rejected if role would have less holders than its
+// minimum cardinality
+func (r *RBAC) UnassignRole(subjectID, roleID string) error {
+	return r.UnassignRoleContext(context.Background(), subjectID, roleID)
+}
+
+// unassignRoleChecked removes a role assignment from a subject if minimum cardinality of the role allows
+func (r *RBAC) unassignRoleChecked(ctx context.Context, subjectID, roleID string) error {
+	r.subjectsMu.Lock()
+	defer r.subjectsMu.Unlock()
+	if err := r.checkUnassign(roleID); err != nil && r.isAssigned(subjectID, roleID) {
+		log.Errorf("can not unassign role %s from subject %s, err: %v", roleID, subjectID, err)
+		return err
+	}
+	if err := r.unassignRole(subjectID, roleID); err != nil {
+		return err
+	}
+	r.emit(ctx, Event{Type: EventRoleUnassigned, RoleID: roleID, Subject: subjectID})
+	return nil
+}
+
+func (r *RBAC) unassignRole(subjectID, roleID string) error {
+	s := r.GetSubject(subjectID)
+	if s == nil {
+		log.Errorf("subject %s has no roles assigned", subjectID)
+		return fmt.Errorf("subject %s has no roles assigned", subjectID)
+	}
+	if _, ok := s.Load(roleID); !ok {
+		log.Errorf("role %s is not assigned to subject %s", roleID, subjectID)
+		return fmt.Errorf("role %s is not assigned to subject %s", roleID, subjectID)
+	}
+	s.Delete(roleID)
+	if len(s.RoleIDs()) == 0 {
+		r.subjects.Delete(subjectID)
+	}
+	return nil
+}
+
+// isAssigned checks if role is assigned to subject
+func (r *RBAC) isAssigned(subjectID, roleID string) bool {
+	if s := r.GetSubject(subjectID); s != nil {
+		_, ok := s.Load(roleID)
+		return ok
+	}
+	return false
+}
+
+// SubjectRoles returns sorted list of role IDs assigned to a subject
+func (r *RBAC) SubjectRoles(subjectID string) []string {
+	s := r.GetSubject(subjectID)
+	if s == nil {
+		return []string{}
+	}
+	return s.RoleIDs()
+}
+
+// IsSubjectGranted checks if any role of subject has the permission(including inherited permissions from parents)
+func (r *RBAC) IsSubjectGranted(subjectID string, perm *Permission, actions ...Action) bool {
+	if perm == nil {
+		log.Errorf("Nil perm is sent for granted check for subject %s", subjectID)
+		return false
+	}
+	return r.IsSubjectGrantedStr(subjectID, perm.ID, actions...)
+}
+
+// IsSubjectGrantedStr checks if any role of subject has permID with target actions(including inherited permissions from parents)
+func (r *RBAC) IsSubjectGrantedStr(subjectID string, permID string, actions ...Action) bool {
+	roleIDs := r.SubjectRoles(subjectID)
+	return r.decide(func() bool { return r.anyGrantInherited("", roleIDs, permID, actions...) }, func() *Decision {
+		return &Decision{Check: "IsSubjectGranted", Subject: subjectID, Roles: roleIDs, Permission: permID, Actions: actions}
+	})
+}
+
+// SubjectAssignments returns role assignments of all subjects
+func (r *RBAC) SubjectAssignments() []*SubjectAssignments {
+	res := []*SubjectAssignments{}
+	r.subjects.Range(func(_, v interface{}) bool {
+		res = append(res, &SubjectAssignments{
+			ID:    v.(*Subject).ID,
+			Roles: v.(*Subject).RoleIDs(),
+		})
+		return true
+	})
+	return res
+}
+
+// unassignRoleFromAll removes role assignment from all subjects
+func (r *RBAC) unassignRoleFromAll(roleID string) {
+	r.subjectsMu.Lock()
+	defer r.subjectsMu.Unlock()
+	r.subjects.Range(func(k, v interface{}) bool {
+		if _, ok := v.(*Subject).Load(roleID); ok {
+			r.unassignRole(k.(string), roleID)
+		}
+		return true
+	})
+}
@@ -0,0 +1,119 @@
+package rbac
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestSubject(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	postsPerm, err := R.RegisterPermission("posts", "Post resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register posts permission, err: %v", err)
+	}
+	viewerRole, err := R.RegisterRole("viewer", "Viewer role")
+	if err != nil {
+		t.Fatalf("can not add viewer role, err: %v", err)
+	}
+	if err = R.Permit(viewerRole.ID, usersPerm, Read); err != nil {
+		t.Fatalf("can not permit Read action to role %s", viewerRole.ID)
+	}
+	adminRole, err := R.RegisterRole("admin", "Admin role")
+	if err != nil {
+		t.Fatalf("can not add admin role, err: %v", err)
+	}
+	if err = R.Permit(adminRole.ID, postsPerm, Update); err != nil {
+		t.Fatalf("can not permit Update action to role %s", adminRole.ID)
+	}
+	if err = adminRole.AddParent(viewerRole); err != nil {
+		t.Fatalf("adding parent role failed with: %v", err)
+	}
+
+	if err = R.AssignRole("alice", "fake_role"); err == nil {
+		t.Fatalf("AssignRole should fail with nonexisting role")
+	}
+	if err = R.AssignRole("alice", adminRole.ID); err != nil {
+		t.Fatalf("can not assign admin role to alice, err: %v", err)
+	}
+	if err = R.AssignRole("alice", adminRole.ID); err == nil {
+		t.Fatalf("AssignRole should fail when role is already assigned")
+	}
+	if err = R.AssignRole("bob", viewerRole.ID); err != nil {
+		t.Fatalf("can not assign viewer role to bob, err: %v", err)
+	}
+	if err = R.AssignRole("bob", adminRole.ID); err != nil {
+		t.Fatalf("can not assign admin role to bob, err: %v", err)
+	}
+	if roles := R.SubjectRoles("bob"); !reflect.DeepEqual(roles, []string{"admin", "viewer"}) {
+		t.Fatalf("bob should have admin and viewer roles, got %v", roles)
+	}
+	if roles := R.SubjectRoles("nobody"); len(roles) != 0 {
+		t.Fatalf("nobody should not have roles, got %v", roles)
+	}
+
+	// Inherited grants
+	if !R.IsSubjectGranted("alice", usersPerm, Read) {
+		t.Fatalf("alice should have users.read inherited from viewer role")
+	}
+	if !R.IsSubjectGranted("alice", postsPerm, Update) {
+		t.Fatalf("alice should have posts.update")
+	}
+	if R.IsSubjectGranted("alice", usersPerm, Delete) {
+		t.Fatalf("alice should not have users.delete")
+	}
+	if R.IsSubjectGranted("alice", nil, Read) {
+		t.Fatalf("IsSubjectGranted should fail with nil permission")
+	}
+	if R.IsSubjectGrantedStr("nobody", usersPerm.ID, Read) {
+		t.Fatalf("nobody should not have users.read")
+	}
+
+	// Unassign
+	if err = R.UnassignRole("bob", adminRole.ID); err != nil {
+		t.Fatalf("can not unassign admin role from bob, err: %v", err)
+	}
+	if err = R.UnassignRole("bob", adminRole.ID); err == nil {
+		t.Fatalf("UnassignRole should fail when role is not assigned")
+	}
+	if err = R.UnassignRole("nobody", adminRole.ID); err == nil {
+		t.Fatalf("UnassignRole should fail for unknown subject")
+	}
+	if R.IsSubjectGranted("bob", postsPerm, Update) {
+		t.Fatalf("bob should not have posts.update after unassigning admin role")
+	}
+
+	// Persisting
+	var buf bytes.Buffer
+	if err = R.SaveJSON(&buf); err != nil {
+		t.Fatalf("unable to save to json, err:%v", err)
+	}
+	R2 := R.Clone(false)
+	if err = R2.LoadJSON(&buf); err != nil {
+		t.Fatalf("unable to load from json, err:%v", err)
+	}
+	if roles := R2.SubjectRoles("alice"); !reflect.DeepEqual(roles, []string{"admin"}) {
+		t.Fatalf("loaded alice should have admin role, got %v", roles)
+	}
+	if !R2.IsSubjectGranted("alice", usersPerm, Read) {
+		t.Fatalf("loaded alice should have users.read inherited from viewer role")
+	}
+	if len(R2.SubjectAssignments()) != 2 {
+		t.Fatalf("should have 2 subjects loaded, got %d", len(R2.SubjectAssignments()))
+	}
+
+	// Removing role removes assignments
+	if err = R2.RemoveRole(viewerRole.ID); err != nil {
+		t.Fatalf("removing role failed with: %v", err)
+	}
+	if R2.GetSubject("bob") != nil {
+		t.Fatalf("bob should be removed as it has no roles left")
+	}
+	if R2.IsSubjectGranted("alice", usersPerm, Read) {
+		t.Fatalf("alice should not have users.read after viewer role is removed")
+	}
+}
@@ -0,0 +1,238 @@
+package rbac
+
+import (
+	"context"
+	"fmt"
+)
+
+// Tx stages mutations of a transaction, see RBAC.Tx. Each mutation is applied to a staged copy of the instance
+// when it is called, so it fails immediately like its RBAC counterpart and checks of Tx see staged state.
+type Tx struct {
+	rbac     *RBAC
+	staged   *RBAC
+	revision uint64 // revision of the instance staged copy is made at
+	ops      []txOp
+}
+
+// txOp applies a staged mutation to an instance
+type txOp func(r *RBAC, ctx context.Context) error
+
+// txHeldKey marks contexts of mutations made while txMu is already held by the caller
+type txHeldKey struct{}
+
+// withTxHeld returns a copy of ctx marking mutations made with it as made while txMu is held
+func withTxHeld(ctx context.Context) context.Context {
+	return context.WithValue(ctx, txHeldKey{}, true)
+}
+
+// lockMutation holds txMu for reading during a mutation, so mutations wait while a transaction is committed and a
+// commit can not fail after it is validated. Nothing is locked if ctx is marked by withTxHeld. It returns the
+// unlock function.
+func (r *RBAC) lockMutation(ctx context.Context) func() {
+	if ctx.Value(txHeldKey{}) != nil {
+		return func() {}
+	}
+	r.txMu.RLock()
+	return r.txMu.RUnlock
+}
+
+// Tx runs fn with a transaction. Mutations of the transaction are staged and validated together, cycles, unknown
+// actions, separation of duty and cardinality constraints are checked. If fn returns an error or validation fails,
+// nothing is changed, otherwise all mutations are committed at once and checks running concurrently never see a
+// partial state. Other mutations wait while a transaction is committed. Staging copies the instance, so cost of a
+// transaction is linear in size of the policy, and it is copied once more if the instance is changed before commit.
+func (r *RBAC) Tx(fn func(tx *Tx) error) error {
+	return r.TxContext(context.Background(), fn)
+}
+
+// TxContext is same as Tx, mutations are recorded to admin log with actor and reason carried by ctx
+func (r *RBAC) TxContext(ctx context.Context, fn func(tx *Tx) error) error {
+	revision := r.Revision()
+	tx := &Tx{rbac: r, staged: r.stage(), revision: revision}
+	if err := fn(tx); err != nil {
+		log.Errorf("transaction is rolled back, err: %v", err)
+		return err
+	}
+	if len(tx.ops) == 0 {
+		return nil
+	}
+
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+	if r.Revision() != tx.revision {
+		// instance is changed since it is staged, mutations are validated again on current state
+		tx.staged = r.stage()
+		for _, op := range tx.ops {
+			if err := op(tx.staged, context.Background()); err != nil {
+				log.Errorf("transaction is rolled back, err: %v", err)
+				return err
+			}
+		}
+	}
+	if err := tx.validate(); err != nil {
+		log.Errorf("transaction is rolled back, err: %v", err)
+		return err
+	}
+	// other mutations wait for txMu, so ops succeed on the instance as they did on the staged copy of it
+	ctx = withTxHeld(ctx)
+	for _, op := range tx.ops {
+		if err := op(r, ctx); err != nil {
+			log.Errorf("can not commit transaction, err: %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+// stage returns a copy of the instance, permissions, roles and subjects are copied deeply
+func (r *RBAC) stage() *RBAC {
+	staged := r.Clone(true)
+	for _, perm := range r.Permissions() {
+		staged.permissions.Store(perm.ID, newPermission(perm.ID, perm.Description, perm.Actions()...))
+	}
+	return staged
+}
+
+// validate checks constraints which can only be checked after all mutations are staged
+func (tx *Tx) validate() error {
+	existing := map[string]bool{}
+	for _, v := range tx.rbac.ValidateCardinality() {
+		existing[v.RoleID] = true
+	}
+	for _, v := range tx.staged.ValidateCardinality() {
+		if !existing[v.RoleID] {
+			return v
+		}
+	}
+	return nil
+}
+
+// apply applies op to staged copy and stages it if it succeeds
+func (tx *Tx) apply(op txOp) error {
+	if err := op(tx.staged, context.Background()); err != nil {
+		return err
+	}
+	tx.ops = append(tx.ops, op)
+	return nil
+}
+
+// txPerm returns permission of instance with ID of perm
+func txPerm(r *RBAC, perm *Permission) (*Permission, error) {
+	if perm == nil {
+		return nil, fmt.Errorf("permission can not be nil")
+	}
+	if p := r.GetPermission(perm.ID); p != nil {
+		return p, nil
+	}
+	return nil, fmt.Errorf("permission %s is not registered", perm.ID)
+}
+
+// RegisterPermission stages registering a permission. Returned permission is the staged one, it can be used in
+// mutations of the transaction and in checks by ID, GetPermission returns the committed one.
+func (tx *Tx) RegisterPermission(permissionID, description string, actions ...Action) (perm *Permission, err error) {
+	err = tx.apply(func(r *RBAC, ctx context.Context) (err error) {
+		perm, err = r.RegisterPermissionContext(ctx, permissionID, description, actions...)
+		return err
+	})
+	return perm, err
+}
+
+// RegisterRole stages registering a role
+func (tx *Tx) RegisterRole(roleID string, description string) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		_, err := r.RegisterRoleContext(ctx, roleID, description)
+		return err
+	})
+}
+
+// RemoveRole stages removing a role
+func (tx *Tx) RemoveRole(roleID string) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		return r.RemoveRoleContext(ctx, roleID)
+	})
+}
+
+// Permit stages permitting actions of a permission to a role
+func (tx *Tx) Permit(roleID string, perm *Permission, actions ...Action) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		p, err := txPerm(r, perm)
+		if err != nil {
+			return err
+		}
+		return r.PermitContext(ctx, roleID, p, actions...)
+	})
+}
+
+// Revoke stages revoking actions of a permission from a role
+func (tx *Tx) Revoke(roleID string, perm *Permission, actions ...Action) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		p, err := txPerm(r, perm)
+		if err != nil {
+			return err
+		}
+		return r.RevokeContext(ctx, roleID, p, actions...)
+	})
+}
+
+// Deny stages denying actions of a permission for a role
+func (tx *Tx) Deny(roleID string, perm *Permission, actions ...Action) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		p, err := txPerm(r, perm)
+		if err != nil {
+			return err
+		}
+		return r.DenyContext(ctx, roleID, p, actions...)
+	})
+}
+
+// Undeny stages removing denies of a permission from a role
+func (tx *Tx) Undeny(roleID string, perm *Permission, actions ...Action) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		p, err := txPerm(r, perm)
+		if err != nil {
+			return err
+		}
+		return r.UndenyContext(ctx, roleID, p, actions...)
+	})
+}
+
+// AddParent stages adding a parent role to a role
+func (tx *Tx) AddParent(roleID, parentID string) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		return r.AddParentContext(ctx, roleID, parentID)
+	})
+}
+
+// RemoveParent stages removing a parent role from a role
+func (tx *Tx) RemoveParent(roleID, parentID string) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		return r.RemoveParentContext(ctx, roleID, parentID)
+	})
+}
+
+// AssignRole stages assigning a role to a subject
+func (tx *Tx) AssignRole(subjectID, roleID string) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		return r.AssignRoleContext(ctx, subjectID, roleID)
+	})
+}
+
+// UnassignRole stages removing a role assignment from a subject
+func (tx *Tx) UnassignRole(subjectID, roleID string) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		return r.UnassignRoleContext(ctx, subjectID, roleID)
+	})
+}
+
+// SetRoleCardinality stages setting cardinality of a role
+func (tx *Tx) SetRoleCardinality(roleID string, min, max int) error {
+	return tx.apply(func(r *RBAC, ctx context.Context) error {
+		return r.SetRoleCardinalityContext(ctx, roleID, min, max)
+	})
+}
+
+// IsGrantInheritedStr checks if permID is granted with target actions for role(including inherited permissions
+// from parents) in staged state
+func (tx *Tx) IsGrantInheritedStr(roleID string, permID string, actions ...Action) bool {
+	return tx.staged.isGrantInherited("", roleID, permID, actions...)
+}
@@ -0,0 +1,201 @@
+package rbac
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTx(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterRole("user", "User role")
+
+	err := R.Tx(func(tx *Tx) error {
+		reportsPerm, err := tx.RegisterPermission("reports", "Report resource", Read)
+		if err != nil {
+			return err
+		}
+		if err = tx.RegisterRole("admin", "Admin role"); err != nil {
+			return err
+		}
+		if err = tx.Permit("admin", usersPerm, Update); err != nil {
+			return err
+		}
+		if err = tx.Permit("user", reportsPerm, Read); err != nil {
+			return err
+		}
+		if err = tx.AddParent("admin", "user"); err != nil {
+			return err
+		}
+		if !tx.IsGrantInheritedStr("admin", "reports", Read) {
+			t.Fatalf("staged grants should be visible in transaction")
+		}
+		if R.IsRoleExist("admin") {
+			t.Fatalf("staged role should not be visible before commit")
+		}
+		return tx.AssignRole("alice", "admin")
+	})
+	if err != nil {
+		t.Fatalf("transaction should be committed, err: %v", err)
+	}
+	if !R.IsGrantInheritedStr("admin", "reports", Read) || !R.IsGrantInheritedStr("admin", "users", Update) {
+		t.Fatalf("transaction mutations should be committed")
+	}
+	if !R.IsSubjectGrantedStr("alice", "users", Update) {
+		t.Fatalf("alice should be assigned admin role")
+	}
+
+	revision := R.Revision()
+	err = R.Tx(func(tx *Tx) error {
+		if err := tx.RegisterRole("guest", "Guest role"); err != nil {
+			return err
+		}
+		if err := tx.Permit("guest", usersPerm, "unknown"); err == nil {
+			t.Fatalf("permitting unknown action should fail in transaction")
+		}
+		if err := tx.AddParent("user", "admin"); err == nil {
+			t.Fatalf("circular parent should fail in transaction")
+		}
+		return fmt.Errorf("abort")
+	})
+	if err == nil || R.IsRoleExist("guest") || R.Revision() != revision {
+		t.Fatalf("transaction returning error should be rolled back")
+	}
+
+	err = R.Tx(func(tx *Tx) error {
+		if err := tx.RegisterRole("auditor", "Auditor role"); err != nil {
+			return err
+		}
+		return tx.SetRoleCardinality("auditor", 1, 2)
+	})
+	if err == nil || R.IsRoleExist("auditor") {
+		t.Fatalf("transaction violating cardinality should be rolled back")
+	}
+
+	err = R.Tx(func(tx *Tx) error {
+		if err := tx.Permit("user", usersPerm, Delete); err != nil {
+			return err
+		}
+		// instance is changed concurrently, transaction is validated again
+		return R.RemoveRole("admin")
+	})
+	if err != nil || !R.IsGrantInheritedStr("user", "users", Delete) || R.IsRoleExist("admin") {
+		t.Fatalf("transaction should be committed after instance is changed, err: %v", err)
+	}
+	err = R.Tx(func(tx *Tx) error {
+		if err := tx.Permit("user", usersPerm, Create); err != nil {
+			return err
+		}
+		return R.RemoveRole("user")
+	})
+	if err == nil || R.IsRoleExist("user") {
+		t.Fatalf("transaction should fail if its role is removed concurrently")
+	}
+}
+
+func TestTxIsolation(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	usersPerm, _ := R.RegisterPermission("users", "User resource", CRUD)
+	R.RegisterRole("user", "User role")
+
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			e := R.Explain([]string{"user"}, "users", Read, Update)
+			if e.Actions[0].Allowed != e.Actions[1].Allowed {
+				t.Errorf("partial state of transaction is seen")
+				return
+			}
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		R.Tx(func(tx *Tx) error {
+			if i%2 == 0 {
+				tx.Permit("user", usersPerm, Read)
+				return tx.Permit("user", usersPerm, Update)
+			}
+			tx.Revoke("user", usersPerm, Read)
+			return tx.Revoke("user", usersPerm, Update)
+		})
+	}
+	close(done)
+	wg.Wait()
+}
+
+func TestTxConcurrentMutations(t *testing.T) {
+	for i := 0; i < 10; i++ {
+		R := New(nil) //NewConsoleLogger()
+		R.RegisterRole("lead", "Lead role")
+		R.SetRoleCardinality("lead", 0, 1)
+		// bob is assigned concurrently once the commit starts writing to admin log, writes are slowed down so bob
+		// has time to interleave with the commit
+		committing := make(chan struct{})
+		var once sync.Once
+		R.EnableAdminLog(writerFunc(func(p []byte) (int, error) {
+			once.Do(func() { close(committing) })
+			time.Sleep(2 * time.Millisecond)
+			return len(p), nil
+		}))
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-committing
+			R.AssignRole("bob", "lead")
+		}()
+
+		err := R.Tx(func(tx *Tx) error {
+			for j := 0; j < 5; j++ {
+				if err := tx.RegisterRole(fmt.Sprintf("deputy%d", j), "Deputy role"); err != nil {
+					return err
+				}
+			}
+			return tx.AssignRole("alice", "lead")
+		})
+		wg.Wait()
+		if err != nil {
+			t.Fatalf("validated transaction should be committed, err: %v", err)
+		}
+		if holders := R.RoleHolders("lead"); !R.IsRoleExist("deputy4") || len(holders) != 1 || holders[0] != "alice" {
+			t.Fatalf("committed transaction should be applied entirely, holders: %v", holders)
+		}
+	}
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func TestTxLoadJSON(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	R.RegisterPermission("users", "User resource", CRUD)
+	done := make(chan error)
+	go func() {
+		done <- R.Tx(func(tx *Tx) error {
+			return tx.RegisterRole("admin", "Admin role")
+		})
+	}()
+	doc := `{"roles": [{"id": "user", "grants": {"users": ["read"]}, "parents": []}], "subjects": [{"id": "alice", "roles": ["user"]}]}`
+	if err := R.LoadJSONStrict(strings.NewReader(doc)); err != nil {
+		t.Fatalf("can not load policy, err: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("transaction should be committed, err: %v", err)
+	}
+	if !R.IsRoleExist("admin") || !R.IsSubjectGrantedStr("alice", "users", Read) {
+		t.Fatalf("both transaction and loaded policy should be applied")
+	}
+}
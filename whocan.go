@@ -0,0 +1,121 @@
+package rbac
+
+import (
+	"sort"
+	"sync"
+)
+
+// grantKey is key of reverse grant index
+type grantKey struct {
+	permID string
+	action Action
+}
+
+// Holders is the result of WhoCan
+type Holders struct {
+	Permission string `json:"permission"`
+	Action     Action `json:"action"`
+	// Direct are roles which are granted the action directly
+	Direct []string `json:"direct"`
+	// Inherited are roles which inherit the action from their ancestors
+	Inherited []string `json:"inherited"`
+	// Subjects are subjects which any of Direct or Inherited roles is assigned to
+	Subjects []string `json:"subjects"`
+}
+
+// WhoCan returns global roles and subjects which can perform action of permission, sorted by ID. Roles are looked
+// up by a reverse index of grants, so it does not iterate over all roles. Denies, wildcards, implied actions and
+// hierarchical permissions are considered, conditional, instance and scheduled grants are not.
+func (r *RBAC) WhoCan(permID string, action Action) *Holders {
+	r.txMu.RLock()
+	defer r.txMu.RUnlock()
+	res := &Holders{Permission: permID, Action: action, Direct: []string{}, Inherited: []string{}, Subjects: []string{}}
+	q := r.newQuery(permID, action)
+	if len(q.actions) != 1 {
+		log.Errorf("WhoCan expects a single action, %s is expanded to %v", action, q.actions)
+		return res
+	}
+	seen := map[*Role]bool{}
+	queue := []*Role{}
+	for _, pID := range append(append([]string{}, q.perms...), AnyPermission) {
+		for _, a := range append([]Action{q.actions[0], AnyAction}, q.implied[0]...) {
+			if roles, ok := r.grantIndex.Load(grantKey{pID, a}); ok {
+				roles.(*sync.Map).Range(func(k, _ interface{}) bool {
+					if role := k.(*Role); !seen[role] {
+						seen[role] = true
+						queue = append(queue, role)
+					}
+					return true
+				})
+			}
+		}
+	}
+	direct := map[*Role]bool{}
+	for _, role := range queue {
+		direct[role] = true
+	}
+	for len(queue) > 0 {
+		role := queue[0]
+		queue = queue[1:]
+		role.children.Range(func(k, _ interface{}) bool {
+			if child := k.(*Role); !seen[child] {
+				seen[child] = true
+				queue = append(queue, child)
+			}
+			return true
+		})
+		if role.Domain != "" || !role.isGrantInheritedQ(q) {
+			continue
+		}
+		if direct[role] && role.hasGrant(q, 0) {
+			res.Direct = append(res.Direct, role.ID)
+		} else {
+			res.Inherited = append(res.Inherited, role.ID)
+		}
+		for _, subjectID := range r.RoleHolders(role.ID) {
+			if !hasString(res.Subjects, subjectID) {
+				res.Subjects = append(res.Subjects, subjectID)
+			}
+		}
+	}
+	sort.Strings(res.Direct)
+	sort.Strings(res.Inherited)
+	sort.Strings(res.Subjects)
+	return res
+}
+
+// indexGrants adds role to reverse grant index for actions of permission
+func (r *RBAC) indexGrants(role *Role, permID string, actions ...Action) {
+	for _, a := range actions {
+		roles, _ := r.grantIndex.LoadOrStore(grantKey{permID, a}, &sync.Map{})
+		roles.(*sync.Map).Store(role, nil)
+	}
+}
+
+// unindexGrants removes role from reverse grant index for actions of permission
+func (r *RBAC) unindexGrants(role *Role, permID string, actions ...Action) {
+	for _, a := range actions {
+		if roles, ok := r.grantIndex.Load(grantKey{permID, a}); ok {
+			roles.(*sync.Map).Delete(role)
+		}
+	}
+}
+
+// unindexRole removes role from reverse grant index and from children of its parents
+func (r *RBAC) unindexRole(role *Role) {
+	for permID, actions := range role.getGrants() {
+		r.unindexGrants(role, permID, actions...)
+	}
+	for _, p := range role.Parents() {
+		p.children.Delete(role)
+	}
+}
+
+// reindex builds reverse grant index from all roles
+func (r *RBAC) reindex() {
+	for _, role := range append(r.Roles(), r.domainRoles()...) {
+		for permID, actions := range role.getGrants() {
+			r.indexGrants(role, permID, actions...)
+		}
+	}
+}
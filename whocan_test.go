@@ -0,0 +1,76 @@
+package rbac
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWhoCan(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	invoicesPerm, err := R.RegisterPermission("invoices", "Invoice resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register invoices permission, err: %v", err)
+	}
+	accountantRole, _ := R.RegisterRole("accountant", "Accountant role")
+	managerRole, _ := R.RegisterRole("manager", "Manager role")
+	ceoRole, _ := R.RegisterRole("ceo", "CEO role")
+	internRole, _ := R.RegisterRole("intern", "Intern role")
+	rootRole, _ := R.RegisterRole("root", "Root role")
+	managerRole.AddParent(accountantRole)
+	ceoRole.AddParent(managerRole)
+	internRole.AddParent(accountantRole)
+	R.Permit(accountantRole.ID, invoicesPerm, Delete, Read)
+	R.Deny(internRole.ID, invoicesPerm, Delete)
+	R.Permit(rootRole.ID, R.GetPermission(AnyPermission), AnyAction)
+	R.AssignRole("alice", ceoRole.ID)
+	R.AssignRole("bob", internRole.ID)
+	R.AssignRole("carol", accountantRole.ID)
+
+	h := R.WhoCan(invoicesPerm.ID, Delete)
+	if !reflect.DeepEqual(h.Direct, []string{"accountant", "root"}) {
+		t.Fatalf("direct holders are not valid, got %v", h.Direct)
+	}
+	if !reflect.DeepEqual(h.Inherited, []string{"ceo", "manager"}) {
+		t.Fatalf("inheriting holders are not valid, got %v", h.Inherited)
+	}
+	if !reflect.DeepEqual(h.Subjects, []string{"alice", "carol"}) {
+		t.Fatalf("subjects are not valid, got %v", h.Subjects)
+	}
+
+	R.Permit(ceoRole.ID, invoicesPerm, Delete)
+	if h = R.WhoCan(invoicesPerm.ID, Delete); !reflect.DeepEqual(h.Direct, []string{"accountant", "ceo", "root"}) {
+		t.Fatalf("ceo should be a direct holder after permit, got %v", h.Direct)
+	}
+	R.Revoke(accountantRole.ID, invoicesPerm, Delete)
+	h = R.WhoCan(invoicesPerm.ID, Delete)
+	if !reflect.DeepEqual(h.Direct, []string{"ceo", "root"}) || len(h.Inherited) != 0 {
+		t.Fatalf("accountant should not be a holder after revoke, got %v %v", h.Direct, h.Inherited)
+	}
+	if h = R.WhoCan(invoicesPerm.ID, Read); !reflect.DeepEqual(h.Inherited, []string{"ceo", "intern", "manager"}) {
+		t.Fatalf("inheriting read holders are not valid, got %v", h.Inherited)
+	}
+	managerRole.RemoveParent(accountantRole)
+	if h = R.WhoCan(invoicesPerm.ID, Read); !reflect.DeepEqual(h.Inherited, []string{"intern"}) {
+		t.Fatalf("manager and ceo should not inherit read after parent removal, got %v", h.Inherited)
+	}
+	R.RemoveRole(rootRole.ID)
+	if h = R.WhoCan(invoicesPerm.ID, Read); !reflect.DeepEqual(h.Direct, []string{"accountant"}) {
+		t.Fatalf("removed role should not be a holder, got %v", h.Direct)
+	}
+	if h = R.Clone(true).WhoCan(invoicesPerm.ID, Read); !reflect.DeepEqual(h.Direct, []string{"accountant"}) {
+		t.Fatalf("cloned instance should have reverse index, got %v", h.Direct)
+	}
+
+	// Grants of a clone are indexed by the clone only
+	C := R.Clone(true)
+	C.Permit(accountantRole.ID, invoicesPerm, Delete)
+	if h = C.WhoCan(invoicesPerm.ID, Delete); !reflect.DeepEqual(h.Direct, []string{"accountant", "ceo"}) {
+		t.Fatalf("grant of cloned instance should be indexed by it, got %v", h.Direct)
+	}
+	if h = R.WhoCan(invoicesPerm.ID, Delete); !reflect.DeepEqual(h.Direct, []string{"ceo"}) {
+		t.Fatalf("grant of cloned instance should not change the instance, got %v", h.Direct)
+	}
+	if R.IsGranted(accountantRole.ID, invoicesPerm, Delete) {
+		t.Fatalf("grant of cloned instance should not be granted by the instance")
+	}
+}
@@ -0,0 +1,111 @@
+package rbac
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWildcard(t *testing.T) {
+	R := New(nil) //NewConsoleLogger()
+	ApproveAction := Action("approve")
+	usersPerm, err := R.RegisterPermission("users", "User resource", CRUD)
+	if err != nil {
+		t.Fatalf("can not register users permission, err: %v", err)
+	}
+	postPerm, err := R.RegisterPermission("post", "Post resource", CRUD, ApproveAction)
+	if err != nil {
+		t.Fatalf("can not register post permission, err: %v", err)
+	}
+	if _, err = R.RegisterPermission(AnyPermission, "All", Read); err == nil {
+		t.Fatalf("should not be able to register wildcard permission")
+	}
+	allPerm := R.GetPermission(AnyPermission)
+	if allPerm == nil || allPerm.ID != AnyPermission {
+		t.Fatalf("wildcard permission should be returned for %s", AnyPermission)
+	}
+	if len(R.Permissions()) != 2 {
+		t.Fatalf("wildcard permission should not be listed in permissions, got %d", len(R.Permissions()))
+	}
+
+	superRole, _ := R.RegisterRole("superadmin", "Super admin role")
+	editorRole, _ := R.RegisterRole("editor", "Editor role")
+	readerRole, _ := R.RegisterRole("reader", "Reader role")
+
+	if err = R.Permit(superRole.ID, allPerm, AnyAction); err != nil {
+		t.Fatalf("can not permit everything to role %s, err: %v", superRole.ID, err)
+	}
+	if err = R.Permit(editorRole.ID, postPerm, AnyAction); err != nil {
+		t.Fatalf("can not permit all post actions to role %s, err: %v", editorRole.ID, err)
+	}
+	if err = R.Permit(readerRole.ID, allPerm, Read); err != nil {
+		t.Fatalf("can not permit read on all permissions to role %s, err: %v", readerRole.ID, err)
+	}
+	if err = R.Permit(readerRole.ID, allPerm, "unknown"); err == nil {
+		t.Fatalf("Permit should fail with action not registered for any permission")
+	}
+
+	if !R.IsGranted(superRole.ID, usersPerm, Create, Read, Update, Delete) {
+		t.Fatalf("superadmin role should have users.crud")
+	}
+	if !R.IsGranted(superRole.ID, postPerm, AnyAction) {
+		t.Fatalf("superadmin role should have all post actions")
+	}
+	if !R.IsGranted(editorRole.ID, postPerm, ApproveAction, Delete) {
+		t.Fatalf("editor role should have post.approve and post.delete")
+	}
+	if R.IsGranted(editorRole.ID, usersPerm, Read) {
+		t.Fatalf("editor role should not have users.read")
+	}
+	if !R.IsGranted(readerRole.ID, usersPerm, Read) || !R.IsGranted(readerRole.ID, postPerm, Read) {
+		t.Fatalf("reader role should have read on all permissions")
+	}
+	if R.IsGranted(readerRole.ID, postPerm, AnyAction) {
+		t.Fatalf("reader role should not have all post actions")
+	}
+
+	// Permissions registered later are covered too
+	reportsPerm, err := R.RegisterPermission("reports", "Reports", Read, Download)
+	if err != nil {
+		t.Fatalf("can not register reports permission, err: %v", err)
+	}
+	if !R.IsGranted(superRole.ID, reportsPerm, Download) {
+		t.Fatalf("superadmin role should have reports.download")
+	}
+	childRole, _ := R.RegisterRole("child", "Child role")
+	if err = childRole.AddParent(superRole); err != nil {
+		t.Fatalf("adding parent role failed with: %v", err)
+	}
+	if !R.IsGrantInherited(childRole.ID, reportsPerm, Download, Read) {
+		t.Fatalf("child role should inherit reports.download and reports.read")
+	}
+
+	// Wildcard denies
+	if err = R.Deny(childRole.ID, usersPerm, AnyAction); err != nil {
+		t.Fatalf("can not deny all users actions, err: %v", err)
+	}
+	if R.IsGrantInherited(childRole.ID, usersPerm, Read) {
+		t.Fatalf("child role should not have users.read as all users actions are denied")
+	}
+	if !R.IsGrantInherited(childRole.ID, postPerm, Read) {
+		t.Fatalf("child role should have post.read")
+	}
+
+	// Round trip
+	var buf bytes.Buffer
+	if err = R.SaveJSON(&buf); err != nil {
+		t.Fatalf("unable to save to json, err:%v", err)
+	}
+	R2 := R.Clone(false)
+	if err = R2.LoadJSON(&buf); err != nil {
+		t.Fatalf("unable to load from json, err:%v", err)
+	}
+	if !R2.IsGrantedStr(superRole.ID, reportsPerm.ID, Download) {
+		t.Fatalf("loaded superadmin role should have reports.download")
+	}
+	if !R2.IsGrantedStr(readerRole.ID, usersPerm.ID, Read) {
+		t.Fatalf("loaded reader role should have users.read")
+	}
+	if R2.IsGrantInheritedStr(childRole.ID, usersPerm.ID, Read) {
+		t.Fatalf("loaded child role should not have users.read")
+	}
+}